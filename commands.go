@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"redisDatabase/hybridsystem"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func serve(e *env, args []string) error {
+	fs := e.flagSet("serve", "", "Runs the HTTP API until interrupted. The resource modules and their path\n"+
+		"prefixes come from the configuration (-mysql-users-prefix and friends);\n"+
+		"--mode picks a preset instead: mysql serves /users from MySQL, mongo serves\n"+
+		"/users from MongoDB and hybrid serves /users from MySQL and /persons from\n"+
+		"MongoDB, all cached in Redis.")
+	mode := fs.String("mode", "", "module preset: mysql, mongo or hybrid (default: the configured modules)")
+	cfg, logger, err := e.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError("unexpected arguments " + strings.Join(fs.Args(), " "))
+	}
+	if *mode != "" {
+		if err := oneOf("mode", *mode, "mysql", "mongo", "hybrid"); err != nil {
+			return err
+		}
+		cfg.Modules = hybridsystem.ModulePresets[*mode]
+	}
+	slog.SetDefault(logger)
+	return hybridsystem.Serve(cfg, logger)
+}
+
+func migrate(e *env, args []string) error {
+	fs := e.flagSet("migrate", "up | down [steps] | version",
+		"Applies the pending MySQL migrations, reverts the latest ones (one unless\n"+
+			"steps says otherwise) or prints the current schema version.")
+	cfg, logger, err := e.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		return usageError("missing up, down or version")
+	}
+	if err := oneOf("action", args[0], "up", "down", "version"); err != nil {
+		return err
+	}
+	steps := 1
+	switch {
+	case args[0] == "down" && len(args) == 2:
+		if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+			return usageError("steps must be a positive number")
+		}
+	case len(args) > 1:
+		return usageError("unexpected arguments " + strings.Join(args[1:], " "))
+	}
+
+	mySQLInstance, err := hybridsystem.NewMySQLInstance1(cfg.MySQL)
+	if err != nil {
+		return err
+	}
+	defer mySQLInstance.Close()
+	migrator, err := hybridsystem.NewMigrator(mySQLInstance.DB, logger)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		_, err = migrator.Up(ctx)
+	case "down":
+		_, err = migrator.Down(ctx, steps)
+	default:
+		var version int
+		if version, err = migrator.Version(ctx); err == nil {
+			fmt.Fprintln(e.stdout, version)
+		}
+	}
+	return err
+}
+
+func dupEmails(e *env, args []string) error {
+	fs := e.flagSet("dupemails", "", "Lists the emails that, compared case-insensitively, belong to more than\n"+
+		"one record, one line per email: entity, email and ids. Resolve them before\n"+
+		"the unique email indexes can be created. Exits with 3 if any are found.")
+	entityName := fs.String("entity", "all", "entity to check: "+entityNames()+" or all")
+	cfg, _, err := e.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	selected, err := selectEntities(*entityName)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	found := false
+	for _, ent := range selected {
+		recs, err := ent.open(cfg)
+		if err != nil {
+			return err
+		}
+		groups, err := recs.unique().FindDuplicateEmails(ctx)
+		recs.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", ent.name, err)
+		}
+		for _, g := range groups {
+			fmt.Fprintf(e.stdout, "%s\t%s\t%s\n", ent.name, g.Email, strings.Join(g.IDs, ","))
+		}
+		found = found || len(groups) > 0
+	}
+	if found {
+		return errFound
+	}
+	return nil
+}
+
+func mongoSchema(e *env, args []string) error {
+	fs := e.flagSet("mongo-schema", "check | apply [collection...]",
+		"Compares the MongoDB person collections (persons unless named) with their\n"+
+			"declared indexes and validators, or creates what is missing. Changed and\n"+
+			"undeclared indexes are only reported. Exits with 3 if drift remains.")
+	cfg, _, err := e.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	args = fs.Args()
+	if len(args) == 0 {
+		return usageError("missing check or apply")
+	}
+	if err := oneOf("action", args[0], "check", "apply"); err != nil {
+		return err
+	}
+	collections := args[1:]
+	if len(collections) == 0 {
+		collections = []string{"persons"}
+	}
+	mongoInstance, err := hybridsystem.NewMongoInstance1(cfg.Mongo)
+	if err != nil {
+		return err
+	}
+	defer mongoInstance.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	drifted := false
+	for _, name := range collections {
+		spec := hybridsystem.PersonCollection(name, cfg.Validation, cfg.Mongo.SchemaValidation)
+		var drift hybridsystem.Drift
+		if args[0] == "apply" {
+			drift, err = hybridsystem.EnsureCollection(ctx, mongoInstance.DB, spec)
+		} else {
+			drift, err = hybridsystem.CheckCollection(ctx, mongoInstance.DB, spec)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		fmt.Fprintln(e.stdout, drift)
+		drifted = drifted || !drift.Empty()
+	}
+	if drifted {
+		return errFound
+	}
+	return nil
+}
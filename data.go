@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"time"
+)
+
+// entity is a kind of record the data commands work on, one per resource
+// module: MySQL users, MongoDB persons and MongoDB users.
+type entity struct {
+	name       string
+	keys       hybridsystem.KeyBuilder
+	collection string // MongoDB collection, "" for MySQL
+}
+
+var entities = []entity{
+	{name: "users", keys: hybridsystem.UserKeys},
+	{name: "persons", keys: hybridsystem.PersonKeys, collection: "persons"},
+	{name: "mongo-users", keys: hybridsystem.MongoUserKeys, collection: "users"},
+}
+
+func entityNames() string {
+	names := make([]string, len(entities))
+	for i, ent := range entities {
+		names[i] = ent.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// selectEntities resolves an --entity flag; "all" selects every entity.
+func selectEntities(name string) ([]entity, error) {
+	if name == "all" {
+		return entities, nil
+	}
+	for _, ent := range entities {
+		if ent.name == name {
+			return []entity{ent}, nil
+		}
+	}
+	return nil, usageError(fmt.Sprintf("unknown entity %q, want %s", name, entityNames()))
+}
+
+// records runs the data commands on one entity, hiding whether its records
+// are User2 or Person.
+type records interface {
+	io.Closer
+	export(ctx context.Context, w io.Writer) (int, error)
+	importFrom(ctx context.Context, r io.Reader, skipDuplicates bool) (hybridsystem.ImportResult, error)
+	seed(ctx context.Context, count int, domain string) (int, error)
+	warm(ctx context.Context, backend hybridsystem.CacheBackend, limit int) (int, error)
+	unique() hybridsystem.UniqueEmails
+}
+
+type typedRecords[T any, P hybridsystem.Entity[T]] struct {
+	io.Closer
+	store interface {
+		hybridsystem.UserStore[T]
+		hybridsystem.UniqueEmails
+	}
+	keys      hybridsystem.KeyBuilder
+	validate  func(T) error
+	newRecord func(name, email string) T
+}
+
+// open connects to the database holding the entity.
+func (ent entity) open(cfg hybridsystem.Config) (records, error) {
+	if ent.collection == "" {
+		m, err := hybridsystem.NewMySQLInstance1(cfg.MySQL)
+		if err != nil {
+			return nil, err
+		}
+		return &typedRecords[hybridsystem.User2, *hybridsystem.User2]{
+			Closer:    m,
+			store:     hybridsystem.NewMySQLUserStore(m),
+			keys:      ent.keys,
+			validate:  hybridsystem.UserValidator(cfg.Validation).Validate,
+			newRecord: func(name, email string) hybridsystem.User2 { return hybridsystem.User2{Name: name, Email: email} },
+		}, nil
+	}
+	m, err := hybridsystem.NewMongoInstance1(cfg.Mongo)
+	if err != nil {
+		return nil, err
+	}
+	store := &hybridsystem.MongoPersonStore{Collection: m.DB.Collection(ent.collection)}
+	if cfg.Mongo.Audit {
+		store.Audit = m.DB.Collection(ent.collection + "_audit")
+	}
+	return &typedRecords[hybridsystem.Person, *hybridsystem.Person]{
+		Closer:    m,
+		store:     store,
+		keys:      ent.keys,
+		validate:  hybridsystem.PersonValidator(cfg.Validation).Validate,
+		newRecord: func(name, email string) hybridsystem.Person { return hybridsystem.Person{Name: name, Email: email} },
+	}, nil
+}
+
+func (t *typedRecords[T, P]) export(ctx context.Context, w io.Writer) (int, error) {
+	return hybridsystem.Export[T](ctx, t.store, w)
+}
+
+func (t *typedRecords[T, P]) importFrom(ctx context.Context, r io.Reader, skipDuplicates bool) (hybridsystem.ImportResult, error) {
+	return hybridsystem.Import[T](ctx, t.store, r, t.validate, skipDuplicates)
+}
+
+func (t *typedRecords[T, P]) seed(ctx context.Context, count int, domain string) (int, error) {
+	return hybridsystem.Seed[T](ctx, t.store, count, domain, t.newRecord)
+}
+
+func (t *typedRecords[T, P]) warm(ctx context.Context, backend hybridsystem.CacheBackend, limit int) (int, error) {
+	return hybridsystem.WarmCache[T, P](ctx, hybridsystem.NewCache[T](backend, t.keys, hybridsystem.DefaultCacheTTL), t.store, limit)
+}
+
+func (t *typedRecords[T, P]) unique() hybridsystem.UniqueEmails {
+	return t.store
+}
+
+func seed(e *env, args []string) error {
+	fs := e.flagSet("seed", "", "Creates made-up records with valid names and unique emails.")
+	entityName := fs.String("entity", "users", "entity to seed: "+entityNames())
+	count := fs.Int("count", 10, "number of records to create")
+	domain := fs.String("domain", "", "email domain (default: the first allowed domain, or example.com)")
+	cfg, _, err := e.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	selected, err := selectEntities(*entityName)
+	if err != nil || len(selected) != 1 {
+		return usageError("seed needs a single entity: " + entityNames())
+	}
+	if *count <= 0 {
+		return usageError("count must be positive")
+	}
+	if *domain == "" {
+		*domain = "example.com"
+		if len(cfg.Validation.AllowedEmailDomains) > 0 {
+			*domain = strings.TrimPrefix(cfg.Validation.AllowedEmailDomains[0], "@")
+		}
+	}
+
+	recs, err := selected[0].open(cfg)
+	if err != nil {
+		return err
+	}
+	defer recs.Close()
+	n, err := recs.seed(context.Background(), *count, *domain)
+	fmt.Fprintf(e.stdout, "created %d %s\n", n, selected[0].name)
+	return err
+}
+
+func export(e *env, args []string) error {
+	fs := e.flagSet("export", "", "Writes every record of an entity as one JSON object per line, in id order.")
+	entityName := fs.String("entity", "users", "entity to export: "+entityNames())
+	out := fs.String("out", "-", "file to write, - for standard output")
+	cfg, _, err := e.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	selected, err := selectEntities(*entityName)
+	if err != nil || len(selected) != 1 {
+		return usageError("export needs a single entity: " + entityNames())
+	}
+
+	recs, err := selected[0].open(cfg)
+	if err != nil {
+		return err
+	}
+	defer recs.Close()
+	w := e.stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	n, err := recs.export(context.Background(), w)
+	fmt.Fprintf(e.stderr, "exported %d %s\n", n, selected[0].name)
+	return err
+}
+
+func importCommand(e *env, args []string) error {
+	fs := e.flagSet("import", "", "Creates a record for every JSON line, as written by export. Ids in the\n"+
+		"input are ignored and records get new ones. Every record is validated.")
+	entityName := fs.String("entity", "users", "entity to import into: "+entityNames())
+	in := fs.String("in", "-", "file to read, - for standard input")
+	skipDuplicates := fs.Bool("skip-duplicates", false, "skip records whose email is taken instead of stopping")
+	cfg, _, err := e.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	selected, err := selectEntities(*entityName)
+	if err != nil || len(selected) != 1 {
+		return usageError("import needs a single entity: " + entityNames())
+	}
+
+	r := e.stdin
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	recs, err := selected[0].open(cfg)
+	if err != nil {
+		return err
+	}
+	defer recs.Close()
+	result, err := recs.importFrom(context.Background(), r, *skipDuplicates)
+	fmt.Fprintf(e.stdout, "created %d, skipped %d %s\n", result.Created, result.Skipped, selected[0].name)
+	return err
+}
+
+func cacheCommand(e *env, args []string) error {
+	fs := e.flagSet("cache", "flush | inspect <id> | warm | cleanup-legacy",
+		"flush deletes the cached records, stale copies and tombstones of the\n"+
+			"entity (all entities by default). inspect shows what is cached for one id.\n"+
+			"warm loads the first --limit records into the cache. cleanup-legacy deletes\n"+
+			"the keys older versions of the service left behind: bare-id records from\n"+
+			"before keys were namespaced and keys of an older schema version (all\n"+
+			"entities by default); with --dry-run it only counts them.")
+	entityName := fs.String("entity", "", "entity: "+entityNames()+" or all (default all for flush and cleanup-legacy, users otherwise)")
+	limit := fs.Int("limit", 1000, "number of records warm loads")
+	dryRun := fs.Bool("dry-run", false, "count the keys cleanup-legacy would delete, delete none")
+	action := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+	cfg, _, err := e.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	rest := fs.Args()
+	if action == "" && len(rest) > 0 {
+		action, rest = rest[0], rest[1:]
+	}
+	if action == "" {
+		return usageError("missing flush, inspect, warm or cleanup-legacy")
+	}
+	if err := oneOf("action", action, "flush", "inspect", "warm", "cleanup-legacy"); err != nil {
+		return err
+	}
+	if *entityName == "" {
+		*entityName = "users"
+		if action == "flush" || action == "cleanup-legacy" {
+			*entityName = "all"
+		}
+	}
+	selected, err := selectEntities(*entityName)
+	if err != nil {
+		return err
+	}
+	if (action == "inspect" || action == "warm") && len(selected) != 1 {
+		return usageError(action + " needs a single entity: " + entityNames())
+	}
+	if (action == "inspect") != (len(rest) == 1) || len(rest) > 1 {
+		return usageError("inspect takes exactly one id, the other actions none")
+	}
+	if action == "warm" && *limit <= 0 {
+		return usageError("limit must be positive")
+	}
+	if *dryRun && action != "cleanup-legacy" {
+		return usageError("dry-run is only for cleanup-legacy")
+	}
+
+	redisInstance, err := hybridsystem.NewRedisInstance1(cfg.Redis)
+	if err != nil {
+		return err
+	}
+	defer redisInstance.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	builders := make([]hybridsystem.KeyBuilder, len(selected))
+	for i, ent := range selected {
+		builders[i] = ent.keys
+	}
+	switch action {
+	case "flush":
+		n, err := hybridsystem.FlushKeys(ctx, redisInstance.Client, builders...)
+		fmt.Fprintf(e.stdout, "deleted %d keys\n", n)
+		return err
+	case "cleanup-legacy":
+		n, err := hybridsystem.CleanupLegacyKeys(ctx, redisInstance.Client, *dryRun, builders...)
+		if *dryRun {
+			fmt.Fprintf(e.stdout, "would delete %d keys\n", n)
+		} else {
+			fmt.Fprintf(e.stdout, "deleted %d keys\n", n)
+		}
+		return err
+	case "inspect":
+		entry, err := hybridsystem.InspectCacheEntry(ctx, redisInstance.Client, selected[0].keys.Key(rest[0]))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(e.stdout, "key\t%s\nstate\t%s\n", entry.Key, entry.State)
+		if entry.State != hybridsystem.CacheEntryMissing {
+			fmt.Fprintf(e.stdout, "ttl\t%s\n", entry.TTL)
+		}
+		if entry.StaleTTL > 0 {
+			fmt.Fprintf(e.stdout, "stale ttl\t%s\n", entry.StaleTTL)
+		}
+		if entry.Value != nil {
+			fmt.Fprintf(e.stdout, "value\t%s\n", entry.Value)
+		}
+		return nil
+	}
+	recs, err := selected[0].open(cfg)
+	if err != nil {
+		return err
+	}
+	defer recs.Close()
+	n, err := recs.warm(ctx, &hybridsystem.RedisBackend{Client: redisInstance.Client}, *limit)
+	fmt.Fprintf(e.stdout, "cached %d %s\n", n, selected[0].name)
+	return err
+}
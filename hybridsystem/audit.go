@@ -0,0 +1,135 @@
+package hybridsystem
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// ActorHeader names who a request acts for. There is no authentication in
+// front of the API yet, so it is taken as given; requests without it are
+// audited as AnonymousActor.
+const ActorHeader = "X-Actor"
+
+const (
+	// AnonymousActor is the actor of requests without an ActorHeader.
+	AnonymousActor = "anonymous"
+	// SystemActor is the actor of writes made outside any request, such
+	// as the trash purge.
+	SystemActor = "system"
+)
+
+// The operations of an AuditEntry.
+const (
+	AuditCreate     = "create"
+	AuditUpdate     = "update"
+	AuditDelete     = "delete"
+	AuditSoftDelete = "soft_delete"
+	AuditRestore    = "restore"
+	AuditPurge      = "purge"
+)
+
+// AuditEntry records one write of a record: who made it, in which request,
+// and the fields it changed. Version is the record's version after the write,
+// 0 once it is deleted. The stores append entries in the same transaction as
+// the write itself and never change them afterwards.
+type AuditEntry struct {
+	RecordID  string            `json:"record_id" bson:"record_id"`
+	Op        string            `json:"op" bson:"op"`
+	Actor     string            `json:"actor" bson:"actor"`
+	RequestID string            `json:"request_id,omitempty" bson:"request_id,omitempty"`
+	At        time.Time         `json:"at" bson:"at"`
+	Version   int64             `json:"version" bson:"version"`
+	Changes   map[string]Change `json:"changes" bson:"changes"`
+}
+
+// Change is the value of a field before and after a write. A field the
+// record did not have before (or no longer has) leaves that side out.
+type Change struct {
+	Before any `json:"before,omitempty" bson:"before,omitempty"`
+	After  any `json:"after,omitempty" bson:"after,omitempty"`
+}
+
+// AuditLog is implemented by stores that keep an audit log. History returns
+// the entries of a record oldest first, also once it has been deleted, and
+// ErrNotFound if it has none.
+type AuditLog interface {
+	History(ctx context.Context, id string) ([]AuditEntry, error)
+}
+
+// Actor is middleware that puts the ActorHeader of a request in its context
+// for the audit log, see ActorFrom. It follows the rules of request ids.
+func Actor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := r.Header.Get(ActorHeader)
+		if !validRequestID(actor) {
+			actor = AnonymousActor
+		}
+		next.ServeHTTP(w, r.WithContext(WithActor(r.Context(), actor)))
+	})
+}
+
+// WithActor returns ctx with the actor its writes are audited as.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// ActorFrom returns the actor set by Actor or WithActor, and SystemActor
+// outside of a request.
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey).(string); ok {
+		return actor
+	}
+	return SystemActor
+}
+
+// newAuditEntry describes a write of a record that went from before to
+// after; before is nil for a create and after nil for a delete.
+func newAuditEntry[T any, P Entity[T]](ctx context.Context, op string, before, after *T) AuditEntry {
+	entry := AuditEntry{
+		Op:        op,
+		Actor:     ActorFrom(ctx),
+		RequestID: RequestIDFrom(ctx),
+		At:        stamp(),
+		Changes:   diffRecords(before, after),
+	}
+	if after != nil {
+		entry.RecordID = P(after).Key()
+		entry.Version = P(after).CurrentVersion()
+	} else if before != nil {
+		entry.RecordID = P(before).Key()
+	}
+	return entry
+}
+
+// diffRecords compares the JSON form of two records field by field.
+func diffRecords[T any](before, after *T) map[string]Change {
+	from, to := recordFields(before), recordFields(after)
+	changes := map[string]Change{}
+	for name, value := range from {
+		if other, ok := to[name]; !ok || !reflect.DeepEqual(value, other) {
+			changes[name] = Change{Before: value, After: other}
+		}
+	}
+	for name, value := range to {
+		if _, ok := from[name]; !ok {
+			changes[name] = Change{After: value}
+		}
+	}
+	return changes
+}
+
+func recordFields[T any](v *T) map[string]any {
+	fields := map[string]any{}
+	if v == nil {
+		return fields
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(data, &fields)
+	return fields
+}
@@ -0,0 +1,175 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_Audit(t *testing.T) {
+	ctx := hybridsystem.WithActor(context.Background(), "akash")
+	store := hybridsystem.NewMemoryUserStore()
+	store.Create(ctx, &hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com"})
+	store.Update(ctx, "1", &hybridsystem.User2{Name: "Paul", Email: "akash@gmail.com"})
+	store.Update(ctx, "1", &hybridsystem.User2{Name: "Lost", Email: "akash@gmail.com", Version: 1})
+	store.SoftDelete(ctx, "1", 0)
+	store.Restore(ctx, "1")
+	store.Delete(context.Background(), "1")
+
+	entries, err := store.History(ctx, "1")
+	if err != nil {
+		t.Fatalf("history failed: %v", err)
+	}
+	tests := []struct {
+		name        string // description of this test case
+		wantOp      string
+		wantActor   string
+		wantVersion int64
+		wantChanges map[string]hybridsystem.Change
+	}{
+		{
+			name:        "create has no before",
+			wantOp:      hybridsystem.AuditCreate,
+			wantActor:   "akash",
+			wantVersion: 1,
+			wantChanges: map[string]hybridsystem.Change{
+				"id":      {After: float64(1)},
+				"name":    {After: "Akash"},
+				"email":   {After: "akash@gmail.com"},
+				"version": {After: float64(1)},
+			},
+		},
+		{
+			name:        "update records only the changed fields",
+			wantOp:      hybridsystem.AuditUpdate,
+			wantActor:   "akash",
+			wantVersion: 2,
+			wantChanges: map[string]hybridsystem.Change{
+				"name":    {Before: "Akash", After: "Paul"},
+				"version": {Before: float64(1), After: float64(2)},
+			},
+		},
+		{
+			name:        "soft delete sets deleted_at",
+			wantOp:      hybridsystem.AuditSoftDelete,
+			wantActor:   "akash",
+			wantVersion: 3,
+		},
+		{
+			name:        "restore clears deleted_at",
+			wantOp:      hybridsystem.AuditRestore,
+			wantActor:   "akash",
+			wantVersion: 4,
+		},
+		{
+			name:        "delete has no after",
+			wantOp:      hybridsystem.AuditDelete,
+			wantActor:   hybridsystem.SystemActor,
+			wantVersion: 0,
+			wantChanges: map[string]hybridsystem.Change{
+				"id":      {Before: float64(1)},
+				"name":    {Before: "Paul"},
+				"email":   {Before: "akash@gmail.com"},
+				"version": {Before: float64(4)},
+			},
+		},
+	}
+	if len(entries) != len(tests) {
+		t.Fatalf("expected %d entries (the failed update leaves none), got %+v", len(tests), entries)
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := entries[i]
+			if e.RecordID != "1" || e.Op != tt.wantOp || e.Actor != tt.wantActor || e.Version != tt.wantVersion || e.At.IsZero() || !e.At.Equal(e.At.Truncate(time.Millisecond)) {
+				t.Errorf("expected %s by %s at version %d, got %+v", tt.wantOp, tt.wantActor, tt.wantVersion, e)
+			}
+			if tt.wantChanges != nil {
+				// the timestamps are checked below; updates within the
+				// same millisecond leave updated_at as it was
+				changes := maps.Clone(e.Changes)
+				delete(changes, "created_at")
+				delete(changes, "updated_at")
+				got, _ := json.Marshal(changes)
+				want, _ := json.Marshal(tt.wantChanges)
+				if !sameJSON(t, got, string(want)) {
+					t.Errorf("expected changes %s, got %s", want, got)
+				}
+			}
+			if _, ok := e.Changes["created_at"]; ok != (tt.wantOp == hybridsystem.AuditCreate || tt.wantOp == hybridsystem.AuditDelete) {
+				t.Errorf("expected created_at to change only on create and delete, got %+v", e.Changes)
+			}
+			if _, ok := e.Changes["deleted_at"]; ok != (tt.wantOp == hybridsystem.AuditSoftDelete || tt.wantOp == hybridsystem.AuditRestore) {
+				t.Errorf("unexpected deleted_at change in %+v", e.Changes)
+			}
+		})
+	}
+
+	if _, err := store.History(ctx, "2"); !errors.Is(err, hybridsystem.ErrNotFound) {
+		t.Errorf("expected ErrNotFound for a user without history, got %v", err)
+	}
+}
+
+func TestResource_History(t *testing.T) {
+	store := hybridsystem.NewMemoryUserStore()
+	cache := hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)
+	res := &hybridsystem.Resource[hybridsystem.User2, *hybridsystem.User2]{Name: "user", Store: store, Cache: cache, History: store, Validate: hybridsystem.ValidateUser}
+	router, err := hybridsystem.Compose(nil, nil, nil, hybridsystem.Mount{Name: "users", Prefix: "/users", Module: res})
+	if err != nil {
+		t.Fatal(err)
+	}
+	send := func(method, path, body, actor, requestID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if actor != "" {
+			r.Header.Set(hybridsystem.ActorHeader, actor)
+		}
+		if requestID != "" {
+			r.Header.Set(hybridsystem.RequestIDHeader, requestID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := send(http.MethodGet, "/users/1/history", "", "", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 before any write, got %d", w.Code)
+	}
+	send(http.MethodPost, "/users", `{"name":"Akash","email":"akash@gmail.com"}`, "admin@gmail.com", "req-1")
+	send(http.MethodPut, "/users/1", `{"name":"Paul","email":"akash@gmail.com"}`, "", "req-2")
+	send(http.MethodPut, "/users/1", `{"name":"","email":"akash@gmail.com"}`, "admin@gmail.com", "req-3")
+	send(http.MethodDelete, "/users/1", "", "bad actor", "req-4")
+
+	w := send(http.MethodGet, "/users/1/history", "", "", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the history of a deleted user, got %d: %s", w.Code, w.Body)
+	}
+	var page hybridsystem.Page[hybridsystem.AuditEntry]
+	if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct{ op, actor, requestID string }{
+		{hybridsystem.AuditCreate, "admin@gmail.com", "req-1"},
+		{hybridsystem.AuditUpdate, hybridsystem.AnonymousActor, "req-2"},
+		{hybridsystem.AuditDelete, hybridsystem.AnonymousActor, "req-4"},
+	}
+	if len(page.Items) != len(want) {
+		t.Fatalf("expected %d entries (the invalid update leaves none), got %+v", len(want), page.Items)
+	}
+	for i, e := range page.Items {
+		if e.Op != want[i].op || e.Actor != want[i].actor || e.RequestID != want[i].requestID {
+			t.Errorf("entry %d: expected %s by %s in %s, got %+v", i, want[i].op, want[i].actor, want[i].requestID, e)
+		}
+	}
+}
+
+func TestActorFrom(t *testing.T) {
+	if got := hybridsystem.ActorFrom(context.Background()); got != hybridsystem.SystemActor {
+		t.Errorf("expected %s outside a request, got %s", hybridsystem.SystemActor, got)
+	}
+}
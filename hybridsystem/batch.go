@@ -0,0 +1,263 @@
+package hybridsystem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// MaxBatchSize bounds the operations of one batch request.
+const MaxBatchSize = 500
+
+// The operations of a batch.
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// The modes of a batch request. All-or-nothing is the default.
+const (
+	BatchAllOrNothing = "all_or_nothing"
+	BatchBestEffort   = "best_effort"
+)
+
+// ErrNotApplied is the result of the operations of an all-or-nothing batch
+// that were rolled back, or never tried, because another one failed.
+var ErrNotApplied = errors.New("not applied because another operation of the batch failed")
+
+// BatchOp is one operation of a batch. A create stores Record, an update
+// replaces record ID with Record and a delete removes record ID. Version, when
+// not 0, is the version the record must be at, as with DeleteVersion. On
+// success Record is filled in like by Create and Update.
+type BatchOp[T any] struct {
+	Op      string
+	ID      string
+	Version int64
+	Record  *T
+}
+
+// BatchOptions says how a batch is applied. Atomic applies all of its
+// operations or none; SoftDelete moves deleted records to the trash like
+// TrashStore.SoftDelete.
+type BatchOptions struct {
+	Atomic     bool
+	SoftDelete bool
+}
+
+// BatchStore is implemented by stores that can apply many writes in one
+// transaction or round trip. Batch returns the error of each operation, nil
+// for those applied; in atomic mode, once one fails, the others fail with
+// ErrNotApplied. The second result is for failures of the whole batch, such
+// as a lost connection, after which nothing is known to be applied.
+type BatchStore[T any] interface {
+	Batch(ctx context.Context, ops []BatchOp[T], opts BatchOptions) ([]error, error)
+}
+
+// errBatchAborted rolls back the transaction of a batch.
+var errBatchAborted = errors.New("batch aborted")
+
+// notApplied sets ErrNotApplied for the operations of a rolled back batch
+// that did not fail themselves.
+func notApplied(errs []error) {
+	for i := range errs {
+		if errs[i] == nil {
+			errs[i] = ErrNotApplied
+		}
+	}
+}
+
+// BatchRequest is the body of a batch request.
+type BatchRequest struct {
+	Mode       string           `json:"mode"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation is one operation of a BatchRequest. Record is what the body
+// of the single POST or PUT would be; Version stands in for its If-Match.
+type BatchOperation struct {
+	Op      string          `json:"op"`
+	ID      string          `json:"id,omitempty"`
+	Version int64           `json:"version,omitempty"`
+	Record  json.RawMessage `json:"record,omitempty"`
+}
+
+// BatchResponse has one result per operation of a BatchRequest, in order.
+type BatchResponse struct {
+	Mode    string        `json:"mode"`
+	Applied int           `json:"applied"`
+	Failed  int           `json:"failed"`
+	Results []BatchResult `json:"results"`
+}
+
+// BatchResult is the outcome of one operation: the status the single request
+// would have been answered with, and the record written or the problem.
+type BatchResult struct {
+	Index   int      `json:"index"`
+	Op      string   `json:"op"`
+	ID      string   `json:"id,omitempty"`
+	Status  int      `json:"status"`
+	Record  any      `json:"record,omitempty"`
+	Problem *Problem `json:"problem,omitempty"`
+}
+
+// ApplyBatch applies the operations of a BatchRequest through Batch and
+// answers with a BatchResponse, 200 if all of them were applied and 207
+// Multi-Status otherwise. Each operation is checked like the request it
+// stands for, and a record may only be written once per batch; in
+// all-or-nothing mode a single invalid operation leaves the store untouched.
+// The cache entries of the written records are updated in one pipelined
+// round trip.
+func (res *Resource[T, P]) ApplyBatch(w http.ResponseWriter, r *http.Request) {
+	if res.Batch == nil {
+		res.fail(w, r, ErrNotFound)
+		return
+	}
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, ProblemTypeMalformedBody, "request body is not valid JSON: "+err.Error()))
+		return
+	}
+	atomic := true
+	switch req.Mode {
+	case "":
+		req.Mode = BatchAllOrNothing
+	case BatchAllOrNothing:
+	case BatchBestEffort:
+		atomic = false
+	default:
+		WriteProblem(w, r, fieldProblem("mode", "must be "+BatchAllOrNothing+" or "+BatchBestEffort))
+		return
+	}
+	if len(req.Operations) == 0 || len(req.Operations) > MaxBatchSize {
+		WriteProblem(w, r, fieldProblem("operations", fmt.Sprintf("must have 1 to %d operations", MaxBatchSize)))
+		return
+	}
+	AddLogAttrs(r.Context(), slog.String("entity", res.Name), slog.Int("batch_size", len(req.Operations)), slog.String("batch_mode", req.Mode))
+
+	results := make([]BatchResult, len(req.Operations))
+	ops := make([]BatchOp[T], 0, len(req.Operations))
+	index := make([]int, 0, len(req.Operations)) // of ops in the request
+	seen := make(map[string]int)
+	invalid := false
+	for i, o := range req.Operations {
+		op, err := res.batchOp(o)
+		results[i] = BatchResult{Index: i, Op: o.Op, ID: op.ID}
+		if err == nil && op.ID != "" {
+			if first, ok := seen[op.ID]; ok {
+				err = fieldProblem("id", fmt.Sprintf("is already written by operation %d", first))
+			} else {
+				seen[op.ID] = i
+			}
+		}
+		if err != nil {
+			results[i].Problem = res.problem(r, err)
+			invalid = true
+			continue
+		}
+		ops = append(ops, op)
+		index = append(index, i)
+	}
+
+	ctx, cancel := res.writeContext(r)
+	defer cancel()
+	errs := make([]error, len(ops))
+	if invalid && atomic {
+		notApplied(errs)
+	} else if len(ops) > 0 {
+		var err error
+		errs, err = res.Batch.Batch(ctx, ops, BatchOptions{Atomic: atomic, SoftDelete: res.Trash != nil})
+		if err != nil {
+			res.fail(w, r, err)
+			return
+		}
+	}
+
+	written := make(map[string]*T)
+	var deleted []string
+	for k, err := range errs {
+		op, result := ops[k], &results[index[k]]
+		if err != nil {
+			result.Problem = res.problem(r, err)
+			continue
+		}
+		switch op.Op {
+		case BatchCreate:
+			result.Status, result.ID, result.Record = http.StatusCreated, P(op.Record).Key(), op.Record
+			written[result.ID] = op.Record
+		case BatchUpdate:
+			result.Status, result.Record = http.StatusOK, op.Record
+			written[op.ID] = op.Record
+		case BatchDelete:
+			result.Status = http.StatusOK
+			deleted = append(deleted, op.ID)
+		}
+	}
+	res.Cache.WriteMany(afterWrite(ctx), written, deleted)
+
+	out := BatchResponse{Mode: req.Mode, Results: results}
+	for i := range results {
+		if p := results[i].Problem; p != nil {
+			results[i].Status = p.Status
+			out.Failed++
+		} else {
+			out.Applied++
+		}
+	}
+	status := http.StatusOK
+	if out.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(out)
+}
+
+// batchOp checks and decodes one operation of a batch request.
+func (res *Resource[T, P]) batchOp(o BatchOperation) (BatchOp[T], error) {
+	op := BatchOp[T]{Op: o.Op, ID: o.ID, Version: o.Version}
+	switch o.Op {
+	case BatchCreate:
+		// like POST, a create takes no id
+		op.ID, op.Version = "", 0
+	case BatchUpdate, BatchDelete:
+		if o.ID == "" {
+			return op, fieldProblem("id", "is required")
+		}
+		// canonical, so that two spellings of an id count as one record
+		id, err := canonicalKey[T, P](o.ID)
+		if err != nil {
+			return op, err
+		}
+		op.ID = id
+	default:
+		return op, fieldProblem("op", "must be "+BatchCreate+", "+BatchUpdate+" or "+BatchDelete)
+	}
+	if o.Op == BatchDelete {
+		return op, nil
+	}
+	if len(o.Record) == 0 {
+		return op, fieldProblem("record", "is required")
+	}
+	var v T
+	if err := json.Unmarshal(o.Record, &v); err != nil {
+		return op, NewProblem(http.StatusBadRequest, ProblemTypeMalformedBody, "record is not valid JSON: "+err.Error())
+	}
+	if p := res.invalid(v); p != nil {
+		return op, p
+	}
+	// the version comes from the operation, never from the record
+	P(&v).SetVersion(op.Version)
+	op.Record = &v
+	return op, nil
+}
+
+// fieldProblem is a validation problem with one invalid field.
+func fieldProblem(field, message string) *Problem {
+	p := NewProblem(http.StatusBadRequest, ProblemTypeValidation, field+" "+message)
+	p.Errors = []FieldError{{Field: field, Message: message}}
+	return p
+}
@@ -0,0 +1,284 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMemoryStore_Batch(t *testing.T) {
+	tests := []struct {
+		name        string // description of this test case
+		opts        hybridsystem.BatchOptions
+		wantErrs    []error
+		wantUsers   int
+		wantTrashed int
+	}{
+		{
+			name:      "all or nothing rolls back on a stale update",
+			opts:      hybridsystem.BatchOptions{Atomic: true},
+			wantErrs:  []error{hybridsystem.ErrNotApplied, hybridsystem.ErrVersionMismatch, hybridsystem.ErrNotApplied},
+			wantUsers: 2,
+		},
+		{
+			name:      "best effort applies the others",
+			wantErrs:  []error{nil, hybridsystem.ErrVersionMismatch, nil},
+			wantUsers: 2,
+		},
+		{
+			name:        "best effort soft deletes",
+			opts:        hybridsystem.BatchOptions{SoftDelete: true},
+			wantErrs:    []error{nil, hybridsystem.ErrVersionMismatch, nil},
+			wantUsers:   2,
+			wantTrashed: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := hybridsystem.NewMemoryUserStore()
+			store.Create(ctx, &hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com"})
+			store.Create(ctx, &hybridsystem.User2{Name: "Paul", Email: "paul@gmail.com"})
+
+			ops := []hybridsystem.BatchOp[hybridsystem.User2]{
+				{Op: hybridsystem.BatchCreate, Record: &hybridsystem.User2{Name: "Rahul", Email: "Rahul@Gmail.com"}},
+				{Op: hybridsystem.BatchUpdate, ID: "1", Version: 2, Record: &hybridsystem.User2{Name: "Lost", Email: "akash@gmail.com"}},
+				{Op: hybridsystem.BatchDelete, ID: "2", Version: 1},
+			}
+			errs, err := store.Batch(ctx, ops, tt.opts)
+			if err != nil {
+				t.Fatalf("batch failed: %v", err)
+			}
+			for i, want := range tt.wantErrs {
+				if !errors.Is(errs[i], want) || (want == nil && errs[i] != nil) {
+					t.Errorf("operation %d: expected %v, got %v", i, want, errs[i])
+				}
+			}
+			page, _ := store.List(ctx, hybridsystem.ListQuery{})
+			if len(page.Items) != tt.wantUsers {
+				t.Errorf("expected %d users, got %+v", tt.wantUsers, page.Items)
+			}
+			if trash, _ := store.Trash(ctx, hybridsystem.ListQuery{}); len(trash.Items) != tt.wantTrashed {
+				t.Errorf("expected %d trashed users, got %+v", tt.wantTrashed, trash.Items)
+			}
+			if user, _ := store.Get(ctx, "1"); user.Name != "Akash" || user.Version != 1 {
+				t.Errorf("expected the stale update to leave user 1 alone, got %+v", user)
+			}
+			if errs[0] == nil && (ops[0].Record.ID == 0 || ops[0].Record.Version != 1 || ops[0].Record.Email != "rahul@gmail.com") {
+				t.Errorf("expected the created record filled in, got %+v", ops[0].Record)
+			}
+			history, _ := store.History(ctx, "1")
+			if len(history) != 1 {
+				t.Errorf("expected no audit entry for the failed update, got %+v", history)
+			}
+		})
+	}
+}
+
+func TestResource_Batch(t *testing.T) {
+	tests := []struct {
+		name        string // description of this test case
+		body        string
+		wantCode    int
+		wantApplied int
+		wantStatus  []int
+		wantNames   []string // of users 1, 2 and 3 afterwards, "" if absent
+	}{
+		{
+			name: "all applied",
+			body: `{"operations":[
+				{"op":"create","record":{"name":"Rahul","email":"rahul@gmail.com"}},
+				{"op":"update","id":"1","version":1,"record":{"name":"Akash Paul","email":"akash@gmail.com"}},
+				{"op":"delete","id":"2"}]}`,
+			wantCode:    http.StatusOK,
+			wantApplied: 3,
+			wantStatus:  []int{http.StatusCreated, http.StatusOK, http.StatusOK},
+			wantNames:   []string{"Akash Paul", "", "Rahul"},
+		},
+		{
+			name: "an invalid record stops an all-or-nothing batch",
+			body: `{"mode":"all_or_nothing","operations":[
+				{"op":"create","record":{"name":"Rahul","email":"rahul@gmail.com"}},
+				{"op":"update","id":"1","record":{"name":"","email":"akash@gmail.com"}}]}`,
+			wantCode:   http.StatusMultiStatus,
+			wantStatus: []int{http.StatusFailedDependency, http.StatusBadRequest},
+			wantNames:  []string{"Akash", "Paul", ""},
+		},
+		{
+			name: "a conflict rolls back an all-or-nothing batch",
+			body: `{"operations":[
+				{"op":"delete","id":"2"},
+				{"op":"create","record":{"name":"Rahul","email":"AKASH@gmail.com"}}]}`,
+			wantCode:   http.StatusMultiStatus,
+			wantStatus: []int{http.StatusFailedDependency, http.StatusConflict},
+			wantNames:  []string{"Akash", "Paul", ""},
+		},
+		{
+			name: "best effort applies what it can",
+			body: `{"mode":"best_effort","operations":[
+				{"op":"create","record":{"name":"Rahul","email":"rahul@gmail.com"}},
+				{"op":"update","id":"1","version":7,"record":{"name":"Lost","email":"akash@gmail.com"}},
+				{"op":"delete","id":"9"},
+				{"op":"update","id":"x","record":{"name":"Lost","email":"lost@gmail.com"}},
+				{"op":"rename","id":"2"},
+				{"op":"update","id":"2","record":{"name":"Paul Das","email":"paul@gmail.com"}},
+				{"op":"delete","id":"2"}]}`,
+			wantCode:    http.StatusMultiStatus,
+			wantApplied: 2,
+			wantStatus: []int{http.StatusCreated, http.StatusPreconditionFailed, http.StatusNotFound,
+				http.StatusBadRequest, http.StatusBadRequest, http.StatusOK, http.StatusBadRequest},
+			wantNames: []string{"Akash", "Paul Das", "Rahul"},
+		},
+		{
+			name: "two spellings of one id are one record",
+			body: `{"mode":"best_effort","operations":[
+				{"op":"update","id":"01","record":{"name":"Akash Paul","email":"akash@gmail.com"}},
+				{"op":"delete","id":"1"}]}`,
+			wantCode:    http.StatusMultiStatus,
+			wantApplied: 1,
+			wantStatus:  []int{http.StatusOK, http.StatusBadRequest},
+			wantNames:   []string{"Akash Paul", "Paul", ""},
+		},
+		{
+			name:      "unknown mode",
+			body:      `{"mode":"some","operations":[{"op":"delete","id":"2"}]}`,
+			wantCode:  http.StatusBadRequest,
+			wantNames: []string{"Akash", "Paul", ""},
+		},
+		{
+			name:      "no operations",
+			body:      `{"operations":[]}`,
+			wantCode:  http.StatusBadRequest,
+			wantNames: []string{"Akash", "Paul", ""},
+		},
+		{
+			name:      "too many operations",
+			body:      `{"operations":[` + strings.Repeat(`{"op":"delete","id":"2"},`, hybridsystem.MaxBatchSize) + `{"op":"delete","id":"2"}]}`,
+			wantCode:  http.StatusBadRequest,
+			wantNames: []string{"Akash", "Paul", ""},
+		},
+		{
+			name:      "malformed body",
+			body:      `{"operations":`,
+			wantCode:  http.StatusBadRequest,
+			wantNames: []string{"Akash", "Paul", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := hybridsystem.NewMemoryUserStore()
+			store.Create(ctx, &hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com"})
+			store.Create(ctx, &hybridsystem.User2{Name: "Paul", Email: "paul@gmail.com"})
+			cache := hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)
+			res := &hybridsystem.Resource[hybridsystem.User2, *hybridsystem.User2]{Name: "user", Store: store, Cache: cache, Batch: store, Validate: hybridsystem.ValidateUser}
+			router := mux.NewRouter()
+			res.Register(router, "/users")
+			get := func(id string) *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users/"+id, nil))
+				return w
+			}
+			// cache users 1 and 2 as they were
+			get("1")
+			get("2")
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/users:batch", strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, w.Code, w.Body)
+			}
+			if tt.wantStatus != nil {
+				var out hybridsystem.BatchResponse
+				if err := json.NewDecoder(w.Body).Decode(&out); err != nil {
+					t.Fatal(err)
+				}
+				if out.Applied != tt.wantApplied || out.Failed != len(tt.wantStatus)-tt.wantApplied || len(out.Results) != len(tt.wantStatus) {
+					t.Fatalf("expected %d of %d applied, got %+v", tt.wantApplied, len(tt.wantStatus), out)
+				}
+				for i, result := range out.Results {
+					if result.Index != i || result.Status != tt.wantStatus[i] || (result.Problem == nil) != (result.Status < 300) {
+						t.Errorf("operation %d: expected %d, got %+v", i, tt.wantStatus[i], result)
+					}
+				}
+			}
+
+			// the cache must agree with the store
+			for i, want := range tt.wantNames {
+				id := fmt.Sprint(i + 1)
+				w := get(id)
+				var user hybridsystem.User2
+				json.NewDecoder(w.Body).Decode(&user)
+				if user.Name != want {
+					t.Errorf("expected user %s named %q, got %d: %+v", id, want, w.Code, user)
+				}
+			}
+		})
+	}
+}
+
+func TestResource_NoBatch(t *testing.T) {
+	res := &hybridsystem.Resource[hybridsystem.User2, *hybridsystem.User2]{Name: "user", Store: hybridsystem.NewMemoryUserStore()}
+	router := mux.NewRouter()
+	res.Register(router, "/users")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/users:batch", strings.NewReader(`{"operations":[{"op":"delete","id":"1"}]}`)))
+	if w.Code != http.StatusNotFound && w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected no batch route, got %d", w.Code)
+	}
+}
+
+func TestCache_WriteMany(t *testing.T) {
+	ctx := context.Background()
+	backend := hybridsystem.NewMemoryBackend()
+	cache := hybridsystem.NewCache[hybridsystem.User2](backend, hybridsystem.UserKeys, time.Minute)
+	cache.StaleTTL = time.Minute
+	cache.Put(ctx, "1", &hybridsystem.User2{ID: 1, Name: "Akash", Version: 3})
+	cache.Put(ctx, "2", &hybridsystem.User2{ID: 2, Name: "Paul", Version: 1})
+
+	err := cache.WriteMany(ctx, map[string]*hybridsystem.User2{
+		"1": {ID: 1, Name: "Older", Version: 2},
+		"3": {ID: 3, Name: "Rahul", Version: 1},
+	}, []string{"2"})
+	if err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	tests := []struct {
+		name     string // description of this test case
+		id       string
+		wantName string
+	}{
+		{name: "an older version does not replace a newer one", id: "1", wantName: "Akash"},
+		{name: "invalidated", id: "2"},
+		{name: "added", id: "3", wantName: "Rahul"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := cache.Fetch(ctx, tt.id, func(context.Context) (*hybridsystem.User2, error) {
+				return nil, hybridsystem.ErrNotFound
+			})
+			if tt.wantName == "" {
+				if !errors.Is(err, hybridsystem.ErrNotFound) {
+					t.Errorf("expected a miss, got %+v, %v", v, err)
+				}
+				if _, err := backend.Get(ctx, hybridsystem.UserKeys.Key(tt.id)+":stale"); err == nil {
+					t.Errorf("expected the stale copy dropped too")
+				}
+				return
+			}
+			if err != nil || v.Name != tt.wantName {
+				t.Errorf("expected %s, got %+v, %v", tt.wantName, v, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,548 @@
+package hybridsystem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultCacheTTL is used when an entity does not configure its own TTL.
+const DefaultCacheTTL = 10 * time.Minute
+
+var ErrCacheMiss = errors.New("cache miss")
+
+// CacheBackend is the key/value store behind a Cache. Get returns
+// ErrCacheMiss when the key is absent.
+type CacheBackend interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Del(ctx context.Context, keys ...string) error
+}
+
+// VersionedSetter is an optional capability of a CacheBackend: SetNewer
+// stores value under key unless a newer version of it is already cached, so a
+// slow writer cannot replace a record with an older one. Del must drop the
+// version along with the value.
+type VersionedSetter interface {
+	SetNewer(ctx context.Context, key string, value []byte, version int64, ttl time.Duration) (stored bool, err error)
+}
+
+// CacheWrite is one write of a BatchWriter: it stores Value under Key, like
+// SetNewer when Version is not 0, or deletes Key when Value is nil.
+type CacheWrite struct {
+	Key     string
+	Value   []byte
+	Version int64
+	TTL     time.Duration
+}
+
+// BatchWriter is an optional capability of a CacheBackend: WriteBatch
+// applies many writes in one round trip.
+type BatchWriter interface {
+	WriteBatch(ctx context.Context, writes []CacheWrite) error
+}
+
+// versionSuffix names the Redis key holding the version of a cached value.
+const versionSuffix = ":version"
+
+// setNewerScript is SetNewer as one atomic step. ARGV are the value, its
+// version and the TTL in milliseconds (0 for none).
+var setNewerScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[2])
+if current and tonumber(current) > tonumber(ARGV[2]) then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[3])
+	redis.call("SET", KEYS[2], ARGV[2], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+	redis.call("SET", KEYS[2], ARGV[2])
+end
+return 1`)
+
+// RedisBackend stores cache entries in Redis.
+type RedisBackend struct {
+	Client *redis.Client
+}
+
+func (b *RedisBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := b.Client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrCacheMiss
+	}
+	return value, err
+}
+
+func (b *RedisBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return b.Client.Set(ctx, key, value, ttl).Err()
+}
+
+func (b *RedisBackend) Del(ctx context.Context, keys ...string) error {
+	all := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		all = append(all, key, key+versionSuffix)
+	}
+	return b.Client.Del(ctx, all...).Err()
+}
+
+func (b *RedisBackend) SetNewer(ctx context.Context, key string, value []byte, version int64, ttl time.Duration) (bool, error) {
+	stored, err := setNewerScript.Run(ctx, b.Client, []string{key, key + versionSuffix}, value, version, ttl.Milliseconds()).Int()
+	return stored == 1, err
+}
+
+// WriteBatch sends the writes in one pipeline. Versioned ones run
+// setNewerScript with EVAL, since EVALSHA could not fall back to loading it
+// in the middle of a pipeline.
+func (b *RedisBackend) WriteBatch(ctx context.Context, writes []CacheWrite) error {
+	_, err := b.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, w := range writes {
+			switch {
+			case w.Value == nil:
+				pipe.Del(ctx, w.Key, w.Key+versionSuffix)
+			case w.Version != 0:
+				setNewerScript.Eval(ctx, pipe, []string{w.Key, w.Key + versionSuffix}, w.Value, w.Version, w.TTL.Milliseconds())
+			default:
+				pipe.Set(ctx, w.Key, w.Value, w.TTL)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// MemoryBackend keeps cache entries in process. It is meant for tests.
+type MemoryBackend struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+}
+
+type memoryItem struct {
+	value   []byte
+	version int64
+	expires time.Time
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{items: make(map[string]memoryItem)}
+}
+
+func (b *MemoryBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	item, ok := b.items[key]
+	if !ok || (!item.expires.IsZero() && time.Now().After(item.expires)) {
+		delete(b.items, key)
+		return nil, ErrCacheMiss
+	}
+	return item.value, nil
+}
+
+func (b *MemoryBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	item := memoryItem{value: value}
+	if ttl > 0 {
+		item.expires = time.Now().Add(ttl)
+	}
+	b.items[key] = item
+	return nil
+}
+
+func (b *MemoryBackend) SetNewer(ctx context.Context, key string, value []byte, version int64, ttl time.Duration) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if item, ok := b.items[key]; ok && item.version > version && (item.expires.IsZero() || time.Now().Before(item.expires)) {
+		return false, nil
+	}
+	item := memoryItem{value: value, version: version}
+	if ttl > 0 {
+		item.expires = time.Now().Add(ttl)
+	}
+	b.items[key] = item
+	return true, nil
+}
+
+func (b *MemoryBackend) Del(ctx context.Context, keys ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, key := range keys {
+		delete(b.items, key)
+	}
+	return nil
+}
+
+func (b *MemoryBackend) WriteBatch(ctx context.Context, writes []CacheWrite) error {
+	for _, w := range writes {
+		switch {
+		case w.Value == nil:
+			b.Del(ctx, w.Key)
+		case w.Version != 0:
+			b.SetNewer(ctx, w.Key, w.Value, w.Version, w.TTL)
+		default:
+			b.Set(ctx, w.Key, w.Value, w.TTL)
+		}
+	}
+	return nil
+}
+
+// Serializer turns cached values into bytes and back.
+type Serializer[T any] interface {
+	Marshal(v *T) ([]byte, error)
+	Unmarshal(data []byte, v *T) error
+}
+
+type JSONSerializer[T any] struct{}
+
+func (JSONSerializer[T]) Marshal(v *T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONSerializer[T]) Unmarshal(data []byte, v *T) error {
+	return json.Unmarshal(data, v)
+}
+
+// CacheStats is a snapshot of a cache's counters. Stale counts reads served
+// from the stale copy while another replica held the reload lock, NotFound
+// counts reads answered by a tombstone.
+type CacheStats struct {
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+	Errors   uint64 `json:"errors"`
+	Stale    uint64 `json:"stale"`
+	NotFound uint64 `json:"not_found"`
+}
+
+// tombstone is cached in place of a record that does not exist. It can never
+// be the output of the JSON serializer.
+var tombstone = []byte("\x00not-found")
+
+// Cache implements cache-aside for one entity: reads go to the backend first
+// and fall through to a loader on a miss. Ids are turned into backend keys
+// by Keys. A nil *Cache is valid and simply calls the loader every time.
+//
+// Concurrent misses for the same id within a process share one load. To
+// coordinate replicas as well, set Locker (usually the backend itself) and
+// StaleTTL so that losers of the lock can serve the previous value.
+//
+// Timeout, when set, bounds every backend call on top of the caller's
+// context, so a slow Redis degrades to a miss instead of holding requests.
+//
+// With NotFoundTTL set, ids the loader reports as ErrNotFound are remembered
+// by a tombstone for that (usually short) time. Put replaces the tombstone,
+// so creating the record makes it visible at once.
+type Cache[T any] struct {
+	Backend    CacheBackend
+	Keys       KeyBuilder
+	TTL        time.Duration
+	Serializer Serializer[T]
+
+	Locker   Locker
+	LockTTL  time.Duration
+	LockWait time.Duration
+	StaleTTL time.Duration
+
+	NotFoundTTL time.Duration
+
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	calls    map[string]*call[T]
+	hits     atomic.Uint64
+	misses   atomic.Uint64
+	errors   atomic.Uint64
+	stale    atomic.Uint64
+	notFound atomic.Uint64
+}
+
+func NewCache[T any](backend CacheBackend, keys KeyBuilder, ttl time.Duration) *Cache[T] {
+	return &Cache[T]{Backend: backend, Keys: keys, TTL: ttl, Serializer: JSONSerializer[T]{}}
+}
+
+// CacheOutcome says how a lookup was answered.
+type CacheOutcome string
+
+const (
+	CacheHit      CacheOutcome = "hit"
+	CacheMiss     CacheOutcome = "miss"
+	CacheNotFound CacheOutcome = "not_found" // answered by a tombstone
+	CacheStale    CacheOutcome = "stale"     // served the stale copy
+	CacheBypass   CacheOutcome = "bypass"    // no cache configured
+)
+
+// Fetch returns the value cached for id, or calls load and caches its
+// result. Backend failures are counted and treated as a miss.
+func (c *Cache[T]) Fetch(ctx context.Context, id string, load func(ctx context.Context) (*T, error)) (*T, error) {
+	v, _, err := c.Lookup(ctx, id, load)
+	return v, err
+}
+
+// Lookup is Fetch that also reports how the value was found.
+func (c *Cache[T]) Lookup(ctx context.Context, id string, load func(ctx context.Context) (*T, error)) (*T, CacheOutcome, error) {
+	if c == nil {
+		v, err := load(ctx)
+		return v, CacheBypass, err
+	}
+	key := c.key(id)
+	switch v, err := c.get(ctx, key); {
+	case err == nil:
+		c.hits.Add(1)
+		return v, CacheHit, nil
+	case errors.Is(err, ErrNotFound):
+		c.notFound.Add(1)
+		return nil, CacheNotFound, err
+	}
+	c.misses.Add(1)
+
+	result, err := c.coalesce(ctx, key, func(ctx context.Context) (loaded[T], error) {
+		return c.load(ctx, id, key, load)
+	})
+	if err != nil {
+		return nil, CacheMiss, err
+	}
+	// Every caller gets its own copy of the shared result.
+	v := *result.v
+	return &v, result.outcome, nil
+}
+
+// loaded is the result shared by the callers coalesced into one load.
+type loaded[T any] struct {
+	v       *T
+	outcome CacheOutcome
+}
+
+// get reads and decodes key. It returns ErrNotFound for a tombstone and
+// ErrCacheMiss otherwise, counting anything but a plain miss as an error.
+func (c *Cache[T]) get(ctx context.Context, key string) (*T, error) {
+	ctx, cancel := c.backendContext(ctx)
+	defer cancel()
+	data, err := c.Backend.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, ErrCacheMiss) {
+			c.errors.Add(1)
+		}
+		return nil, ErrCacheMiss
+	}
+	if bytes.Equal(data, tombstone) {
+		return nil, ErrNotFound
+	}
+	var v T
+	if err := c.serializer().Unmarshal(data, &v); err != nil {
+		c.errors.Add(1)
+		return nil, ErrCacheMiss
+	}
+	return &v, nil
+}
+
+// putNotFound stores a tombstone for key if negative caching is enabled.
+func (c *Cache[T]) putNotFound(ctx context.Context, key string) {
+	if c.NotFoundTTL <= 0 {
+		return
+	}
+	ctx, cancel := c.backendContext(ctx)
+	defer cancel()
+	if err := c.Backend.Set(ctx, key, tombstone, c.NotFoundTTL); err != nil {
+		c.errors.Add(1)
+	}
+}
+
+// Put caches v for id with the cache's TTL.
+func (c *Cache[T]) Put(ctx context.Context, id string, v *T) error {
+	if c == nil {
+		return nil
+	}
+	ctx, cancel := c.backendContext(ctx)
+	defer cancel()
+	data, err := c.serializer().Marshal(v)
+	if err == nil {
+		err = c.set(ctx, c.key(id), data, v, c.ttl())
+	}
+	if err == nil && c.StaleTTL > 0 {
+		err = c.set(ctx, c.key(id)+":stale", data, v, c.ttl()+c.StaleTTL)
+	}
+	if err != nil {
+		c.errors.Add(1)
+	}
+	return err
+}
+
+// set stores a record with SetNewer when both the record and the backend
+// are versioned, so that of two racing writers the later version wins.
+func (c *Cache[T]) set(ctx context.Context, key string, data []byte, v *T, ttl time.Duration) error {
+	version := versionOf(v)
+	setter, canSet := c.Backend.(VersionedSetter)
+	if !canSet || version == 0 {
+		return c.Backend.Set(ctx, key, data, ttl)
+	}
+	_, err := setter.SetNewer(ctx, key, data, version, ttl)
+	return err
+}
+
+// versionOf returns the version of a record, 0 if it has none.
+func versionOf(v any) int64 {
+	if versioned, ok := v.(interface{ CurrentVersion() int64 }); ok {
+		return versioned.CurrentVersion()
+	}
+	return 0
+}
+
+// WriteMany caches the records of put and drops the ids of invalidate, like
+// Put and Invalidate but in a single round trip when the backend is a
+// BatchWriter. A record that cannot be serialized is dropped instead.
+func (c *Cache[T]) WriteMany(ctx context.Context, put map[string]*T, invalidate []string) error {
+	if c == nil || len(put)+len(invalidate) == 0 {
+		return nil
+	}
+	ctx, cancel := c.backendContext(ctx)
+	defer cancel()
+	_, versioned := c.Backend.(VersionedSetter)
+	writes := make([]CacheWrite, 0, 2*(len(put)+len(invalidate)))
+	for _, id := range sortedKeys(put) {
+		data, err := c.serializer().Marshal(put[id])
+		if err != nil {
+			c.errors.Add(1)
+			invalidate = append(invalidate, id)
+			continue
+		}
+		var version int64
+		if versioned {
+			version = versionOf(put[id])
+		}
+		writes = append(writes, CacheWrite{Key: c.key(id), Value: data, Version: version, TTL: c.ttl()})
+		if c.StaleTTL > 0 {
+			writes = append(writes, CacheWrite{Key: c.key(id) + ":stale", Value: data, Version: version, TTL: c.ttl() + c.StaleTTL})
+		}
+	}
+	for _, id := range invalidate {
+		writes = append(writes, CacheWrite{Key: c.key(id)}, CacheWrite{Key: c.key(id) + ":stale"})
+	}
+
+	var err error
+	if batch, ok := c.Backend.(BatchWriter); ok {
+		err = batch.WriteBatch(ctx, writes)
+	} else {
+		for _, w := range writes {
+			var werr error
+			switch {
+			case w.Value == nil:
+				werr = c.Backend.Del(ctx, w.Key)
+			case w.Version != 0:
+				_, werr = c.Backend.(VersionedSetter).SetNewer(ctx, w.Key, w.Value, w.Version, w.TTL)
+			default:
+				werr = c.Backend.Set(ctx, w.Key, w.Value, w.TTL)
+			}
+			err = errors.Join(err, werr)
+		}
+	}
+	if err != nil {
+		c.errors.Add(1)
+	}
+	return err
+}
+
+// Invalidate drops id from the cache.
+func (c *Cache[T]) Invalidate(ctx context.Context, id string) error {
+	if c == nil {
+		return nil
+	}
+	ctx, cancel := c.backendContext(ctx)
+	defer cancel()
+	err := c.Backend.Del(ctx, c.key(id), c.key(id)+":stale")
+	if err != nil {
+		c.errors.Add(1)
+	}
+	return err
+}
+
+// CacheEntry is what Redis holds for one cache key, as shown by the cache
+// inspect command. State is one of the CacheEntry* constants.
+type CacheEntry struct {
+	Key      string
+	State    string
+	TTL      time.Duration
+	StaleTTL time.Duration
+	Value    []byte
+}
+
+const (
+	CacheEntryMissing  = "missing"
+	CacheEntryValue    = "value"
+	CacheEntryNotFound = "not_found"
+)
+
+// InspectCacheEntry reads key and its stale copy without touching the
+// cache statistics.
+func InspectCacheEntry(ctx context.Context, client *redis.Client, key string) (CacheEntry, error) {
+	entry := CacheEntry{Key: key, State: CacheEntryMissing}
+	data, err := client.Get(ctx, key).Bytes()
+	switch {
+	case errors.Is(err, redis.Nil):
+	case err != nil:
+		return entry, err
+	case bytes.Equal(data, tombstone):
+		entry.State = CacheEntryNotFound
+	default:
+		entry.State, entry.Value = CacheEntryValue, data
+	}
+	if entry.State != CacheEntryMissing {
+		if entry.TTL, err = client.TTL(ctx, key).Result(); err != nil {
+			return entry, err
+		}
+	}
+	staleTTL, err := client.TTL(ctx, key+":stale").Result()
+	if err != nil {
+		return entry, err
+	}
+	if staleTTL > 0 {
+		entry.StaleTTL = staleTTL
+	}
+	return entry, nil
+}
+
+func (c *Cache[T]) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	return CacheStats{
+		Hits:     c.hits.Load(),
+		Misses:   c.misses.Load(),
+		Errors:   c.errors.Load(),
+		Stale:    c.stale.Load(),
+		NotFound: c.notFound.Load(),
+	}
+}
+
+// key falls back to the bare id only for caches built without a KeyBuilder.
+func (c *Cache[T]) key(id string) string {
+	if c.Keys.Entity == "" {
+		return id
+	}
+	return c.Keys.Key(id)
+}
+
+func (c *Cache[T]) backendContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.Timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.Timeout)
+}
+
+func (c *Cache[T]) ttl() time.Duration {
+	if c.TTL <= 0 {
+		return DefaultCacheTTL
+	}
+	return c.TTL
+}
+
+func (c *Cache[T]) serializer() Serializer[T] {
+	if c.Serializer == nil {
+		return JSONSerializer[T]{}
+	}
+	return c.Serializer
+}
@@ -0,0 +1,87 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"errors"
+	"redisDatabase/hybridsystem"
+	"testing"
+	"time"
+)
+
+func TestCache_Fetch(t *testing.T) {
+	ctx := context.Background()
+	cache := hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)
+
+	loads := 0
+	load := func(ctx context.Context) (*hybridsystem.User2, error) {
+		loads++
+		return &hybridsystem.User2{ID: 1, Name: "Akash", Email: "akash@gmail.com"}, nil
+	}
+
+	for i := 0; i < 3; i++ {
+		user, err := cache.Fetch(ctx, "1", load)
+		if err != nil {
+			t.Fatalf("fetch failed: %v", err)
+		}
+		if user.Name != "Akash" {
+			t.Fatalf("expected name Akash, got %s", user.Name)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected loader to run once, ran %d times", loads)
+	}
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("expected 2 hits and 1 miss, got %+v", stats)
+	}
+
+	cache.Invalidate(ctx, "1")
+	cache.Fetch(ctx, "1", load)
+	if loads != 2 {
+		t.Errorf("expected loader to run again after invalidate, ran %d times", loads)
+	}
+}
+
+func TestCache_FetchErrorIsNotCached(t *testing.T) {
+	ctx := context.Background()
+	cache := hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)
+
+	for i := 0; i < 2; i++ {
+		_, err := cache.Fetch(ctx, "9648", func(ctx context.Context) (*hybridsystem.User2, error) {
+			return nil, hybridsystem.ErrNotFound
+		})
+		if !errors.Is(err, hybridsystem.ErrNotFound) {
+			t.Fatalf("expected not found, got %v", err)
+		}
+	}
+	if stats := cache.Stats(); stats.Misses != 2 {
+		t.Errorf("expected 2 misses, got %+v", stats)
+	}
+}
+
+func TestCache_TTL(t *testing.T) {
+	ctx := context.Background()
+	cache := hybridsystem.NewCache[hybridsystem.Person](hybridsystem.NewMemoryBackend(), hybridsystem.PersonKeys, 20*time.Millisecond)
+
+	loads := 0
+	load := func(ctx context.Context) (*hybridsystem.Person, error) {
+		loads++
+		return &hybridsystem.Person{Name: "Akash"}, nil
+	}
+	cache.Fetch(ctx, "p", load)
+	time.Sleep(40 * time.Millisecond)
+	cache.Fetch(ctx, "p", load)
+	if loads != 2 {
+		t.Errorf("expected entry to expire after its TTL, loader ran %d times", loads)
+	}
+}
+
+func TestCache_Nil(t *testing.T) {
+	var cache *hybridsystem.Cache[hybridsystem.User2]
+	user, err := cache.Fetch(context.Background(), "1", func(ctx context.Context) (*hybridsystem.User2, error) {
+		return &hybridsystem.User2{ID: 1}, nil
+	})
+	if err != nil || user.ID != 1 {
+		t.Fatalf("nil cache should call the loader, got %v %v", user, err)
+	}
+}
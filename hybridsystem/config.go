@@ -0,0 +1,341 @@
+package hybridsystem
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is every setting the servers need. It is built from, in order of
+// increasing precedence: built-in defaults, a YAML file, environment
+// variables and command-line flags. See settings for the names.
+type Config struct {
+	Server     ServerConfig     `yaml:"server"`
+	Redis      RedisConfig      `yaml:"redis"`
+	MySQL      MySQLConfig      `yaml:"mysql"`
+	Mongo      MongoConfig      `yaml:"mongo"`
+	Log        LogConfig        `yaml:"log"`
+	Timeouts   TimeoutConfig    `yaml:"timeouts"`
+	Validation ValidationConfig `yaml:"validation"`
+	Modules    ModulesConfig    `yaml:"modules"`
+	SoftDelete SoftDeleteConfig `yaml:"soft_delete"`
+}
+
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`
+	DB       int    `yaml:"db"`
+	PoolSize int    `yaml:"pool_size"`
+}
+
+// TimeoutConfig holds the deadlines applied to each store and cache call on
+// top of the request's own context. Zero disables a deadline.
+type TimeoutConfig struct {
+	DBRead  time.Duration `yaml:"db_read"`
+	DBWrite time.Duration `yaml:"db_write"`
+	Cache   time.Duration `yaml:"cache"`
+}
+
+type MySQLConfig struct {
+	DSN             string        `yaml:"dsn"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	// AutoMigrate applies pending schema migrations at startup.
+	AutoMigrate bool `yaml:"auto_migrate"`
+}
+
+type MongoConfig struct {
+	URI         string `yaml:"uri"`
+	Database    string `yaml:"database"`
+	MaxPoolSize uint64 `yaml:"max_pool_size"`
+	// SchemaValidation is the level of the $jsonSchema validators applied
+	// by BootstrapMongo: off, moderate or strict.
+	SchemaValidation string `yaml:"schema_validation"`
+	// Audit keeps an audit log of the writes of each collection in
+	// "<collection>_audit". It is on by default and needs a replica set
+	// for transactions: without one the server refuses to start until it
+	// is turned off.
+	Audit bool `yaml:"audit"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Server: DefaultServerConfig(),
+		Redis:  RedisConfig{Addr: "localhost:6379", PoolSize: 10},
+		MySQL: MySQLConfig{
+			DSN:             "root:root@tcp(127.0.0.1:3306)/go_users",
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 5 * time.Minute,
+		},
+		Mongo: MongoConfig{URI: "mongodb://localhost:27017", Database: "go_users", MaxPoolSize: 100, SchemaValidation: SchemaValidationOff, Audit: true},
+		Log:   LogConfig{Format: "text", Level: "info"},
+		Timeouts: TimeoutConfig{
+			DBRead:  3 * time.Second,
+			DBWrite: 5 * time.Second,
+			Cache:   500 * time.Millisecond,
+		},
+		Validation: DefaultValidationConfig(),
+		Modules:    ModulePresets["hybrid"],
+		SoftDelete: SoftDeleteConfig{Retention: 30 * 24 * time.Hour, PurgeInterval: time.Hour},
+	}
+}
+
+// setting ties a Config field to its flag and environment variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	field func(c *Config) any
+}
+
+var settings = []setting{
+	{"http-addr", "HTTP_ADDR", "address the HTTP server listens on", func(c *Config) any { return &c.Server.Addr }},
+	{"http-read-timeout", "HTTP_READ_TIMEOUT", "maximum duration for reading a request", func(c *Config) any { return &c.Server.ReadTimeout }},
+	{"http-write-timeout", "HTTP_WRITE_TIMEOUT", "maximum duration for writing a response", func(c *Config) any { return &c.Server.WriteTimeout }},
+	{"http-idle-timeout", "HTTP_IDLE_TIMEOUT", "how long idle keep-alive connections are kept", func(c *Config) any { return &c.Server.IdleTimeout }},
+	{"http-shutdown-timeout", "HTTP_SHUTDOWN_TIMEOUT", "how long to drain requests on shutdown", func(c *Config) any { return &c.Server.ShutdownTimeout }},
+	{"redis-addr", "REDIS_ADDR", "Redis host:port", func(c *Config) any { return &c.Redis.Addr }},
+	{"redis-db", "REDIS_DB", "Redis database number", func(c *Config) any { return &c.Redis.DB }},
+	{"redis-pool-size", "REDIS_POOL_SIZE", "Redis connection pool size", func(c *Config) any { return &c.Redis.PoolSize }},
+	{"mysql-dsn", "MYSQL_DSN", "MySQL data source name", func(c *Config) any { return &c.MySQL.DSN }},
+	{"mysql-max-open-conns", "MYSQL_MAX_OPEN_CONNS", "maximum open MySQL connections (0 = unlimited)", func(c *Config) any { return &c.MySQL.MaxOpenConns }},
+	{"mysql-max-idle-conns", "MYSQL_MAX_IDLE_CONNS", "maximum idle MySQL connections", func(c *Config) any { return &c.MySQL.MaxIdleConns }},
+	{"mysql-conn-max-lifetime", "MYSQL_CONN_MAX_LIFETIME", "maximum lifetime of a MySQL connection", func(c *Config) any { return &c.MySQL.ConnMaxLifetime }},
+	{"mysql-auto-migrate", "MYSQL_AUTO_MIGRATE", "apply pending MySQL migrations at startup", func(c *Config) any { return &c.MySQL.AutoMigrate }},
+	{"mongo-uri", "MONGO_URI", "MongoDB connection URI", func(c *Config) any { return &c.Mongo.URI }},
+	{"mongo-db", "MONGO_DB", "MongoDB database name", func(c *Config) any { return &c.Mongo.Database }},
+	{"mongo-max-pool-size", "MONGO_MAX_POOL_SIZE", "MongoDB connection pool size", func(c *Config) any { return &c.Mongo.MaxPoolSize }},
+	{"mongo-schema-validation", "MONGO_SCHEMA_VALIDATION", "MongoDB validator level: off, moderate or strict", func(c *Config) any { return &c.Mongo.SchemaValidation }},
+	{"mongo-audit", "MONGO_AUDIT", "keep an audit log of MongoDB writes; needs a replica set, set to false without one", func(c *Config) any { return &c.Mongo.Audit }},
+	{"log-format", "LOG_FORMAT", "log output format, text or json", func(c *Config) any { return &c.Log.Format }},
+	{"log-level", "LOG_LEVEL", "minimum log level: debug, info, warn or error", func(c *Config) any { return &c.Log.Level }},
+	{"db-read-timeout", "DB_READ_TIMEOUT", "deadline for each database read (0 = none)", func(c *Config) any { return &c.Timeouts.DBRead }},
+	{"db-write-timeout", "DB_WRITE_TIMEOUT", "deadline for each database write (0 = none)", func(c *Config) any { return &c.Timeouts.DBWrite }},
+	{"cache-timeout", "CACHE_TIMEOUT", "deadline for each cache call (0 = none)", func(c *Config) any { return &c.Timeouts.Cache }},
+	{"allowed-email-domains", "ALLOWED_EMAIL_DOMAINS", "comma-separated email domains to accept (empty = any)", func(c *Config) any { return &c.Validation.AllowedEmailDomains }},
+	{"name-max-len", "NAME_MAX_LEN", "maximum name length in characters", func(c *Config) any { return &c.Validation.NameMaxLen }},
+	{"email-max-len", "EMAIL_MAX_LEN", "maximum email length in characters", func(c *Config) any { return &c.Validation.EmailMaxLen }},
+	{"mysql-users-prefix", "MYSQL_USERS_PREFIX", "path prefix of the MySQL users module, off to disable", func(c *Config) any { return &c.Modules.MySQLUsers }},
+	{"mongo-users-prefix", "MONGO_USERS_PREFIX", "path prefix of the MongoDB users module, off to disable", func(c *Config) any { return &c.Modules.MongoUsers }},
+	{"mongo-persons-prefix", "MONGO_PERSONS_PREFIX", "path prefix of the MongoDB persons module, off to disable", func(c *Config) any { return &c.Modules.MongoPersons }},
+	{"soft-delete", "SOFT_DELETE", "move deleted records to the trash instead of deleting them", func(c *Config) any { return &c.SoftDelete.Enabled }},
+	{"trash-retention", "TRASH_RETENTION", "how long trashed records are kept before they are purged", func(c *Config) any { return &c.SoftDelete.Retention }},
+	{"trash-purge-interval", "TRASH_PURGE_INTERVAL", "how often the trash is purged", func(c *Config) any { return &c.SoftDelete.PurgeInterval }},
+}
+
+// LoadConfig builds and validates the configuration. args are the
+// command-line arguments without the program name; -config (or CONFIG_FILE)
+// names the YAML file.
+func LoadConfig(args []string) (Config, error) {
+	cfg, _, err := ParseConfig(args)
+	return cfg, err
+}
+
+// ParseConfig is LoadConfig for commands that take arguments of their own:
+// it also returns the arguments left after the flags.
+func ParseConfig(args []string) (Config, []string, error) {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	load := ConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+	cfg, err := load()
+	return cfg, fs.Args(), err
+}
+
+// ConfigFlags adds -config and the flag of every setting to fs, so that a
+// command can mix them with flags of its own. Once fs has been parsed, the
+// returned function builds and validates the Config.
+func ConfigFlags(fs *flag.FlagSet) func() (Config, error) {
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	flagValues := map[string]string{}
+	for _, s := range settings {
+		name := s.flag
+		set := func(v string) error {
+			flagValues[name] = v
+			return nil
+		}
+		if _, ok := s.field(&Config{}).(*bool); ok {
+			fs.BoolFunc(name, s.usage+" (env "+s.env+")", set)
+		} else {
+			fs.Func(name, s.usage+" (env "+s.env+")", set)
+		}
+	}
+	return func() (Config, error) {
+		cfg, err := buildConfig(*configFile, flagValues)
+		if err != nil {
+			return Config{}, err
+		}
+		return cfg, cfg.Validate()
+	}
+}
+
+func buildConfig(configFile string, flagValues map[string]string) (Config, error) {
+	cfg := DefaultConfig()
+	if configFile != "" {
+		data, err := os.ReadFile(configFile)
+		if err != nil {
+			return Config{}, err
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return Config{}, fmt.Errorf("%s: %w", configFile, err)
+		}
+	}
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok && v != "" {
+			if err := setField(s.field(&cfg), v); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+	for _, s := range settings {
+		if v, ok := flagValues[s.flag]; ok {
+			if err := setField(s.field(&cfg), v); err != nil {
+				return Config{}, fmt.Errorf("-%s: %w", s.flag, err)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// EnvConfig is the validated default configuration overridden by
+// environment variables only. It backs the Connect*1 helpers.
+func EnvConfig() (Config, error) {
+	cfg, err := buildConfig("", nil)
+	if err != nil {
+		return Config{}, err
+	}
+	return cfg, cfg.Validate()
+}
+
+func setField(ptr any, value string) error {
+	var err error
+	switch p := ptr.(type) {
+	case *string:
+		*p = value
+	case *int:
+		*p, err = strconv.Atoi(value)
+	case *bool:
+		*p, err = strconv.ParseBool(value)
+	case *uint64:
+		*p, err = strconv.ParseUint(value, 10, 64)
+	case *time.Duration:
+		*p, err = time.ParseDuration(value)
+	case *[]string:
+		*p = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*p = append(*p, item)
+			}
+		}
+	default:
+		err = fmt.Errorf("unsupported setting type %T", ptr)
+	}
+	return err
+}
+
+// Validate reports every invalid setting at once.
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	check(c.Server.Addr != "", "server address is empty")
+	check(c.Server.ReadTimeout > 0, "http read timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "http write timeout must be positive")
+	check(c.Server.IdleTimeout > 0, "http idle timeout must be positive")
+
+	check(c.Redis.Addr != "", "redis address is empty")
+	check(c.Redis.DB >= 0, "redis db must not be negative")
+	check(c.Redis.PoolSize >= 0, "redis pool size must not be negative")
+
+	_, err := mysql.ParseDSN(c.MySQL.DSN)
+	check(err == nil, "invalid mysql dsn: %v", err)
+	check(c.MySQL.MaxOpenConns >= 0, "mysql max open conns must not be negative")
+	check(c.MySQL.MaxIdleConns >= 0, "mysql max idle conns must not be negative")
+	check(c.MySQL.MaxOpenConns == 0 || c.MySQL.MaxIdleConns <= c.MySQL.MaxOpenConns,
+		"mysql max idle conns (%d) exceeds max open conns (%d)", c.MySQL.MaxIdleConns, c.MySQL.MaxOpenConns)
+
+	check(strings.HasPrefix(c.Mongo.URI, "mongodb://") || strings.HasPrefix(c.Mongo.URI, "mongodb+srv://"),
+		"mongo uri must start with mongodb:// or mongodb+srv://")
+	check(c.Mongo.Database != "", "mongo database is empty")
+	switch c.Mongo.SchemaValidation {
+	case SchemaValidationOff, SchemaValidationModerate, SchemaValidationStrict:
+	default:
+		check(false, "invalid mongo schema validation %q, want off, moderate or strict", c.Mongo.SchemaValidation)
+	}
+
+	check(c.Validation.NameMaxLen >= 0 && c.Validation.EmailMaxLen >= 0, "validation length limits must not be negative")
+	check(c.Timeouts.DBRead >= 0 && c.Timeouts.DBWrite >= 0 && c.Timeouts.Cache >= 0, "timeouts must not be negative")
+
+	check(c.Modules.Validate() == nil, "modules: %v", c.Modules.Validate())
+	check(c.SoftDelete.Retention > 0, "trash retention must be positive")
+	check(c.SoftDelete.PurgeInterval > 0, "trash purge interval must be positive")
+
+	_, err = NewLogger(io.Discard, c.Log)
+	check(err == nil, "%v", err)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (c RedisConfig) NewClient() *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     c.Addr,
+		DB:       c.DB,
+		PoolSize: c.PoolSize,
+	})
+}
+
+// Open opens the pool. Whatever the DSN says, DATETIME columns are read as
+// time.Time, since the stores scan them into one.
+func (c MySQLConfig) Open() (*sql.DB, error) {
+	dsn, err := mysql.ParseDSN(c.DSN)
+	if err != nil {
+		return nil, err
+	}
+	dsn.ParseTime = true
+	db, err := sql.Open("mysql", dsn.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	return db, nil
+}
+
+// Addr is the host:port part of the DSN, safe to log unlike the DSN itself.
+func (c MySQLConfig) Addr() string {
+	dsn, err := mysql.ParseDSN(c.DSN)
+	if err != nil {
+		return ""
+	}
+	return dsn.Addr
+}
+
+func (c MongoConfig) Connect(ctx context.Context) (*mongo.Client, error) {
+	clientOptions := options.Client().ApplyURI(c.URI)
+	if c.MaxPoolSize > 0 {
+		clientOptions.SetMaxPoolSize(c.MaxPoolSize)
+	}
+	return mongo.Connect(ctx, clientOptions)
+}
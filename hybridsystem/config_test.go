@@ -0,0 +1,173 @@
+package hybridsystem_test
+
+import (
+	"os"
+	"path/filepath"
+	"redisDatabase/hybridsystem"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	cfg, err := hybridsystem.LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, hybridsystem.DefaultConfig()) {
+		t.Fatalf("expected defaults, got %+v", cfg)
+	}
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  addr: ":9000"
+  read_timeout: 7s
+redis:
+  addr: "file-redis:6379"
+  db: 2
+mysql:
+  max_open_conns: 50
+mongo:
+  database: "from_file"
+`)
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("REDIS_ADDR", "env-redis:6379")
+	t.Setenv("MONGO_DB", "from_env")
+	t.Setenv("ALLOWED_EMAIL_DOMAINS", " gmail.com, example.org,")
+	t.Setenv("MONGO_AUDIT", "false")
+
+	cfg, err := hybridsystem.LoadConfig([]string{"-config", path, "-mongo-db", "from_flag", "-http-write-timeout", "3s", "-mysql-auto-migrate", "serve"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name string // description of this test case
+		got  any
+		want any
+	}{
+		{name: "file overrides default", got: cfg.Server.Addr, want: ":9000"},
+		{name: "file duration", got: cfg.Server.ReadTimeout, want: 7 * time.Second},
+		{name: "file int", got: cfg.Redis.DB, want: 2},
+		{name: "env overrides file", got: cfg.Redis.Addr, want: "env-redis:6379"},
+		{name: "flag overrides env", got: cfg.Mongo.Database, want: "from_flag"},
+		{name: "flag overrides default", got: cfg.Server.WriteTimeout, want: 3 * time.Second},
+		{name: "untouched default", got: cfg.MySQL.MaxIdleConns, want: 5},
+		{name: "env list", got: strings.Join(cfg.Validation.AllowedEmailDomains, "|"), want: "gmail.com|example.org"},
+		{name: "file int beside defaults", got: cfg.MySQL.MaxOpenConns, want: 50},
+		{name: "bare bool flag", got: cfg.MySQL.AutoMigrate, want: true},
+		{name: "env turns off a default", got: cfg.Mongo.Audit, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, tt.got)
+			}
+		})
+	}
+}
+
+func TestEnvConfig(t *testing.T) {
+	tests := []struct {
+		name    string // description of this test case
+		env     string
+		value   string
+		wantErr string
+	}{
+		{name: "valid", env: "REDIS_ADDR", value: "env-redis:6379"},
+		{name: "unparsable value", env: "REDIS_DB", value: "one", wantErr: "REDIS_DB"},
+		{name: "invalid value", env: "MONGO_URI", value: "localhost:27017", wantErr: "mongo uri"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			cfg, err := hybridsystem.EnvConfig()
+			if tt.wantErr == "" {
+				if err != nil || cfg.Redis.Addr != tt.value {
+					t.Errorf("expected redis at %s, got %q, %v", tt.value, cfg.Redis.Addr, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error about %s, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+
+	tests := []struct {
+		name     string // description of this test case
+		args     []string
+		wantErrs []string
+	}{
+		{
+			name:     "bad duration",
+			args:     []string{"-http-read-timeout", "soon"},
+			wantErrs: []string{"-http-read-timeout"},
+		},
+		{
+			name:     "every problem reported at once",
+			args:     []string{"-mysql-dsn", "not a dsn", "-mysql-max-idle-conns", "99", "-mongo-uri", "localhost:27017"},
+			wantErrs: []string{"invalid mysql dsn", "max idle conns (99)", "mongo uri"},
+		},
+		{
+			name:     "unknown log format",
+			args:     []string{"-log-format", "xml"},
+			wantErrs: []string{"invalid log format"},
+		},
+		{
+			name:     "unknown mongo schema validation",
+			args:     []string{"-mongo-schema-validation", "warn"},
+			wantErrs: []string{"invalid mongo schema validation"},
+		},
+		{
+			name:     "clashing module prefixes",
+			args:     []string{"-mongo-users-prefix", "/users/mongo"},
+			wantErrs: []string{`prefix "/users" clashes with mongo_users`},
+		},
+		{
+			name:     "no modules",
+			args:     []string{"-mysql-users-prefix", "off", "-mongo-persons-prefix", "off"},
+			wantErrs: []string{"no modules enabled"},
+		},
+		{
+			name:     "trash retention and purge interval",
+			args:     []string{"-soft-delete", "-trash-retention", "0s", "-trash-purge-interval", "-1m"},
+			wantErrs: []string{"trash retention must be positive", "trash purge interval must be positive"},
+		},
+		{
+			name:     "missing config file",
+			args:     []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")},
+			wantErrs: []string{"missing.yaml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := hybridsystem.LoadConfig(tt.args)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("expected error to mention %q, got %v", want, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// blockingStore blocks every Get until its context ends and reports the
+// context error it saw on seen.
+type blockingStore struct {
+	hybridsystem.UserStore[hybridsystem.User2]
+	started chan struct{}
+	seen    chan error
+}
+
+func newBlockingStore() *blockingStore {
+	return &blockingStore{
+		UserStore: hybridsystem.NewMemoryUserStore(),
+		started:   make(chan struct{}, 10),
+		seen:      make(chan error, 10),
+	}
+}
+
+func (s *blockingStore) Get(ctx context.Context, id string) (*hybridsystem.User2, error) {
+	s.started <- struct{}{}
+	<-ctx.Done()
+	s.seen <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func serveGet(handle *hybridsystem.HybridHandler3, ctx context.Context) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/users/1", nil).WithContext(ctx)
+	r = mux.SetURLVars(r, map[string]string{"id": "1"})
+	w := httptest.NewRecorder()
+	handle.GetUserHandler3(w, r)
+	return w
+}
+
+func TestResource_ClientDisconnectCancelsStore(t *testing.T) {
+	tests := []struct {
+		name  string // description of this test case
+		cache *hybridsystem.Cache[hybridsystem.User2]
+	}{
+		{name: "without cache"},
+		{name: "through the cache", cache: hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newBlockingStore()
+			handle := &hybridsystem.HybridHandler3{Users: store, UserCache: tt.cache}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				serveGet(handle, ctx)
+				close(done)
+			}()
+			<-store.started
+			cancel()
+
+			select {
+			case err := <-store.seen:
+				if !errors.Is(err, context.Canceled) {
+					t.Fatalf("expected the store to see context.Canceled, got %v", err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("cancelling the request did not reach the store")
+			}
+			<-done
+		})
+	}
+}
+
+func TestResource_ReadTimeout(t *testing.T) {
+	store := newBlockingStore()
+	handle := &hybridsystem.HybridHandler3{
+		Users:    store,
+		Timeouts: hybridsystem.TimeoutConfig{DBRead: 20 * time.Millisecond},
+	}
+
+	w := serveGet(handle, context.Background())
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected status %d, got %d", http.StatusGatewayTimeout, w.Code)
+	}
+	if err := <-store.seen; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the store to see a deadline, got %v", err)
+	}
+}
+
+func TestCache_SharedLoadOutlivesOneCaller(t *testing.T) {
+	cache := hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)
+	release := make(chan struct{})
+	loaderErr := make(chan error, 1)
+	load := func(ctx context.Context) (*hybridsystem.User2, error) {
+		select {
+		case <-release:
+			return &hybridsystem.User2{ID: 1, Name: "alice"}, nil
+		case <-ctx.Done():
+			loaderErr <- ctx.Err()
+			return nil, ctx.Err()
+		}
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := cache.Fetch(first, "1", load)
+		firstErr <- err
+	}()
+	second := make(chan *hybridsystem.User2, 1)
+	go func() {
+		// give the first caller time to start the load
+		time.Sleep(20 * time.Millisecond)
+		v, _ := cache.Fetch(context.Background(), "1", load)
+		second <- v
+	}()
+
+	time.Sleep(40 * time.Millisecond)
+	cancelFirst()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the first caller to give up, got %v", err)
+	}
+	close(release)
+
+	select {
+	case v := <-second:
+		if v == nil || v.Name != "alice" {
+			t.Fatalf("expected the second caller to get the shared result, got %+v", v)
+		}
+	case err := <-loaderErr:
+		t.Fatalf("load was cancelled while a caller still waited: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("second caller never got a result")
+	}
+}
+
+func TestCache_SharedLoadCancelledWhenAllCallersLeave(t *testing.T) {
+	cache := hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)
+	started := make(chan struct{})
+	loaderErr := make(chan error, 1)
+	load := func(ctx context.Context) (*hybridsystem.User2, error) {
+		close(started)
+		<-ctx.Done()
+		loaderErr <- ctx.Err()
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go cache.Fetch(ctx, "1", load)
+	<-started
+	cancel()
+
+	select {
+	case err := <-loaderErr:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("load kept running after every caller left")
+	}
+}
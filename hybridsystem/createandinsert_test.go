@@ -8,7 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
-	hybridsystem "redisDatabase/Hybridsystem"
+	hybridsystem "redisDatabase/hybridsystem"
 	"strconv"
 	"testing"
 
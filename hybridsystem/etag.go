@@ -0,0 +1,44 @@
+package hybridsystem
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ETag is the entity tag of a record at version: a strong tag, since every
+// write bumps the version.
+func ETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// parseETag is the version of a strong tag made by ETag.
+func parseETag(tag string) (int64, bool) {
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	return version, err == nil
+}
+
+// matchETag reports whether an If-Match or If-None-Match header matches tag.
+// The header is "*" or a comma-separated list of tags. If-None-Match uses the
+// weak comparison (weak), where W/"3" matches "3"; If-Match the strong one,
+// where weak tags never match (RFC 9110, section 8.8.3.2).
+func matchETag(header, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.HasPrefix(candidate, "W/") {
+			if !weak {
+				continue
+			}
+			candidate = candidate[2:]
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
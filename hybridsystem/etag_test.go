@@ -0,0 +1,135 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMemoryStore_Versions(t *testing.T) {
+	ctx := context.Background()
+	store := hybridsystem.NewMemoryUserStore()
+	user := &hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com", Version: 7}
+	if err := store.Create(ctx, user); err != nil || user.Version != 1 {
+		t.Fatalf("expected version 1 on create, got %d: %v", user.Version, err)
+	}
+
+	update := &hybridsystem.User2{Name: "Paul", Email: "akash@gmail.com"}
+	if err := store.Update(ctx, "1", update); err != nil || update.Version != 2 {
+		t.Fatalf("expected an unconditional update to version 2, got %d: %v", update.Version, err)
+	}
+	stale := &hybridsystem.User2{Name: "Lost", Email: "akash@gmail.com", Version: 1}
+	if err := store.Update(ctx, "1", stale); !errors.Is(err, hybridsystem.ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch for a stale update, got %v", err)
+	}
+	current := &hybridsystem.User2{Name: "Won", Email: "akash@gmail.com", Version: 2}
+	if err := store.Update(ctx, "1", current); err != nil || current.Version != 3 {
+		t.Fatalf("expected a conditional update to version 3, got %d: %v", current.Version, err)
+	}
+
+	if err := store.DeleteVersion(ctx, "1", 2); !errors.Is(err, hybridsystem.ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch for a stale delete, got %v", err)
+	}
+	if err := store.DeleteVersion(ctx, "1", 3); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if err := store.DeleteVersion(ctx, "1", 3); !errors.Is(err, hybridsystem.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound once deleted, got %v", err)
+	}
+}
+
+func TestResource_ETags(t *testing.T) {
+	ctx := context.Background()
+	store := hybridsystem.NewMemoryUserStore()
+	store.Create(ctx, &hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com"})
+	cache := hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)
+	res := &hybridsystem.Resource[hybridsystem.User2, *hybridsystem.User2]{Name: "user", Store: store, Cache: cache, Validate: hybridsystem.ValidateUser}
+	router := mux.NewRouter()
+	res.Register(router, "/users")
+
+	// the steps run in order against the same record
+	steps := []struct {
+		name     string // description of this test case
+		method   string
+		header   string
+		value    string
+		body     string
+		wantCode int
+		wantETag string
+	}{
+		{name: "get from the store", method: http.MethodGet, wantCode: http.StatusOK, wantETag: `"1"`},
+		{name: "get from the cache", method: http.MethodGet, wantCode: http.StatusOK, wantETag: `"1"`},
+		{name: "not modified", method: http.MethodGet, header: "If-None-Match", value: `"0", W/"1"`, wantCode: http.StatusNotModified, wantETag: `"1"`},
+		{name: "modified", method: http.MethodGet, header: "If-None-Match", value: `"0"`, wantCode: http.StatusOK, wantETag: `"1"`},
+		{name: "put with current etag", method: http.MethodPut, header: "If-Match", value: `"1"`, body: `{"name":"Paul","email":"paul@gmail.com","version":99}`, wantCode: http.StatusOK, wantETag: `"2"`},
+		{name: "put with stale etag", method: http.MethodPut, header: "If-Match", value: `"1"`, body: `{"name":"Lost","email":"lost@gmail.com"}`, wantCode: http.StatusPreconditionFailed},
+		{name: "weak etag never matches if-match", method: http.MethodPut, header: "If-Match", value: `W/"2"`, body: `{"name":"Lost","email":"lost@gmail.com"}`, wantCode: http.StatusPreconditionFailed},
+		{name: "cache follows the write", method: http.MethodGet, header: "If-None-Match", value: `"2"`, wantCode: http.StatusNotModified, wantETag: `"2"`},
+		{name: "patch with stale etag", method: http.MethodPatch, header: "If-Match", value: `"1"`, body: `{"name":"Lost"}`, wantCode: http.StatusPreconditionFailed},
+		{name: "patch with one of several etags", method: http.MethodPatch, header: "If-Match", value: `"1", "2"`, body: `{"name":"Paula"}`, wantCode: http.StatusOK, wantETag: `"3"`},
+		{name: "put without if-match", method: http.MethodPut, body: `{"name":"Anyone","email":"paul@gmail.com"}`, wantCode: http.StatusOK, wantETag: `"4"`},
+		{name: "delete with stale etag", method: http.MethodDelete, header: "If-Match", value: `"3"`, wantCode: http.StatusPreconditionFailed},
+		{name: "delete with any etag", method: http.MethodDelete, header: "If-Match", value: `*`, wantCode: http.StatusOK},
+		{name: "gone", method: http.MethodGet, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/users/1", strings.NewReader(tt.body))
+			if tt.method == http.MethodPatch {
+				r.Header.Set("Content-Type", hybridsystem.MergePatchContentType)
+			}
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, w.Code, w.Body)
+			}
+			if got := w.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("expected ETag %s, got %s", tt.wantETag, got)
+			}
+			if w.Code == http.StatusNotModified && w.Body.Len() > 0 {
+				t.Errorf("expected no body with 304, got %s", w.Body)
+			}
+			if w.Code == http.StatusOK && tt.wantETag != "" {
+				var user hybridsystem.User2
+				json.NewDecoder(w.Body).Decode(&user)
+				if hybridsystem.ETag(user.Version) != tt.wantETag {
+					t.Errorf("expected the body to be at %s, got version %d", tt.wantETag, user.Version)
+				}
+			}
+		})
+	}
+}
+
+// A writer that finishes late must not replace a newer cached version.
+func TestCache_PutKeepsNewerVersion(t *testing.T) {
+	ctx := context.Background()
+	backend := hybridsystem.NewMemoryBackend()
+	cache := hybridsystem.NewCache[hybridsystem.User2](backend, hybridsystem.UserKeys, time.Minute)
+	cache.StaleTTL = time.Minute
+
+	cache.Put(ctx, "1", &hybridsystem.User2{ID: 1, Name: "winner", Version: 3})
+	cache.Put(ctx, "1", &hybridsystem.User2{ID: 1, Name: "loser", Version: 2})
+	for _, key := range []string{hybridsystem.UserKeys.Key("1"), hybridsystem.UserKeys.Key("1") + ":stale"} {
+		data, err := backend.Get(ctx, key)
+		if err != nil || !strings.Contains(string(data), "winner") {
+			t.Errorf("expected %s to keep version 3, got %s: %v", key, data, err)
+		}
+	}
+
+	cache.Invalidate(ctx, "1")
+	cache.Put(ctx, "1", &hybridsystem.User2{ID: 1, Name: "recreated", Version: 1})
+	if data, _ := backend.Get(ctx, hybridsystem.UserKeys.Key("1")); !strings.Contains(string(data), "recreated") {
+		t.Errorf("expected invalidation to forget the version, got %s", data)
+	}
+}
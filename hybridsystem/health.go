@@ -0,0 +1,130 @@
+package hybridsystem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// DefaultHealthTimeout bounds each dependency probe made by /readyz.
+	DefaultHealthTimeout = 2 * time.Second
+	// connectTimeout bounds the ping made when a connection is opened.
+	connectTimeout = 10 * time.Second
+)
+
+// Pinger is anything whose reachability can be checked: RedisInstance1,
+// MySQLInstance1 and MongoInstance1 all implement it.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// PingFunc adapts a plain function to a Pinger.
+type PingFunc func(ctx context.Context) error
+
+func (f PingFunc) Ping(ctx context.Context) error { return f(ctx) }
+
+func (r *RedisInstance1) Ping(ctx context.Context) error {
+	return r.Client.Ping(ctx).Err()
+}
+
+func (m *MySQLInstance1) Ping(ctx context.Context) error {
+	return m.DB.PingContext(ctx)
+}
+
+func (m *MongoInstance1) Ping(ctx context.Context) error {
+	return m.Client.Ping(ctx, nil)
+}
+
+// VerifyConnection pings p once so that a misconfigured or unreachable
+// dependency stops the process at startup instead of on the first request.
+func VerifyConnection(name string, p Pinger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err := p.Ping(ctx); err != nil {
+		return fmt.Errorf("%s is unreachable: %w", name, err)
+	}
+	return nil
+}
+
+// Health serves /healthz and /readyz. Checks maps a dependency name such as
+// "redis" to the Pinger used to probe it.
+type Health struct {
+	Checks  map[string]Pinger
+	Timeout time.Duration
+}
+
+type CheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Register adds GET /healthz and GET /readyz to r.
+func (h *Health) Register(r *mux.Router) {
+	r.HandleFunc("/healthz", h.Liveness).Methods("GET")
+	r.HandleFunc("/readyz", h.Readiness).Methods("GET")
+}
+
+// Liveness reports that the process is up; it never touches a dependency.
+func (h *Health) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, HealthReport{Status: "ok"})
+}
+
+// Readiness probes every dependency concurrently and answers 503 if any of
+// them is down.
+func (h *Health) Readiness(w http.ResponseWriter, r *http.Request) {
+	report := h.Check(r.Context())
+	status := http.StatusOK
+	if report.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeHealth(w, status, report)
+}
+
+// Check runs every probe with its own timeout and collects the results.
+func (h *Health) Check(ctx context.Context) HealthReport {
+	report := HealthReport{Status: "ok", Checks: make(map[string]CheckResult, len(h.Checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, p := range h.Checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, durationOr(h.Timeout, DefaultHealthTimeout))
+			defer cancel()
+			start := time.Now()
+			err := p.Ping(ctx)
+			result := CheckResult{Status: "up", LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				result.Status = "down"
+				result.Error = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = "unavailable"
+			}
+		}()
+	}
+	wg.Wait()
+	return report
+}
+
+func writeHealth(w http.ResponseWriter, status int, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,112 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+	"time"
+)
+
+func up() hybridsystem.Pinger {
+	return hybridsystem.PingFunc(func(ctx context.Context) error { return nil })
+}
+
+func down() hybridsystem.Pinger {
+	return hybridsystem.PingFunc(func(ctx context.Context) error { return errors.New("connection refused") })
+}
+
+// hanging blocks until the probe's deadline expires.
+func hanging() hybridsystem.Pinger {
+	return hybridsystem.PingFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+}
+
+func TestHealth_Readiness(t *testing.T) {
+	tests := []struct {
+		name       string // description of this test case
+		checks     map[string]hybridsystem.Pinger
+		wantStatus int
+		wantDown   []string
+	}{
+		{
+			name:       "all dependencies up",
+			checks:     map[string]hybridsystem.Pinger{"redis": up(), "mysql": up(), "mongo": up()},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "one dependency down",
+			checks:     map[string]hybridsystem.Pinger{"redis": up(), "mysql": down(), "mongo": up()},
+			wantStatus: http.StatusServiceUnavailable,
+			wantDown:   []string{"mysql"},
+		},
+		{
+			name:       "probe times out",
+			checks:     map[string]hybridsystem.Pinger{"redis": up(), "mongo": hanging()},
+			wantStatus: http.StatusServiceUnavailable,
+			wantDown:   []string{"mongo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := &hybridsystem.Health{Checks: tt.checks, Timeout: 50 * time.Millisecond}
+			r := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+			w := httptest.NewRecorder()
+
+			start := time.Now()
+			health.Readiness(w, r)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Fatalf("readiness took %v, the timeout was not applied", elapsed)
+			}
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			var report hybridsystem.HealthReport
+			if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+				t.Fatalf("failed to decode report: %v", err)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Fatalf("expected %d checks, got %+v", len(tt.checks), report.Checks)
+			}
+			for _, name := range tt.wantDown {
+				if got := report.Checks[name]; got.Status != "down" || got.Error == "" {
+					t.Errorf("expected %s to be down with an error, got %+v", name, got)
+				}
+			}
+			if len(tt.wantDown) == 0 && report.Status != "ok" {
+				t.Errorf("expected status ok, got %q", report.Status)
+			}
+		})
+	}
+}
+
+func TestHealth_Liveness(t *testing.T) {
+	// liveness must not depend on the dependencies being reachable
+	health := &hybridsystem.Health{Checks: map[string]hybridsystem.Pinger{"mysql": down()}}
+	r := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	w := httptest.NewRecorder()
+	health.Liveness(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status ok, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"status":"ok"`) {
+		t.Fatalf("unexpected body %s", w.Body.String())
+	}
+}
+
+func TestVerifyConnection(t *testing.T) {
+	if err := hybridsystem.VerifyConnection("redis", up()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := hybridsystem.VerifyConnection("mysql at 127.0.0.1:3306", down())
+	if err == nil || !strings.Contains(err.Error(), "mysql at 127.0.0.1:3306 is unreachable") {
+		t.Fatalf("expected a descriptive error, got %v", err)
+	}
+}
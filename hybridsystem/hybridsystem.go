@@ -3,18 +3,16 @@ package hybridsystem
 import (
 	"context"
 	"database/sql"
-	"log"
-	"net/http"
-	"os"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type MySQLInstance1 struct {
@@ -29,86 +27,343 @@ type MongoInstance1 struct {
 	Persons *mongo.Collection
 }
 
+// HybridHandler3 serves users and persons. Users and Persons pick the
+// backends; when left nil they fall back to MySQL and Mongo respectively.
+// UserCache and PersonCache fall back to a ServerCache on Redis.
+// Requests run under their own context bounded by Timeouts. With Metrics
+// set, the store calls are timed, see InstrumentResource. MongoAudit audits
+// the writes of the Mongo fallback; it needs a replica set, see
+// RequireTransactions.
+//
+// The users and persons are served by one Resource each, built on the first
+// request, so that every request shares its cache: set the fields before
+// serving and leave them alone afterwards.
 type HybridHandler3 struct {
-	Redis *RedisInstance1
-	MySQL *MySQLInstance1
-	Mongo *MongoInstance1
-	Ctx   context.Context
+	Redis       *RedisInstance1
+	MySQL       *MySQLInstance1
+	Mongo       *MongoInstance1
+	Users       UserStore[User2]
+	Persons     UserStore[Person]
+	UserCache   *Cache[User2]
+	PersonCache *Cache[Person]
+	Logger      *slog.Logger
+	Timeouts    TimeoutConfig
+	Validation  ValidationConfig
+	Metrics     *Metrics
+	MongoAudit  bool
+
+	userOnce   sync.Once
+	userRes    *Resource[User2, *User2]
+	personOnce sync.Once
+	personRes  *Resource[Person, *Person]
+
+	// Deprecated: handlers use the request's context; Ctx is ignored.
+	Ctx context.Context
 }
 
+// User2 and Person are the records of the resource modules. Version counts
+// the writes of a record and is managed by the stores; it backs the ETag of
+// the HTTP API. CreatedAt and UpdatedAt are set by the stores too, the
+// latter on every write; like the version, clients cannot set them.
+// DeletedAt is set while a record is in the trash.
 type User2 struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	ID        int        `json:"id"`
+	Name      string     `json:"name"`
+	Email     string     `json:"email"`
+	Version   int64      `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type Person struct {
-	ID    primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	Name  string             `json:"name" bson:"name"`
-	Email string             `json:"email" bson:"email"`
+	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name      string             `json:"name" bson:"name"`
+	Email     string             `json:"email" bson:"email"`
+	Version   int64              `json:"version" bson:"version"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+	DeletedAt *time.Time         `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
 }
 
-func Connectredis1() (*RedisInstance1, error) {
-	rdb := redis.NewClient(&redis.Options{
-		Addr: os.Getenv("REDIS_ADDR"),
-		DB:   0,
+func (a *HybridHandler3) users() *Resource[User2, *User2] {
+	a.userOnce.Do(func() {
+		store := a.Users
+		if store == nil {
+			store = NewMySQLUserStore(a.MySQL)
+		}
+		cache := a.UserCache
+		if cache == nil && a.Redis != nil {
+			cache = ServerCache[User2](&RedisBackend{Client: a.Redis.Client}, UserKeys, a.Timeouts)
+		}
+		res := &Resource[User2, *User2]{Name: "user", Store: store, Cache: cache, Validate: UserValidator(a.Validation).Validate, Logger: a.Logger, Timeouts: a.Timeouts}
+		res.History, _ = store.(AuditLog)
+		res.Batch, _ = store.(BatchStore[User2])
+		if a.Metrics != nil {
+			InstrumentResource(res, a.Metrics, "mysql")
+		}
+		a.userRes = res
+	})
+	return a.userRes
+}
+
+func (a *HybridHandler3) persons() *Resource[Person, *Person] {
+	a.personOnce.Do(func() {
+		store := a.Persons
+		if store == nil {
+			store = NewMongoPersonStore(a.Mongo, a.MongoAudit)
+		}
+		cache := a.PersonCache
+		if cache == nil && a.Redis != nil {
+			cache = ServerCache[Person](&RedisBackend{Client: a.Redis.Client}, PersonKeys, a.Timeouts)
+		}
+		res := &Resource[Person, *Person]{Name: "person", Store: store, Cache: cache, Validate: PersonValidator(a.Validation).Validate, Logger: a.Logger, Timeouts: a.Timeouts}
+		res.History, _ = store.(AuditLog)
+		res.Batch, _ = store.(BatchStore[Person])
+		if a.Metrics != nil {
+			InstrumentResource(res, a.Metrics, "mongo")
+		}
+		a.personRes = res
 	})
-	return &RedisInstance1{Client: rdb}, nil
+	return a.personRes
+}
+
+// UserCacheStats and PersonCacheStats are the counters of the caches the
+// handlers share, for Metrics.RegisterCache.
+func (a *HybridHandler3) UserCacheStats() CacheStats {
+	return a.users().Cache.Stats()
+}
+func (a *HybridHandler3) PersonCacheStats() CacheStats {
+	return a.persons().Cache.Stats()
+}
+
+// Connectredis1, ConnectMySQL1 and ConnectMongo1 connect using the defaults
+// overridden by environment variables, see EnvConfig.
+func Connectredis1() (*RedisInstance1, error) {
+	cfg, err := EnvConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewRedisInstance1(cfg.Redis)
 }
 func ConnectMySQL1() (*MySQLInstance1, error) {
-	db, err := sql.Open("mysql", os.Getenv("MYSQL_DSN"))
+	cfg, err := EnvConfig()
 	if err != nil {
 		return nil, err
 	}
-	return &MySQLInstance1{DB: db}, nil
+	return NewMySQLInstance1(cfg.MySQL)
 }
 func ConnectMongo1() (*MongoInstance1, error) {
-	clientOPtions := options.Client().ApplyURI(os.Getenv("MONGO_URI"))
-	client, err := mongo.NewClient(clientOPtions)
+	cfg, err := EnvConfig()
+	if err != nil {
+		return nil, err
+	}
+	return NewMongoInstance1(cfg.Mongo)
+}
+
+// NewRedisInstance1, NewMySQLInstance1 and NewMongoInstance1 connect and
+// ping once, so an unreachable dependency is reported at startup.
+func NewRedisInstance1(cfg RedisConfig) (*RedisInstance1, error) {
+	r := &RedisInstance1{Client: cfg.NewClient()}
+	if err := VerifyConnection("redis at "+cfg.Addr, r); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+func NewMySQLInstance1(cfg MySQLConfig) (*MySQLInstance1, error) {
+	db, err := cfg.Open()
 	if err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	m := &MySQLInstance1{DB: db}
+	if err := VerifyConnection("mysql at "+cfg.Addr(), m); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+func NewMongoInstance1(cfg MongoConfig) (*MongoInstance1, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
 	defer cancel()
-	err = client.Connect(ctx)
+	client, err := cfg.Connect(ctx)
 	if err != nil {
 		return nil, err
 	}
-	db := client.Database(os.Getenv("MONGO_DB"))
-	return &MongoInstance1{
+	db := client.Database(cfg.Database)
+	m := &MongoInstance1{
 		Client:  client,
 		DB:      db,
 		Persons: db.Collection("persons"),
-	}, nil
+	}
+	if err := VerifyConnection("mongo", m); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
 }
-func CRUDoperations2() {
-	godotenv.Load()
 
-	redisInstance, err := Connectredis1()
-	if err != nil {
-		log.Fatal(err)
+// CRUDoperations2 serves MySQL users and MongoDB persons behind one Redis
+// cache until the process is told to stop. It is Serve with the hybrid
+// module preset.
+func CRUDoperations2(cfg Config, logger *slog.Logger) error {
+	cfg.Modules = ModulePresets["hybrid"]
+	return Serve(cfg, logger)
+}
+
+// Serve runs a single server with the modules enabled in cfg.Modules until
+// the process is told to stop. Redis is always used for caching; MySQL and
+// MongoDB are only connected to when an enabled module needs them.
+func Serve(cfg Config, logger *slog.Logger) error {
+	modules := cfg.Modules
+	var closers []io.Closer
+	fail := func(err error) error {
+		for _, c := range closers {
+			c.Close()
+		}
+		return err
 	}
-	mySQLInstance, err := ConnectMySQL1()
+
+	redisInstance, err := NewRedisInstance1(cfg.Redis)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	closers = append(closers, redisInstance)
+	metrics := NewMetrics()
+	redisInstance.Client.AddHook(metrics.RedisHook())
+	health := &Health{Checks: map[string]Pinger{"redis": redisInstance}}
+	cacheBackend := &RedisBackend{Client: redisInstance.Client}
+	prefixes := modules.Prefixes()
+	var mounts []Mount
+	purger := &Purger{
+		Retention: cfg.SoftDelete.Retention,
+		Interval:  cfg.SoftDelete.PurgeInterval,
+		Stores:    map[string]Purgeable{},
+		Logger:    logger,
 	}
-	mongoInstance, err := ConnectMongo1()
+
+	if modules.Enabled(ModuleMySQLUsers) {
+		mySQLInstance, err := NewMySQLInstance1(cfg.MySQL)
+		if err != nil {
+			return fail(err)
+		}
+		closers = append(closers, mySQLInstance)
+		if cfg.MySQL.AutoMigrate {
+			if err := AutoMigrate(mySQLInstance.DB, logger); err != nil {
+				return fail(err)
+			}
+		}
+		health.Checks["mysql"] = mySQLInstance
+		metrics.RegisterDBPool("mysql", mySQLInstance.DB.Stats)
+
+		store := NewMySQLUserStore(mySQLInstance)
+		EnforceUniqueEmail(logger, "mysql", store)
+		cache := ServerCache[User2](cacheBackend, UserKeys, cfg.Timeouts)
+		metrics.RegisterCache(ModuleMySQLUsers, cache.Stats)
+		res := &Resource[User2, *User2]{
+			Name:     "user",
+			Store:    store,
+			Cache:    cache,
+			History:  store,
+			Batch:    store,
+			Validate: UserValidator(cfg.Validation).Validate,
+			Logger:   logger,
+			Timeouts: cfg.Timeouts,
+		}
+		if cfg.SoftDelete.Enabled {
+			res.Trash = store
+			purger.Stores[ModuleMySQLUsers] = store
+		}
+		InstrumentResource(res, metrics, "mysql")
+		mounts = append(mounts, Mount{Name: ModuleMySQLUsers, Prefix: prefixes[ModuleMySQLUsers], Module: res})
+	}
+
+	if modules.Enabled(ModuleMongoUsers) || modules.Enabled(ModuleMongoPersons) {
+		mongoInstance, err := NewMongoInstance1(cfg.Mongo)
+		if err != nil {
+			return fail(err)
+		}
+		closers = append(closers, mongoInstance)
+		health.Checks["mongo"] = mongoInstance
+		if cfg.Mongo.Audit {
+			ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+			err := mongoInstance.RequireTransactions(ctx)
+			cancel()
+			if err != nil {
+				return fail(fmt.Errorf("mongo audit log: %w; use a replica set or turn it off with MONGO_AUDIT=false", err))
+			}
+		}
+
+		// both modules store Person records, in collections and cache
+		// namespaces of their own; record names them in responses
+		for _, m := range []struct {
+			name, collection, record string
+			keys                     KeyBuilder
+		}{
+			{ModuleMongoUsers, "users", "user", MongoUserKeys},
+			{ModuleMongoPersons, "persons", "person", PersonKeys},
+		} {
+			if !modules.Enabled(m.name) {
+				continue
+			}
+			store := &MongoPersonStore{Collection: mongoInstance.DB.Collection(m.collection)}
+			specs := []CollectionSpec{PersonCollection(m.collection, cfg.Validation, cfg.Mongo.SchemaValidation)}
+			if cfg.Mongo.Audit {
+				store.Audit = mongoInstance.DB.Collection(m.collection + "_audit")
+				specs = append(specs, AuditCollection(store.Audit.Name()))
+			}
+			BootstrapMongo(logger, mongoInstance.DB, specs...)
+			cache := ServerCache[Person](cacheBackend, m.keys, cfg.Timeouts)
+			metrics.RegisterCache(m.name, cache.Stats)
+			res := &Resource[Person, *Person]{
+				Name:     m.record,
+				Store:    store,
+				Cache:    cache,
+				Batch:    store,
+				Validate: PersonValidator(cfg.Validation).Validate,
+				Logger:   logger,
+				Timeouts: cfg.Timeouts,
+			}
+			if cfg.SoftDelete.Enabled {
+				res.Trash = store
+				purger.Stores[m.name] = store
+			}
+			if store.Audit != nil {
+				res.History = store
+			}
+			InstrumentResource(res, metrics, "mongo")
+			mounts = append(mounts, Mount{Name: m.name, Prefix: prefixes[m.name], Module: res})
+		}
+	}
+	router, err := Compose(logger, metrics, health, mounts...)
 	if err != nil {
-		log.Fatal(err)
-	}
-	handle := &HybridHandler3{Mongo: mongoInstance, MySQL: mySQLInstance, Redis: redisInstance, Ctx: context.Background()}
-	r := mux.NewRouter()
-	// for MySQL routes
-	r.HandleFunc("/users", handle.CreateUserHandler3).Methods("POST")
-	r.HandleFunc("/users/{id}", handle.GetUserHandler3).Methods("GET")
-	r.HandleFunc("/users/{id}", handle.UpdateUserHandler3).Methods("PUT")
-	r.HandleFunc("/users/{id}", handle.DeleteUserHandler3).Methods("DELETE")
-	//  for MongoDB routes
-	r.HandleFunc("/persons", handle.CreateUserHandlers4).Methods("POST")
-	r.HandleFunc("/persons/{id}", handle.GetUserHandler4).Methods("GET")
-	r.HandleFunc("/persons/{id}", handle.UpdateUserHandler4).Methods("PUT")
-	r.HandleFunc("/persons/{id}", handle.DeleteuserHandler4).Methods("DELETE")
-
-	log.Println("Server running on port :8080")
-	http.ListenAndServe(":8080", r)
+		return fail(err)
+	}
+	if len(purger.Stores) > 0 {
+		// stopped first on shutdown, while the databases are still open
+		closers = append([]io.Closer{purger.Start()}, closers...)
+	}
+	server := &Server{
+		Config:  cfg.Server,
+		Handler: router,
+		Logger:  logger,
+		Closers: closers,
+	}
+	return server.Run()
+}
+
+// ServerCache is the cache of a module served by Serve, and of the legacy
+// handlers: only one replica reloads an expired entry, the others serve the
+// stale copy for up to a minute while it does; unknown ids are remembered
+// for 30 seconds. Each call is bounded by timeouts.Cache.
+func ServerCache[T any](backend interface {
+	CacheBackend
+	Locker
+}, keys KeyBuilder, timeouts TimeoutConfig) *Cache[T] {
+	cache := NewCache[T](backend, keys, DefaultCacheTTL)
+	cache.Locker = backend
+	cache.StaleTTL = time.Minute
+	cache.NotFoundTTL = 30 * time.Second
+	cache.Timeout = timeouts.Cache
+	return cache
 }
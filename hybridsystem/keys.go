@@ -0,0 +1,206 @@
+package hybridsystem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultKeyService is the first segment of every cache key.
+	DefaultKeyService = "svc"
+	// CacheSchemaVersion must be bumped whenever the JSON shape of a cached
+	// record (User2, Person) changes. Old entries then stop being read and
+	// expire on their own or are removed by CleanupLegacyKeys.
+	CacheSchemaVersion = 3
+)
+
+// KeyBuilder produces cache keys of the form "svc:v3:users:42" so that
+// different entities never share a key even when their ids look alike.
+type KeyBuilder struct {
+	Service string
+	Version int
+	Entity  string
+}
+
+// Key builders for the entities of the resource modules. MongoUserKeys
+// namespaces the Mongo users collection apart from the MySQL users.
+var (
+	UserKeys      = NewKeyBuilder("users")
+	PersonKeys    = NewKeyBuilder("persons")
+	MongoUserKeys = NewKeyBuilder("mongo-users")
+)
+
+func NewKeyBuilder(entity string) KeyBuilder {
+	return KeyBuilder{Service: DefaultKeyService, Version: CacheSchemaVersion, Entity: entity}
+}
+
+func (k KeyBuilder) Key(id string) string {
+	return k.prefix() + id
+}
+
+// Pattern matches every key of this entity and version, for SCAN.
+func (k KeyBuilder) Pattern() string {
+	return k.prefix() + "*"
+}
+
+func (k KeyBuilder) prefix() string {
+	return fmt.Sprintf("%s:v%d:%s:", k.Service, k.Version, k.Entity)
+}
+
+var legacyKey = regexp.MustCompile(`^([0-9]+|[0-9a-f]{24})$`)
+
+// IsStaleKey reports whether key was written by an older version of the
+// service: either a bare MySQL id / ObjectID from before keys were
+// namespaced, or a namespaced key whose schema version differs from the
+// matching builder's. For a bare id only the value tells, see IsLegacyEntry.
+func IsStaleKey(key string, builders ...KeyBuilder) bool {
+	if legacyKey.MatchString(key) {
+		return true
+	}
+	parts := strings.SplitN(key, ":", 4)
+	if len(parts) != 4 {
+		return false
+	}
+	for _, k := range builders {
+		if parts[0] == k.Service && parts[2] == k.Entity {
+			return parts[1] != fmt.Sprintf("v%d", k.Version)
+		}
+	}
+	return false
+}
+
+// IsLegacyEntry reports whether key and its value are a record cached by
+// the first version of the service: a bare MySQL id or ObjectID holding the
+// JSON of that record, {"id", "name", "email"}. A bare id alone could be
+// any other application's key when Redis is shared.
+func IsLegacyEntry(key string, value []byte) bool {
+	if !legacyKey.MatchString(key) {
+		return false
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(value, &fields) != nil || len(fields) != 3 {
+		return false
+	}
+	var name, email string
+	if json.Unmarshal(fields["name"], &name) != nil || json.Unmarshal(fields["email"], &email) != nil {
+		return false
+	}
+	// users have numeric ids, persons ObjectIDs in hex
+	var id any
+	if json.Unmarshal(fields["id"], &id) != nil {
+		return false
+	}
+	switch id := id.(type) {
+	case float64:
+		return strconv.FormatFloat(id, 'f', -1, 64) == key
+	case string:
+		return len(key) == 24 && id == key
+	}
+	return false
+}
+
+// CleanupLegacyKeys deletes the keys older versions of the service left
+// behind: the keys of builders whose schema version is not theirs, and the
+// bare ids for which IsLegacyEntry is true. Other keys are left alone, so a
+// Redis shared with other applications is safe. With dryRun it only counts
+// them. It returns the number of keys removed, or that would be.
+func CleanupLegacyKeys(ctx context.Context, client *redis.Client, dryRun bool, builders ...KeyBuilder) (int, error) {
+	remove := func(keys []string) (int, error) {
+		if !dryRun {
+			if err := client.Del(ctx, keys...).Err(); err != nil {
+				return 0, err
+			}
+		}
+		return len(keys), nil
+	}
+	removed := 0
+	for _, k := range builders {
+		n, err := scanKeys(ctx, client, k.Service+":v*:"+k.Entity+":*", func(key string) bool {
+			return IsStaleKey(key, k)
+		}, remove)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	n, err := scanKeys(ctx, client, "[0-9a-f]*", legacyKey.MatchString, func(keys []string) (int, error) {
+		values, err := client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return 0, err
+		}
+		var legacy []string
+		for i, v := range values {
+			if value, ok := v.(string); ok && IsLegacyEntry(keys[i], []byte(value)) {
+				legacy = append(legacy, keys[i])
+			}
+		}
+		if len(legacy) == 0 {
+			return 0, nil
+		}
+		return remove(legacy)
+	})
+	return removed + n, err
+}
+
+// FlushKeys deletes every current key of the given entities: records, stale
+// copies and tombstones. It returns the number of keys removed.
+func FlushKeys(ctx context.Context, client *redis.Client, builders ...KeyBuilder) (int, error) {
+	removed := 0
+	for _, k := range builders {
+		n, err := deleteKeys(ctx, client, k.Pattern(), func(string) bool { return true })
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// deleteKeys scans for pattern and deletes the keys accepted by match in
+// batches.
+func deleteKeys(ctx context.Context, client *redis.Client, pattern string, match func(key string) bool) (int, error) {
+	return scanKeys(ctx, client, pattern, match, func(keys []string) (int, error) {
+		if err := client.Del(ctx, keys...).Err(); err != nil {
+			return 0, err
+		}
+		return len(keys), nil
+	})
+}
+
+// scanKeys scans for pattern and hands the keys accepted by match to apply
+// in batches, adding up the counts it returns.
+func scanKeys(ctx context.Context, client *redis.Client, pattern string, match func(key string) bool, apply func(keys []string) (int, error)) (int, error) {
+	total := 0
+	iter := client.Scan(ctx, 0, pattern, 500).Iterator()
+	var batch []string
+	for iter.Next(ctx) {
+		if match(iter.Val()) {
+			batch = append(batch, iter.Val())
+		}
+		if len(batch) == 500 {
+			n, err := apply(batch)
+			total += n
+			if err != nil {
+				return total, err
+			}
+			batch = batch[:0]
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return total, err
+	}
+	if len(batch) > 0 {
+		n, err := apply(batch)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,88 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"redisDatabase/hybridsystem"
+	"testing"
+	"time"
+)
+
+func TestKeyBuilder_Key(t *testing.T) {
+	if got := hybridsystem.UserKeys.Key("42"); got != "svc:v3:users:42" {
+		t.Errorf("expected svc:v3:users:42, got %s", got)
+	}
+	if got := hybridsystem.PersonKeys.Key("65a000000000000000000000"); got != "svc:v3:persons:65a000000000000000000000" {
+		t.Errorf("unexpected person key %s", got)
+	}
+	if got := hybridsystem.UserKeys.Pattern(); got != "svc:v3:users:*" {
+		t.Errorf("unexpected pattern %s", got)
+	}
+}
+
+func TestIsStaleKey(t *testing.T) {
+	tests := []struct {
+		name  string // description of this test case
+		key   string
+		stale bool
+	}{
+		{name: "bare mysql id", key: "42", stale: true},
+		{name: "bare object id", key: "65a000000000000000000000", stale: true},
+		{name: "current user key", key: "svc:v3:users:42", stale: false},
+		{name: "older schema version", key: "svc:v1:users:42", stale: true},
+		{name: "previous schema version", key: "svc:v2:persons:65a000000000000000000000", stale: true},
+		{name: "unknown entity", key: "svc:v0:orders:42", stale: false},
+		{name: "foreign key", key: "session:abc", stale: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hybridsystem.IsStaleKey(tt.key, hybridsystem.UserKeys, hybridsystem.PersonKeys)
+			if got != tt.stale {
+				t.Errorf("IsStaleKey(%q) = %v, want %v", tt.key, got, tt.stale)
+			}
+		})
+	}
+}
+
+func TestIsLegacyEntry(t *testing.T) {
+	tests := []struct {
+		name   string // description of this test case
+		key    string
+		value  string
+		legacy bool
+	}{
+		{name: "mysql user", key: "42", value: `{"id":42,"name":"Akash","email":"akash@gmail.com"}`, legacy: true},
+		{name: "mongo person", key: "65a000000000000000000000", value: `{"id":"65a000000000000000000000","name":"Paul","email":"paul@gmail.com"}`, legacy: true},
+		{name: "id of another record", key: "42", value: `{"id":7,"name":"Akash","email":"akash@gmail.com"}`},
+		{name: "numeric id as a string", key: "42", value: `{"id":"42","name":"Akash","email":"akash@gmail.com"}`},
+		{name: "more fields", key: "42", value: `{"id":42,"name":"Akash","email":"akash@gmail.com","version":1}`},
+		{name: "another app's counter", key: "42", value: `17`},
+		{name: "another app's json", key: "42", value: `{"id":42,"total":3,"email":"akash@gmail.com"}`},
+		{name: "namespaced key", key: "svc:v3:users:42", value: `{"id":42,"name":"Akash","email":"akash@gmail.com"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := hybridsystem.IsLegacyEntry(tt.key, []byte(tt.value))
+			if got != tt.legacy {
+				t.Errorf("IsLegacyEntry(%q, %s) = %v, want %v", tt.key, tt.value, got, tt.legacy)
+			}
+		})
+	}
+}
+
+// Users and persons share one backend but must never read each other's
+// entries, even for the same id string.
+func TestCache_EntitiesDoNotCollide(t *testing.T) {
+	ctx := context.Background()
+	backend := hybridsystem.NewMemoryBackend()
+	users := hybridsystem.NewCache[hybridsystem.User2](backend, hybridsystem.UserKeys, time.Minute)
+	persons := hybridsystem.NewCache[hybridsystem.Person](backend, hybridsystem.PersonKeys, time.Minute)
+
+	users.Put(ctx, "1", &hybridsystem.User2{ID: 1, Name: "user"})
+	persons.Put(ctx, "1", &hybridsystem.Person{Name: "person"})
+
+	user, _ := users.Fetch(ctx, "1", nil)
+	person, _ := persons.Fetch(ctx, "1", nil)
+	if user.Name != "user" || person.Name != "person" {
+		t.Fatalf("entries collided: user %q, person %q", user.Name, person.Name)
+	}
+}
@@ -0,0 +1,191 @@
+package hybridsystem
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrInvalidSort is returned for a ListQuery sorted by a field that is not
+// one of the sort fields.
+var ErrInvalidSort = errors.New("invalid sort field")
+
+// ListQuery selects one page of records. Pages are keyset based: Cursor is
+// the opaque token returned as NextCursor by the previous page.
+// CreatedSince and UpdatedSince, unless zero, keep the records created or
+// last written at or after that time.
+type ListQuery struct {
+	Limit        int
+	Cursor       string
+	Sort         string
+	EmailDomain  string
+	CreatedSince time.Time
+	UpdatedSince time.Time
+	WithTotal    bool
+}
+
+// normalized fills in the defaults for queries built by hand rather than by
+// ParseListQuery, and checks the sort field: the stores build their queries
+// from it.
+func (q ListQuery) normalized() (ListQuery, error) {
+	if q.Sort == "" {
+		q.Sort = "id"
+	}
+	if !sortFields[q.Sort] {
+		return q, fmt.Errorf("%w: cannot sort by %q", ErrInvalidSort, q.Sort)
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultListLimit
+	}
+	return q, nil
+}
+
+// Page is one page of a list response. Total is only set when asked for.
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	Total      *int64 `json:"total,omitempty"`
+}
+
+// sortFields are the fields a list can be ordered by. The id always breaks
+// ties so that the order is total and cursors are stable.
+var sortFields = map[string]bool{"id": true, "name": true, "created_at": true, "updated_at": true}
+
+// timeFields are the sort fields holding times. Entity.Field formats them
+// with timeKeyLayout, which sorts like the times themselves.
+var timeFields = map[string]bool{"created_at": true, "updated_at": true}
+
+// timeKeyLayout is fixed-width UTC with microseconds, the precision of the
+// MySQL columns.
+const timeKeyLayout = "2006-01-02T15:04:05.000000Z"
+
+func timeKey(t time.Time) string {
+	return t.UTC().Format(timeKeyLayout)
+}
+
+// cursorValue is the sort value of a cursor as the databases compare it: a
+// time for the time fields, the string otherwise.
+func cursorValue(sort, value string) (any, error) {
+	if !timeFields[sort] {
+		return value, nil
+	}
+	t, err := time.Parse(timeKeyLayout, value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return t, nil
+}
+
+// cursor is the position after the last item of a page.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v,omitempty"`
+	ID    string `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor returns the zero cursor for an empty token.
+func decodeCursor(token, sort string) (cursor, error) {
+	var c cursor
+	if token == "" {
+		return c, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil || c.ID == "" {
+		return c, ErrInvalidCursor
+	}
+	if c.Sort != sort {
+		return c, fmt.Errorf("%w: cursor was issued for sort=%s", ErrInvalidCursor, c.Sort)
+	}
+	return c, nil
+}
+
+// nextCursor builds the token pointing after last, or "" if there is no
+// further page.
+func nextCursor[T any, P Entity[T]](q ListQuery, items []T, more bool) string {
+	if !more || len(items) == 0 {
+		return ""
+	}
+	last := P(&items[len(items)-1])
+	c := cursor{Sort: q.Sort, ID: last.Key()}
+	if q.Sort != "id" {
+		c.Value = last.Field(q.Sort)
+	}
+	return encodeCursor(c)
+}
+
+// ParseListQuery reads limit, cursor, sort, email_domain, created_since,
+// updated_since and count from the query string. The times are RFC 3339.
+func ParseListQuery(r *http.Request) (ListQuery, error) {
+	values := r.URL.Query()
+	q := ListQuery{
+		Limit:       DefaultListLimit,
+		Cursor:      values.Get("cursor"),
+		Sort:        values.Get("sort"),
+		EmailDomain: strings.ToLower(strings.TrimPrefix(values.Get("email_domain"), "@")),
+	}
+	if q.Sort == "" {
+		q.Sort = "id"
+	}
+	if !sortFields[q.Sort] {
+		return q, fmt.Errorf("%w: cannot sort by %q", ErrInvalidSort, q.Sort)
+	}
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return q, fmt.Errorf("limit must be a positive number")
+		}
+		q.Limit = min(n, MaxListLimit)
+	}
+	for _, since := range []struct {
+		param string
+		field *time.Time
+	}{
+		{"created_since", &q.CreatedSince},
+		{"updated_since", &q.UpdatedSince},
+	} {
+		if value := values.Get(since.param); value != "" {
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return q, fmt.Errorf("%s must be an RFC 3339 time such as 2006-01-02T15:04:05Z", since.param)
+			}
+			*since.field = t.UTC()
+		}
+	}
+	if count := values.Get("count"); count != "" {
+		withTotal, err := strconv.ParseBool(count)
+		if err != nil {
+			return q, fmt.Errorf("count must be true or false")
+		}
+		q.WithTotal = withTotal
+	}
+	return q, nil
+}
+
+// compareKeys orders ids the way the databases do: numerically for MySQL
+// ids and byte-wise for the fixed-length hex of ObjectIDs.
+func compareKeys(a, b string) int {
+	if len(a) != len(b) {
+		return len(a) - len(b)
+	}
+	return strings.Compare(a, b)
+}
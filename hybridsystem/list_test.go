@@ -0,0 +1,226 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+	"time"
+)
+
+func seedUsers(t *testing.T, store hybridsystem.UserStore[hybridsystem.User2]) {
+	t.Helper()
+	for _, u := range []hybridsystem.User2{
+		{Name: "dave", Email: "dave@gmail.com"},
+		{Name: "alice", Email: "alice@example.com"},
+		{Name: "carol", Email: "carol@gmail.com"},
+		{Name: "bob", Email: "bob@GMAIL.com"},
+		{Name: "alice", Email: "alice2@gmail.com"},
+	} {
+		if err := store.Create(context.Background(), &u); err != nil {
+			t.Fatalf("seed failed: %v", err)
+		}
+	}
+}
+
+// listAll follows next_cursor until the last page and returns the names in
+// the order they were served.
+func listAll(t *testing.T, handle *hybridsystem.HybridHandler3, query string) ([]string, int) {
+	t.Helper()
+	var names []string
+	pages := 0
+	cursor := ""
+	for {
+		r := httptest.NewRequest(http.MethodGet, "/users?"+query+"&cursor="+cursor, nil)
+		w := httptest.NewRecorder()
+		handle.ListUsersHandler3(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status ok, got %d: %s", w.Code, w.Body.String())
+		}
+		var page hybridsystem.Page[hybridsystem.User2]
+		if err := json.NewDecoder(w.Body).Decode(&page); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		pages++
+		for _, u := range page.Items {
+			names = append(names, u.Name)
+		}
+		if page.NextCursor == "" {
+			return names, pages
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func TestHybridHandler3_ListUsersHandler3(t *testing.T) {
+	store := hybridsystem.NewMemoryUserStore()
+	seedUsers(t, store)
+	handle := &hybridsystem.HybridHandler3{Users: store}
+
+	tests := []struct {
+		name      string // description of this test case
+		query     string
+		wantNames []string
+		wantPages int
+	}{
+		{
+			name:      "default order by id",
+			query:     "limit=2",
+			wantNames: []string{"dave", "alice", "carol", "bob", "alice"},
+			wantPages: 3,
+		},
+		{
+			name:      "sorted by name with ties broken by id",
+			query:     "limit=2&sort=name",
+			wantNames: []string{"alice", "alice", "bob", "carol", "dave"},
+			wantPages: 3,
+		},
+		{
+			name:      "filtered by email domain",
+			query:     "limit=10&email_domain=gmail.com",
+			wantNames: []string{"dave", "carol", "bob", "alice"},
+			wantPages: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, pages := listAll(t, handle, tt.query)
+			if len(names) != len(tt.wantNames) {
+				t.Fatalf("expected %v, got %v", tt.wantNames, names)
+			}
+			for i := range names {
+				if names[i] != tt.wantNames[i] {
+					t.Fatalf("expected %v, got %v", tt.wantNames, names)
+				}
+			}
+			if pages != tt.wantPages {
+				t.Errorf("expected %d pages, got %d", tt.wantPages, pages)
+			}
+		})
+	}
+}
+
+func TestHybridHandler3_ListUsersHandler3_Total(t *testing.T) {
+	store := hybridsystem.NewMemoryUserStore()
+	seedUsers(t, store)
+	handle := &hybridsystem.HybridHandler3{Users: store}
+
+	r := httptest.NewRequest(http.MethodGet, "/users?limit=1&count=true", nil)
+	w := httptest.NewRecorder()
+	handle.ListUsersHandler3(w, r)
+
+	var page hybridsystem.Page[hybridsystem.User2]
+	json.NewDecoder(w.Body).Decode(&page)
+	if page.Total == nil || *page.Total != 5 {
+		t.Fatalf("expected total 5, got %v", page.Total)
+	}
+	if len(page.Items) != 1 || page.NextCursor == "" {
+		t.Fatalf("expected one item and a next cursor, got %+v", page)
+	}
+}
+
+func TestHybridHandler3_ListUsersHandler3_Timestamps(t *testing.T) {
+	ctx := context.Background()
+	store := hybridsystem.NewMemoryUserStore()
+	seedUsers(t, store)
+	handle := &hybridsystem.HybridHandler3{Users: store}
+	time.Sleep(2 * time.Millisecond)
+	since := time.Now().UTC()
+	for _, id := range []string{"3", "1"} {
+		time.Sleep(2 * time.Millisecond)
+		u, _ := store.Get(ctx, id)
+		if err := store.Update(ctx, id, u); err != nil {
+			t.Fatalf("update failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string // description of this test case
+		query     string
+		wantNames []string
+	}{
+		{
+			name:      "sorted by updated_at",
+			query:     "limit=2&sort=updated_at",
+			wantNames: []string{"alice", "bob", "alice", "carol", "dave"},
+		},
+		{
+			name:      "sorted by created_at",
+			query:     "limit=2&sort=created_at",
+			wantNames: []string{"dave", "alice", "carol", "bob", "alice"},
+		},
+		{
+			name:      "updated since",
+			query:     "updated_since=" + url.QueryEscape(since.Format(time.RFC3339Nano)),
+			wantNames: []string{"dave", "carol"},
+		},
+		{
+			name:      "created since",
+			query:     "created_since=" + url.QueryEscape(since.Format(time.RFC3339Nano)),
+			wantNames: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, _ := listAll(t, handle, tt.query)
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("expected %v, got %v", tt.wantNames, names)
+			}
+		})
+	}
+}
+
+func TestHybridHandler3_ListUsersHandler3_BadInput(t *testing.T) {
+	handle := &hybridsystem.HybridHandler3{Users: hybridsystem.NewMemoryUserStore()}
+
+	tests := []struct {
+		name  string // description of this test case
+		query string
+	}{
+		{name: "unknown sort field", query: "sort=password"},
+		{name: "negative limit", query: "limit=-1"},
+		{name: "garbage cursor", query: "cursor=not-a-cursor"},
+		{name: "malformed updated_since", query: "updated_since=yesterday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/users?"+tt.query, nil)
+			w := httptest.NewRecorder()
+			handle.ListUsersHandler3(w, r)
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected bad request, got %d", w.Code)
+			}
+		})
+	}
+}
+
+// Queries built by hand skip ParseListQuery; the stores check the sort
+// field themselves.
+func TestMemoryStore_ListInvalidSort(t *testing.T) {
+	store := hybridsystem.NewMemoryUserStore()
+	seedUsers(t, store)
+
+	tests := []struct {
+		name    string // description of this test case
+		sort    string
+		wantErr error
+	}{
+		{name: "default", sort: ""},
+		{name: "known field", sort: "name"},
+		{name: "unknown field", sort: "password", wantErr: hybridsystem.ErrInvalidSort},
+		{name: "sql", sort: "id; DROP TABLE users", wantErr: hybridsystem.ErrInvalidSort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := store.List(context.Background(), hybridsystem.ListQuery{Sort: tt.sort})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package hybridsystem
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RequestIDHeader carries the request id in both directions.
+const RequestIDHeader = "X-Request-ID"
+
+// LogConfig selects the log output. Format is "text" or "json"; Level is
+// any level slog understands ("debug", "info", "warn", "error").
+type LogConfig struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+}
+
+// NewLogger builds the process logger writing to w.
+func NewLogger(w io.Writer, cfg LogConfig) (*slog.Logger, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", cfg.Level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch cfg.Format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text", "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, want text or json", cfg.Format)
+	}
+}
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	requestLogKey
+	actorKey
+)
+
+// RequestID makes sure every request has an id: it keeps a sane incoming
+// X-Request-ID so ids can be followed across services, and otherwise makes
+// one up. The id is echoed in the response and available via
+// RequestIDFrom.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// RequestIDFrom returns the id assigned by RequestID, or "".
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// requestLog collects attributes that handlers attach to the access log
+// line of the request they serve.
+type requestLog struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// AddLogAttrs attaches attrs, such as the entity id or cache outcome, to the
+// access log line of the request behind ctx. It does nothing outside
+// AccessLog.
+func AddLogAttrs(ctx context.Context, attrs ...slog.Attr) {
+	if l, ok := ctx.Value(requestLogKey).(*requestLog); ok {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.attrs = append(l.attrs, attrs...)
+	}
+}
+
+// AccessLog writes one line per request with the request id, route, status,
+// duration and whatever the handler added with AddLogAttrs. Put it after
+// RequestID.
+func AccessLog(logger *slog.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rl := &requestLog{}
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestLogKey, rl)))
+
+			level := slog.LevelInfo
+			if rec.status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			attrs := []slog.Attr{
+				slog.String("request_id", RequestIDFrom(r.Context())),
+				slog.String("method", r.Method),
+				slog.String("route", routeTemplate(r)),
+				slog.Int("status", rec.status),
+				slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			}
+			rl.mu.Lock()
+			attrs = append(attrs, rl.attrs...)
+			rl.mu.Unlock()
+			logger.LogAttrs(r.Context(), level, "request", attrs...)
+		})
+	}
+}
+
+// RequestLogger returns logger annotated with the request id of r, for log
+// lines written while serving r. A nil logger means slog.Default().
+func RequestLogger(logger *slog.Logger, r *http.Request) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if id := RequestIDFrom(r.Context()); id != "" {
+		return logger.With("request_id", id, "route", routeTemplate(r))
+	}
+	return logger
+}
@@ -0,0 +1,124 @@
+package hybridsystem_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := hybridsystem.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = hybridsystem.RequestIDFrom(r.Context())
+	}))
+
+	tests := []struct {
+		name     string // description of this test case
+		incoming string
+		wantKept bool
+	}{
+		{name: "incoming id is propagated", incoming: "abc-123", wantKept: true},
+		{name: "missing id is generated", incoming: ""},
+		{name: "id with spaces is replaced", incoming: "not a valid id"},
+		{name: "overlong id is replaced", incoming: strings.Repeat("x", 200)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/users", nil)
+			if tt.incoming != "" {
+				r.Header.Set(hybridsystem.RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			got := w.Header().Get(hybridsystem.RequestIDHeader)
+			if got == "" || got != seen {
+				t.Fatalf("expected the response header %q to match the context id %q", got, seen)
+			}
+			if tt.wantKept != (got == tt.incoming) {
+				t.Fatalf("incoming %q, got %q", tt.incoming, got)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := hybridsystem.NewLogger(&buf, hybridsystem.LogConfig{Format: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := hybridsystem.NewMemoryUserStore()
+	seedUsers(t, store)
+	handle := &hybridsystem.HybridHandler3{
+		Users:     store,
+		UserCache: hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute),
+		Logger:    logger,
+	}
+	r := mux.NewRouter()
+	r.Use(hybridsystem.RequestID, hybridsystem.AccessLog(logger))
+	r.HandleFunc("/users/{id}", handle.GetUserHandler3).Methods("GET")
+
+	for _, id := range []string{"req-1", "req-2"} {
+		req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+		req.Header.Set(hybridsystem.RequestIDHeader, id)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected one line per request, got %d:\n%s", len(lines), buf.String())
+	}
+	for i, want := range []struct{ requestID, cache string }{{"req-1", "miss"}, {"req-2", "hit"}} {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("line %d is not JSON: %v", i, err)
+		}
+		expect := map[string]any{
+			"msg":        "request",
+			"request_id": want.requestID,
+			"route":      "/users/{id}",
+			"method":     "GET",
+			"status":     float64(http.StatusOK),
+			"entity":     "user",
+			"entity_id":  "1",
+			"cache":      want.cache,
+		}
+		for key, value := range expect {
+			if entry[key] != value {
+				t.Errorf("line %d: expected %s=%v, got %v", i, key, value, entry[key])
+			}
+		}
+		if _, ok := entry["duration_ms"].(float64); !ok {
+			t.Errorf("line %d: missing duration_ms", i)
+		}
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	tests := []struct {
+		name     string // description of this test case
+		cfg      hybridsystem.LogConfig
+		willpass bool
+	}{
+		{name: "text", cfg: hybridsystem.LogConfig{Format: "text", Level: "debug"}, willpass: true},
+		{name: "json", cfg: hybridsystem.LogConfig{Format: "json", Level: "warn"}, willpass: true},
+		{name: "unknown format", cfg: hybridsystem.LogConfig{Format: "xml"}, willpass: false},
+		{name: "unknown level", cfg: hybridsystem.LogConfig{Format: "text", Level: "loud"}, willpass: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := hybridsystem.NewLogger(&bytes.Buffer{}, tt.cfg)
+			if tt.willpass != (err == nil) {
+				t.Fatalf("willpass=%v, got error %v", tt.willpass, err)
+			}
+		})
+	}
+}
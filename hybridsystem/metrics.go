@@ -0,0 +1,458 @@
+package hybridsystem
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/redis/go-redis/v9"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of every latency
+// histogram. They match the Prometheus client defaults.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collects request, cache and database metrics and serves them in
+// the Prometheus text exposition format. Series are keyed by their rendered
+// label set.
+type Metrics struct {
+	mu              sync.Mutex
+	requests        map[string]uint64
+	requestDuration map[string]*histogram
+	dbDuration      map[string]*histogram
+	dbErrors        map[string]uint64
+	dbOutcomes      map[string]uint64
+	caches          map[string]func() CacheStats
+	pools           map[string]func() sql.DBStats
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests:        make(map[string]uint64),
+		requestDuration: make(map[string]*histogram),
+		dbDuration:      make(map[string]*histogram),
+		dbErrors:        make(map[string]uint64),
+		dbOutcomes:      make(map[string]uint64),
+		caches:          make(map[string]func() CacheStats),
+		pools:           make(map[string]func() sql.DBStats),
+	}
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, le := range latencyBuckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// ObserveRequest records one served HTTP request.
+func (m *Metrics) ObserveRequest(route, method string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[labels("route", route, "method", method, "status", strconv.Itoa(status))]++
+	observe(m.requestDuration, labels("route", route, "method", method), d)
+}
+
+// ObserveDB records one operation against a database and its outcome, see
+// dbOutcome. Only failures of the database itself count as errors: a lookup
+// that found nothing or a write refused because of the request is an
+// outcome the client caused.
+func (m *Metrics) ObserveDB(system, op string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := labels("system", system, "op", op)
+	observe(m.dbDuration, key, d)
+	outcome := dbOutcome(err)
+	if outcome == "error" {
+		m.dbErrors[key]++
+	}
+	m.dbOutcomes[labels("system", system, "op", op, "outcome", outcome)]++
+}
+
+// dbOutcome classifies the result of a database operation.
+func dbOutcome(err error) string {
+	var dup *DuplicateError
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrInvalidID), errors.Is(err, ErrInvalidCursor), errors.Is(err, ErrInvalidSort):
+		return "invalid"
+	case errors.As(err, &dup):
+		return "conflict"
+	case errors.Is(err, ErrVersionMismatch):
+		return "version_mismatch"
+	}
+	return "error"
+}
+
+func observe(series map[string]*histogram, key string, d time.Duration) {
+	h, ok := series[key]
+	if !ok {
+		h = &histogram{}
+		series[key] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// RegisterCache exports the counters of the cache for entity, typically
+// RegisterCache("user", userCache.Stats).
+func (m *Metrics) RegisterCache(entity string, stats func() CacheStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.caches[entity] = stats
+}
+
+// RegisterDBPool exports connection pool gauges, typically
+// RegisterDBPool("mysql", db.Stats).
+func (m *Metrics) RegisterDBPool(name string, stats func() sql.DBStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pools[name] = stats
+}
+
+// Middleware records the count and latency of every request under the
+// route's path template, so /users/42 and /users/43 share a series.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		m.ObserveRequest(routeTemplate(r), r.Method, rec.status, time.Since(start))
+	})
+}
+
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			return tmpl
+		}
+	}
+	return "unmatched"
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// ServeHTTP serves GET /metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes every metric in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+
+	writeCounters(&b, "http_requests_total", "HTTP requests by route, method and status.", m.requests)
+	writeHistograms(&b, "http_request_duration_seconds", "HTTP request latency by route and method.", m.requestDuration)
+	writeHistograms(&b, "db_operation_duration_seconds", "MySQL, MongoDB and Redis operation latency.", m.dbDuration)
+	writeCounters(&b, "db_operation_errors_total", "Failed MySQL, MongoDB and Redis operations.", m.dbErrors)
+	writeCounters(&b, "db_operations_total", "MySQL, MongoDB and Redis operations by outcome.", m.dbOutcomes)
+
+	cacheRequests := map[string]uint64{}
+	cacheErrors := map[string]uint64{}
+	for entity, stats := range m.caches {
+		s := stats()
+		cacheRequests[labels("entity", entity, "result", "hit")] = s.Hits
+		cacheRequests[labels("entity", entity, "result", "miss")] = s.Misses
+		cacheRequests[labels("entity", entity, "result", "not_found")] = s.NotFound
+		cacheRequests[labels("entity", entity, "result", "stale")] = s.Stale
+		cacheErrors[labels("entity", entity)] = s.Errors
+	}
+	writeCounters(&b, "cache_requests_total", "Cache lookups by entity and result.", cacheRequests)
+	writeCounters(&b, "cache_errors_total", "Cache backend and decoding errors by entity.", cacheErrors)
+
+	pool := []struct {
+		name, help, kind string
+		value            func(s sql.DBStats) float64
+	}{
+		{"db_pool_max_open_connections", "Maximum number of open connections.", "gauge", func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"db_pool_open_connections", "Established connections, in use and idle.", "gauge", func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"db_pool_in_use_connections", "Connections currently in use.", "gauge", func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"db_pool_idle_connections", "Idle connections.", "gauge", func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"db_pool_waits_total", "Connections waited for.", "counter", func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"db_pool_wait_seconds_total", "Time blocked waiting for a connection.", "counter", func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+	}
+	stats := map[string]sql.DBStats{}
+	for name, fn := range m.pools {
+		stats[name] = fn()
+	}
+	for _, p := range pool {
+		values := map[string]float64{}
+		for name, s := range stats {
+			values[labels("db", name)] = p.value(s)
+		}
+		writeFamily(&b, p.name, p.help, p.kind, values)
+	}
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeCounters(b *strings.Builder, name, help string, series map[string]uint64) {
+	values := make(map[string]float64, len(series))
+	for k, v := range series {
+		values[k] = float64(v)
+	}
+	writeFamily(b, name, help, "counter", values)
+}
+
+func writeFamily(b *strings.Builder, name, help, kind string, series map[string]float64) {
+	if len(series) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, key := range sortedKeys(series) {
+		fmt.Fprintf(b, "%s{%s} %s\n", name, key, formatFloat(series[key]))
+	}
+}
+
+func writeHistograms(b *strings.Builder, name, help string, series map[string]*histogram) {
+	if len(series) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, key := range sortedKeys(series) {
+		h := series[key]
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(b, "%s_bucket{%s,le=\"%s\"} %d\n", name, key, formatFloat(le), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, key, h.count)
+		fmt.Fprintf(b, "%s_sum{%s} %s\n", name, key, formatFloat(h.sum))
+		fmt.Fprintf(b, "%s_count{%s} %d\n", name, key, h.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// labels renders name/value pairs as `a="1",b="2"`, escaping the values.
+func labels(pairs ...string) string {
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+`="`+escape.Replace(pairs[i+1])+`"`)
+	}
+	return strings.Join(parts, ",")
+}
+
+// InstrumentResource times every store call of res under system ("mysql" or
+// "mongo"). It wraps Store and, when set, Trash, History and Batch, so call
+// it once they are wired: the trash, history and batch routes stay as they
+// are.
+func InstrumentResource[T any, P Entity[T]](res *Resource[T, P], m *Metrics, system string) {
+	obs := observer{metrics: m, system: system}
+	res.Store = &instrumentedStore[T]{store: res.Store, observer: obs}
+	if res.Trash != nil {
+		res.Trash = &instrumentedTrash[T]{trash: res.Trash, observer: obs}
+	}
+	if res.History != nil {
+		res.History = &instrumentedAudit{log: res.History, observer: obs}
+	}
+	if res.Batch != nil {
+		res.Batch = &instrumentedBatch[T]{batch: res.Batch, observer: obs}
+	}
+}
+
+type observer struct {
+	metrics *Metrics
+	system  string
+}
+
+func (o observer) observe(op string, start time.Time, err error) {
+	o.metrics.ObserveDB(o.system, op, time.Since(start), err)
+}
+
+type instrumentedStore[T any] struct {
+	store UserStore[T]
+	observer
+}
+
+func (s *instrumentedStore[T]) Create(ctx context.Context, v *T) error {
+	start := time.Now()
+	err := s.store.Create(ctx, v)
+	s.observe("create", start, err)
+	return err
+}
+
+func (s *instrumentedStore[T]) Get(ctx context.Context, id string) (*T, error) {
+	start := time.Now()
+	v, err := s.store.Get(ctx, id)
+	s.observe("get", start, err)
+	return v, err
+}
+
+func (s *instrumentedStore[T]) Update(ctx context.Context, id string, v *T) error {
+	start := time.Now()
+	err := s.store.Update(ctx, id, v)
+	s.observe("update", start, err)
+	return err
+}
+
+func (s *instrumentedStore[T]) Delete(ctx context.Context, id string) error {
+	start := time.Now()
+	err := s.store.Delete(ctx, id)
+	s.observe("delete", start, err)
+	return err
+}
+
+func (s *instrumentedStore[T]) DeleteVersion(ctx context.Context, id string, version int64) error {
+	start := time.Now()
+	err := s.store.DeleteVersion(ctx, id, version)
+	s.observe("delete", start, err)
+	return err
+}
+
+func (s *instrumentedStore[T]) List(ctx context.Context, q ListQuery) (Page[T], error) {
+	start := time.Now()
+	page, err := s.store.List(ctx, q)
+	s.observe("list", start, err)
+	return page, err
+}
+
+type instrumentedTrash[T any] struct {
+	trash TrashStore[T]
+	observer
+}
+
+func (s *instrumentedTrash[T]) SoftDelete(ctx context.Context, id string, version int64) error {
+	start := time.Now()
+	err := s.trash.SoftDelete(ctx, id, version)
+	s.observe("soft_delete", start, err)
+	return err
+}
+
+func (s *instrumentedTrash[T]) Trash(ctx context.Context, q ListQuery) (Page[T], error) {
+	start := time.Now()
+	page, err := s.trash.Trash(ctx, q)
+	s.observe("trash", start, err)
+	return page, err
+}
+
+func (s *instrumentedTrash[T]) Restore(ctx context.Context, id string) (*T, error) {
+	start := time.Now()
+	v, err := s.trash.Restore(ctx, id)
+	s.observe("restore", start, err)
+	return v, err
+}
+
+func (s *instrumentedTrash[T]) Purge(ctx context.Context, before time.Time) (int64, error) {
+	start := time.Now()
+	n, err := s.trash.Purge(ctx, before)
+	s.observe("purge", start, err)
+	return n, err
+}
+
+type instrumentedAudit struct {
+	log AuditLog
+	observer
+}
+
+func (s *instrumentedAudit) History(ctx context.Context, id string) ([]AuditEntry, error) {
+	start := time.Now()
+	entries, err := s.log.History(ctx, id)
+	s.observe("history", start, err)
+	return entries, err
+}
+
+type instrumentedBatch[T any] struct {
+	batch BatchStore[T]
+	observer
+}
+
+func (s *instrumentedBatch[T]) Batch(ctx context.Context, ops []BatchOp[T], opts BatchOptions) ([]error, error) {
+	start := time.Now()
+	errs, err := s.batch.Batch(ctx, ops, opts)
+	s.observe("batch", start, err)
+	return errs, err
+}
+
+// RedisHook returns a go-redis hook timing every command under system
+// "redis"; install it with client.AddHook.
+func (m *Metrics) RedisHook() redis.Hook {
+	return redisHook{metrics: m}
+}
+
+type redisHook struct {
+	metrics *Metrics
+}
+
+func (h redisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h redisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.metrics.ObserveDB("redis", cmd.Name(), time.Since(start), redisError(err))
+		return err
+	}
+}
+
+func (h redisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		h.metrics.ObserveDB("redis", "pipeline", time.Since(start), redisError(err))
+		return err
+	}
+}
+
+// redisError drops redis.Nil, which only means the key does not exist.
+func redisError(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
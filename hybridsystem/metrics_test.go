@@ -0,0 +1,169 @@
+package hybridsystem_test
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMetrics_Endpoint(t *testing.T) {
+	metrics := hybridsystem.NewMetrics()
+	store := hybridsystem.NewMemoryUserStore()
+	seedUsers(t, store)
+	cache := hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)
+	handle := &hybridsystem.HybridHandler3{
+		Users:     store,
+		UserCache: cache,
+		Metrics:   metrics,
+	}
+	metrics.RegisterCache("user", cache.Stats)
+	metrics.RegisterDBPool("mysql", func() sql.DBStats {
+		return sql.DBStats{MaxOpenConnections: 25, OpenConnections: 3, InUse: 1, Idle: 2, WaitCount: 4}
+	})
+
+	r := mux.NewRouter()
+	r.Use(metrics.Middleware)
+	r.Handle("/metrics", metrics).Methods("GET")
+	r.HandleFunc("/users/{id}", handle.GetUserHandler3).Methods("GET")
+
+	// miss then hit for id 1, then a 404 for an unknown id
+	for _, path := range []string{"/users/1", "/users/1", "/users/999"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status ok, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body := w.Body.String()
+
+	tests := []struct {
+		name string // description of this test case
+		line string
+	}{
+		{name: "requests by route and status", line: `http_requests_total{route="/users/{id}",method="GET",status="200"} 2`},
+		{name: "not found requests", line: `http_requests_total{route="/users/{id}",method="GET",status="404"} 1`},
+		{name: "latency histogram count", line: `http_request_duration_seconds_count{route="/users/{id}",method="GET"} 3`},
+		{name: "latency histogram +Inf bucket", line: `http_request_duration_seconds_bucket{route="/users/{id}",method="GET",le="+Inf"} 3`},
+		{name: "store latency", line: `db_operation_duration_seconds_count{system="mysql",op="get"} 2`},
+		{name: "cache hits", line: `cache_requests_total{entity="user",result="hit"} 1`},
+		{name: "cache misses", line: `cache_requests_total{entity="user",result="miss"} 2`},
+		{name: "cache errors", line: `cache_errors_total{entity="user"} 0`},
+		{name: "pool gauge", line: `db_pool_in_use_connections{db="mysql"} 1`},
+		{name: "pool wait counter", line: `db_pool_waits_total{db="mysql"} 4`},
+		{name: "histogram type", line: `# TYPE http_request_duration_seconds histogram`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(body, tt.line+"\n") {
+				t.Errorf("missing %q in:\n%s", tt.line, body)
+			}
+		})
+	}
+	if strings.Contains(body, "db_operation_errors_total") {
+		t.Errorf("a not-found lookup must not count as a database error:\n%s", body)
+	}
+}
+
+func TestInstrumentResource(t *testing.T) {
+	metrics := hybridsystem.NewMetrics()
+	store := hybridsystem.NewMemoryUserStore()
+	seedUsers(t, store)
+	res := &hybridsystem.Resource[hybridsystem.User2, *hybridsystem.User2]{Name: "user", Store: store, History: store, Batch: store}
+	hybridsystem.InstrumentResource(res, metrics, "mysql")
+	r := mux.NewRouter()
+	res.Register(r, "/users")
+
+	tests := []struct {
+		name     string // description of this test case
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantOp   string
+	}{
+		{name: "history", method: http.MethodGet, path: "/users/1/history", wantCode: http.StatusOK, wantOp: "history"},
+		{name: "batch", method: http.MethodPost, path: "/users:batch", body: `{"operations":[{"op":"delete","id":"2"}]}`, wantCode: http.StatusOK, wantOp: "batch"},
+		{name: "get", method: http.MethodGet, path: "/users/1", wantCode: http.StatusOK, wantOp: "get"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, w.Code, w.Body)
+			}
+			w = httptest.NewRecorder()
+			metrics.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if line := `db_operation_duration_seconds_count{system="mysql",op="` + tt.wantOp + `"} 1`; !strings.Contains(w.Body.String(), line) {
+				t.Errorf("missing %q in:\n%s", line, w.Body)
+			}
+		})
+	}
+}
+
+func TestMetrics_ObserveRequest_Buckets(t *testing.T) {
+	metrics := hybridsystem.NewMetrics()
+	metrics.ObserveRequest("/users", "POST", http.StatusCreated, 30*time.Millisecond)
+	metrics.ObserveRequest("/users", "POST", http.StatusCreated, 3*time.Second)
+
+	var b strings.Builder
+	metrics.WriteTo(&b)
+	body := b.String()
+
+	// buckets are cumulative
+	for _, line := range []string{
+		`http_request_duration_seconds_bucket{route="/users",method="POST",le="0.025"} 0`,
+		`http_request_duration_seconds_bucket{route="/users",method="POST",le="0.05"} 1`,
+		`http_request_duration_seconds_bucket{route="/users",method="POST",le="2.5"} 1`,
+		`http_request_duration_seconds_bucket{route="/users",method="POST",le="5"} 2`,
+		`http_request_duration_seconds_sum{route="/users",method="POST"} 3.03`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetrics_ObserveDB_Outcomes(t *testing.T) {
+	tests := []struct {
+		name        string // description of this test case
+		err         error
+		wantOutcome string
+	}{
+		{name: "applied", wantOutcome: "ok"},
+		{name: "not found", err: hybridsystem.ErrNotFound, wantOutcome: "not_found"},
+		{name: "bad id", err: hybridsystem.ErrInvalidID, wantOutcome: "invalid"},
+		{name: "duplicate email", err: &hybridsystem.DuplicateError{Field: "email", Value: "akash@gmail.com", ExistingID: "1"}, wantOutcome: "conflict"},
+		{name: "stale version", err: fmt.Errorf("delete: %w", hybridsystem.ErrVersionMismatch), wantOutcome: "version_mismatch"},
+		{name: "database failure", err: errors.New("connection refused"), wantOutcome: "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := hybridsystem.NewMetrics()
+			metrics.ObserveDB("mysql", "update", time.Millisecond, tt.err)
+			var b strings.Builder
+			metrics.WriteTo(&b)
+			body := b.String()
+
+			if line := `db_operations_total{system="mysql",op="update",outcome="` + tt.wantOutcome + `"} 1`; !strings.Contains(body, line+"\n") {
+				t.Errorf("missing %q in:\n%s", line, body)
+			}
+			if counted := strings.Contains(body, "db_operation_errors_total"); counted != (tt.wantOutcome == "error") {
+				t.Errorf("expected an error counted only for a database failure, got:\n%s", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package hybridsystem
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrMigrationLocked is returned when another process held the migration
+// lock for longer than Migrator.LockTimeout.
+var ErrMigrationLocked = errors.New("another process is migrating the database")
+
+// Migration is one versioned schema change. Up and Down hold one or more
+// statements, each ending with a semicolon at the end of a line.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrations returns the migrations embedded from the migrations directory.
+func Migrations() ([]Migration, error) {
+	sub, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return LoadMigrations(sub)
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// LoadMigrations reads the files named <version>_<name>.up.sql and
+// <version>_<name>.down.sql in the root of fsys, ordered by version. Every
+// version needs both files.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, e := range entries {
+		match := migrationFile.FindStringSubmatch(e.Name())
+		if match == nil || e.IsDir() {
+			return nil, fmt.Errorf("migration %s: name is not <version>_<name>.(up|down).sql", e.Name())
+		}
+		version, err := strconv.Atoi(match[1])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: version must be a positive number", e.Name())
+		}
+		data, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if len(statements(m.Up)) == 0 || len(statements(m.Down)) == 0 {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	return migrations, nil
+}
+
+// statements splits a migration file into statements, dropping comment
+// lines.
+func statements(script string) []string {
+	var stmts []string
+	var current strings.Builder
+	flush := func() {
+		if stmt := strings.TrimSpace(current.String()); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+		current.Reset()
+	}
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		if strings.HasSuffix(trimmed, ";") {
+			current.WriteString(strings.TrimSuffix(trimmed, ";"))
+			flush()
+			continue
+		}
+		current.WriteString(line + "\n")
+	}
+	flush()
+	return stmts
+}
+
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    BIGINT       NOT NULL,
+    name       VARCHAR(255) NOT NULL,
+    applied_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (version)
+)`
+
+// Migrator applies Migrations to a MySQL database and records each applied
+// version in the schema_migrations table.
+//
+// Up and Down hold a MySQL named lock for the current database while they
+// run, so replicas starting together take turns and the later ones find
+// nothing left to do. MySQL commits DDL implicitly, so a migration that fails
+// halfway is not rolled back: keep one statement per migration or make each
+// statement safe to repeat.
+type Migrator struct {
+	DB          *sql.DB
+	Migrations  []Migration
+	LockTimeout time.Duration
+	Logger      *slog.Logger
+}
+
+// NewMigrator returns a Migrator for the embedded migrations.
+func NewMigrator(db *sql.DB, logger *slog.Logger) (*Migrator, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations, LockTimeout: time.Minute, Logger: logger}, nil
+}
+
+func (m *Migrator) logger() *slog.Logger {
+	if m.Logger == nil {
+		return slog.Default()
+	}
+	return m.Logger
+}
+
+// Up applies every migration that has not been applied yet, oldest first,
+// and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.Migrations {
+			if slices.Contains(applied, mig.Version) {
+				continue
+			}
+			if err := m.run(ctx, conn, mig, mig.Up); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", mig.Version, mig.Name); err != nil {
+				return err
+			}
+			m.logger().Info("applied migration", "version", mig.Version, "name", mig.Name)
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the latest steps applied migrations, newest first, and
+// returns them.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(applied) - 1; i >= 0 && len(done) < steps; i-- {
+			idx := slices.IndexFunc(m.Migrations, func(mig Migration) bool { return mig.Version == applied[i] })
+			if idx < 0 {
+				return fmt.Errorf("migration %d is applied but unknown to this binary", applied[i])
+			}
+			mig := m.Migrations[idx]
+			if err := m.run(ctx, conn, mig, mig.Down); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", mig.Version); err != nil {
+				return err
+			}
+			m.logger().Info("reverted migration", "version", mig.Version, "name", mig.Name)
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Version is the latest applied migration, 0 if there is none.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	if _, err := m.DB.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	err := m.DB.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version)
+	return int(version.Int64), err
+}
+
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, mig Migration, script string) error {
+	for _, stmt := range statements(script) {
+		if _, err := conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+	}
+	return nil
+}
+
+// withLock runs fn on a single connection holding the migration lock; named
+// locks belong to the connection that took them.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	const lockName = "CONCAT('schema_migrations:', DATABASE())"
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK("+lockName+", ?)", int(m.LockTimeout.Seconds())).Scan(&locked); err != nil {
+		return err
+	}
+	if locked.Int64 != 1 {
+		return ErrMigrationLocked
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT RELEASE_LOCK("+lockName+")")
+
+	if _, err := conn.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) ([]int, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var versions []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// AutoMigrate applies the pending embedded migrations at startup, see
+// MySQLConfig.AutoMigrate.
+func AutoMigrate(db *sql.DB, logger *slog.Logger) error {
+	migrator, err := NewMigrator(db, logger)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), migrator.LockTimeout+connectTimeout)
+	defer cancel()
+	_, err = migrator.Up(ctx)
+	return err
+}
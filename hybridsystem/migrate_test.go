@@ -0,0 +1,98 @@
+package hybridsystem_test
+
+import (
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrations_Embedded(t *testing.T) {
+	migrations, err := hybridsystem.Migrations()
+	if err != nil {
+		t.Fatalf("failed to load embedded migrations: %v", err)
+	}
+	if len(migrations) == 0 || migrations[0].Version != 1 {
+		t.Fatalf("expected migration 1 first, got %+v", migrations)
+	}
+	if !strings.Contains(migrations[0].Up, "CREATE TABLE IF NOT EXISTS users") || !strings.Contains(migrations[0].Up, "users_email_unique") {
+		t.Errorf("expected migration 1 to create users with a unique email, got %q", migrations[0].Up)
+	}
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version <= migrations[i-1].Version {
+			t.Errorf("migrations out of order: %d after %d", migrations[i].Version, migrations[i-1].Version)
+		}
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	file := func(s string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(s)} }
+
+	tests := []struct {
+		name         string // description of this test case
+		files        fstest.MapFS
+		wantVersions []int
+		wantErr      string
+	}{
+		{
+			name: "ordered by version, not by name",
+			files: fstest.MapFS{
+				"10_add_index.up.sql":     file("CREATE INDEX i ON users (name);"),
+				"10_add_index.down.sql":   file("DROP INDEX i ON users;"),
+				"2_create_users.up.sql":   file("-- the table\nCREATE TABLE users (id INT);"),
+				"2_create_users.down.sql": file("DROP TABLE users;"),
+			},
+			wantVersions: []int{2, 10},
+		},
+		{
+			name: "missing down",
+			files: fstest.MapFS{
+				"1_create_users.up.sql": file("CREATE TABLE users (id INT);"),
+			},
+			wantErr: "needs both an up and a down file",
+		},
+		{
+			name: "down with only comments",
+			files: fstest.MapFS{
+				"1_create_users.up.sql":   file("CREATE TABLE users (id INT);"),
+				"1_create_users.down.sql": file("-- nothing to do\n"),
+			},
+			wantErr: "needs both an up and a down file",
+		},
+		{
+			name:    "unexpected file",
+			files:   fstest.MapFS{"README.md": file("notes")},
+			wantErr: "README.md",
+		},
+		{
+			name: "two names for one version",
+			files: fstest.MapFS{
+				"1_create_users.up.sql": file("CREATE TABLE users (id INT);"),
+				"1_make_users.down.sql": file("DROP TABLE users;"),
+			},
+			wantErr: "named both",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := hybridsystem.LoadMigrations(tt.files)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error mentioning %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(migrations) != len(tt.wantVersions) {
+				t.Fatalf("expected versions %v, got %+v", tt.wantVersions, migrations)
+			}
+			for i, v := range tt.wantVersions {
+				if migrations[i].Version != v {
+					t.Errorf("expected version %d at %d, got %d", v, i, migrations[i].Version)
+				}
+			}
+		})
+	}
+}
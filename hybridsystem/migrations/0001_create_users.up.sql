@@ -0,0 +1,9 @@
+-- users is the table MySQLUserStore reads and writes. IF NOT EXISTS lets
+-- databases created by hand before migrations existed adopt this version.
+CREATE TABLE IF NOT EXISTS users (
+    id    INT          NOT NULL AUTO_INCREMENT,
+    name  VARCHAR(100) NOT NULL,
+    email VARCHAR(254) NOT NULL,
+    PRIMARY KEY (id),
+    UNIQUE KEY users_email_unique (email)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,3 @@
+-- version counts the writes of a user; conditional updates compare it to
+-- the ETag the client sent. Existing rows start at 1.
+ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
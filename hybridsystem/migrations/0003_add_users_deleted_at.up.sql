@@ -0,0 +1,3 @@
+-- deleted_at is set while a user is in the trash; the index serves the
+-- trash listing and the purge job.
+ALTER TABLE users ADD COLUMN deleted_at DATETIME(6) NULL, ADD INDEX users_deleted_at (deleted_at);
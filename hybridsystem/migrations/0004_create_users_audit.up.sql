@@ -0,0 +1,15 @@
+-- users_audit is the append-only log of user writes. MySQLUserStore adds a
+-- row in the transaction of every write; rows are never updated or deleted,
+-- and outlive the users they describe.
+CREATE TABLE users_audit (
+    id         BIGINT       NOT NULL AUTO_INCREMENT,
+    user_id    INT          NOT NULL,
+    op         VARCHAR(16)  NOT NULL,
+    actor      VARCHAR(128) NOT NULL,
+    request_id VARCHAR(128) NOT NULL DEFAULT '',
+    at         DATETIME(6)  NOT NULL,
+    version    BIGINT       NOT NULL,
+    changes    JSON         NOT NULL,
+    PRIMARY KEY (id),
+    KEY users_audit_user (user_id, id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1 @@
+ALTER TABLE users DROP INDEX users_updated_at, DROP INDEX users_created_at, DROP COLUMN updated_at, DROP COLUMN created_at;
@@ -0,0 +1,7 @@
+-- created_at and updated_at are set by MySQLUserStore on every write. Users
+-- that existed before get the time of the migration for both.
+ALTER TABLE users
+    ADD COLUMN created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    ADD COLUMN updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    ADD INDEX users_created_at (created_at),
+    ADD INDEX users_updated_at (updated_at);
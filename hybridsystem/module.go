@@ -0,0 +1,160 @@
+package hybridsystem
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Names of the resource modules, as used by ModulesConfig and as the entity
+// label of their cache metrics.
+const (
+	ModuleMySQLUsers   = "mysql_users"
+	ModuleMongoUsers   = "mongo_users"
+	ModuleMongoPersons = "mongo_persons"
+)
+
+// ModuleOff as a prefix leaves a module out.
+const ModuleOff = "off"
+
+// ModulesConfig holds the path prefix every resource module is served under,
+// e.g. "/users". A module that is off (or "") is not mounted and the
+// databases only it uses are not connected to.
+type ModulesConfig struct {
+	MySQLUsers   string `yaml:"mysql_users"`
+	MongoUsers   string `yaml:"mongo_users"`
+	MongoPersons string `yaml:"mongo_persons"`
+}
+
+// ModulePresets are the module sets of the servers that used to run on their
+// own: mysql is Redisexample, mongo is CRUDoperations1 and hybrid, the
+// default, is CRUDoperations2.
+var ModulePresets = map[string]ModulesConfig{
+	"mysql":  {MySQLUsers: "/users", MongoUsers: ModuleOff, MongoPersons: ModuleOff},
+	"mongo":  {MySQLUsers: ModuleOff, MongoUsers: "/users", MongoPersons: ModuleOff},
+	"hybrid": {MySQLUsers: "/users", MongoUsers: ModuleOff, MongoPersons: "/persons"},
+}
+
+// Prefixes returns the prefix of every enabled module by module name.
+func (c ModulesConfig) Prefixes() map[string]string {
+	prefixes := map[string]string{}
+	for name, prefix := range map[string]string{
+		ModuleMySQLUsers:   c.MySQLUsers,
+		ModuleMongoUsers:   c.MongoUsers,
+		ModuleMongoPersons: c.MongoPersons,
+	} {
+		if prefix != "" && prefix != ModuleOff {
+			prefixes[name] = prefix
+		}
+	}
+	return prefixes
+}
+
+// Enabled reports whether the module called name is mounted.
+func (c ModulesConfig) Enabled(name string) bool {
+	_, ok := c.Prefixes()[name]
+	return ok
+}
+
+// Validate reports a configuration Compose would reject: no module enabled,
+// or prefixes that are malformed or clash.
+func (c ModulesConfig) Validate() error {
+	prefixes := c.Prefixes()
+	if len(prefixes) == 0 {
+		return fmt.Errorf("no modules enabled")
+	}
+	mounts := make([]Mount, 0, len(prefixes))
+	for _, name := range sortedKeys(prefixes) {
+		mounts = append(mounts, Mount{Name: name, Prefix: prefixes[name]})
+	}
+	return checkMounts(mounts)
+}
+
+// Module is a set of routes that can be served under any path prefix.
+// Register adds them to r with every path starting with prefix. (Routes are
+// not put on a subrouter: mux would answer a wrong method with 404 there
+// instead of 405.)
+type Module interface {
+	Register(r *mux.Router, prefix string)
+}
+
+// Mount places a module under Prefix. Name identifies it in errors.
+type Mount struct {
+	Name   string
+	Prefix string
+	Module Module
+}
+
+// reservedPaths are served by every composed router, next to the modules.
+var reservedPaths = []string{"/metrics", "/healthz", "/readyz"}
+
+// checkMounts reports prefixes that are malformed, or that clash with each
+// other or with reservedPaths. "/users" and "/users/archive" clash, "/users"
+// and "/users2" do not.
+func checkMounts(mounts []Mount) error {
+	taken := map[string]string{}
+	for _, p := range reservedPaths {
+		taken[p] = p
+	}
+	var errs []string
+	for _, m := range mounts {
+		switch {
+		case !strings.HasPrefix(m.Prefix, "/") || len(m.Prefix) < 2:
+			errs = append(errs, fmt.Sprintf("%s: prefix %q must start with / and name a path", m.Name, m.Prefix))
+			continue
+		case strings.HasSuffix(m.Prefix, "/") || strings.ContainsAny(m.Prefix, "{}?#:"):
+			errs = append(errs, fmt.Sprintf("%s: prefix %q must not end with / or contain {}?#:", m.Name, m.Prefix))
+			continue
+		}
+		for other, prefix := range taken {
+			if nested(m.Prefix, prefix) || nested(prefix, m.Prefix) {
+				errs = append(errs, fmt.Sprintf("%s: prefix %q clashes with %s", m.Name, m.Prefix, other))
+			}
+		}
+		taken[m.Name] = m.Prefix
+	}
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// nested reports whether path is prefix or lies below it.
+func nested(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// Compose builds the router of a single server: problem responses, request
+// ids, audit actors and access logs for every route, /metrics and the health checks when
+// metrics and health are set, and every module under its prefix. It fails if
+// prefixes clash instead of letting one module shadow another. A nil logger
+// means slog.Default().
+func Compose(logger *slog.Logger, metrics *Metrics, health *Health, mounts ...Mount) (*mux.Router, error) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if len(mounts) == 0 {
+		return nil, fmt.Errorf("no modules to serve")
+	}
+	if err := checkMounts(mounts); err != nil {
+		return nil, err
+	}
+	r := mux.NewRouter()
+	UseProblems(r)
+	r.Use(RequestID, Actor, AccessLog(logger))
+	if metrics != nil {
+		r.Use(metrics.Middleware)
+		r.Handle("/metrics", metrics).Methods("GET")
+	}
+	if health != nil {
+		health.Register(r)
+	}
+	for _, m := range mounts {
+		m.Module.Register(r, m.Prefix)
+	}
+	return r, nil
+}
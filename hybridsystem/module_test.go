@@ -0,0 +1,124 @@
+package hybridsystem_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+)
+
+func memoryModules() (users, persons hybridsystem.Module) {
+	users = &hybridsystem.Resource[hybridsystem.User2, *hybridsystem.User2]{
+		Name:     "user",
+		Store:    hybridsystem.NewMemoryUserStore(),
+		Validate: hybridsystem.ValidateUser,
+	}
+	persons = &hybridsystem.Resource[hybridsystem.Person, *hybridsystem.Person]{
+		Name:     "person",
+		Store:    hybridsystem.NewMemoryPersonStore(),
+		Validate: hybridsystem.ValidateUser1,
+	}
+	return users, persons
+}
+
+func TestCompose_Prefixes(t *testing.T) {
+	users, persons := memoryModules()
+	router, err := hybridsystem.Compose(nil, hybridsystem.NewMetrics(), &hybridsystem.Health{},
+		hybridsystem.Mount{Name: "users", Prefix: "/api/v2/users", Module: users},
+		hybridsystem.Mount{Name: "persons", Prefix: "/api/v2/users2", Module: persons},
+	)
+	if err != nil {
+		t.Fatalf("compose failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string // description of this test case
+		method   string
+		path     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{name: "create under prefix", method: http.MethodPost, path: "/api/v2/users", body: `{"name":"Akash","email":"akash@gmail.com"}`, wantCode: http.StatusCreated, wantBody: `"id":1`},
+		{name: "get under prefix", method: http.MethodGet, path: "/api/v2/users/1", wantCode: http.StatusOK, wantBody: "akash@gmail.com"},
+		{name: "list under prefix", method: http.MethodGet, path: "/api/v2/users", wantCode: http.StatusOK, wantBody: "akash@gmail.com"},
+		{name: "sibling prefix is another module", method: http.MethodGet, path: "/api/v2/users2/1", wantCode: http.StatusBadRequest},
+		{name: "old path is gone", method: http.MethodGet, path: "/users/1", wantCode: http.StatusNotFound},
+		{name: "metrics stay at the root", method: http.MethodGet, path: "/metrics", wantCode: http.StatusOK},
+		{name: "health stays at the root", method: http.MethodGet, path: "/healthz", wantCode: http.StatusOK},
+		{name: "wrong method", method: http.MethodPatch, path: "/api/v2/users", wantCode: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, bytes.NewBufferString(tt.body)))
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, w.Code, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("expected body to contain %s, got %s", tt.wantBody, w.Body)
+			}
+		})
+	}
+}
+
+func TestCompose_Clashes(t *testing.T) {
+	users, persons := memoryModules()
+
+	tests := []struct {
+		name    string // description of this test case
+		mounts  []hybridsystem.Mount
+		wantErr string
+	}{
+		{
+			name:    "same prefix",
+			mounts:  []hybridsystem.Mount{{Name: "a", Prefix: "/users", Module: users}, {Name: "b", Prefix: "/users", Module: persons}},
+			wantErr: `b: prefix "/users" clashes with a`,
+		},
+		{
+			name:    "nested prefix",
+			mounts:  []hybridsystem.Mount{{Name: "a", Prefix: "/users", Module: users}, {Name: "b", Prefix: "/users/mongo", Module: persons}},
+			wantErr: "clashes with a",
+		},
+		{
+			name:    "reserved path",
+			mounts:  []hybridsystem.Mount{{Name: "a", Prefix: "/metrics", Module: users}},
+			wantErr: "clashes with /metrics",
+		},
+		{
+			name:    "trailing slash",
+			mounts:  []hybridsystem.Mount{{Name: "a", Prefix: "/users/", Module: users}},
+			wantErr: "must not end with /",
+		},
+		{
+			name:    "relative prefix",
+			mounts:  []hybridsystem.Mount{{Name: "a", Prefix: "users", Module: users}},
+			wantErr: "must start with /",
+		},
+		{
+			name:    "nothing to serve",
+			wantErr: "no modules",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := hybridsystem.Compose(nil, nil, nil, tt.mounts...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestModulesConfig_Presets(t *testing.T) {
+	for mode, modules := range hybridsystem.ModulePresets {
+		if err := modules.Validate(); err != nil {
+			t.Errorf("preset %s is invalid: %v", mode, err)
+		}
+	}
+	if got := hybridsystem.DefaultConfig().Modules; got != hybridsystem.ModulePresets["hybrid"] {
+		t.Errorf("expected the hybrid modules by default, got %+v", got)
+	}
+}
@@ -0,0 +1,357 @@
+package hybridsystem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Schema validation levels of MongoConfig.SchemaValidation. Off leaves the
+// collection without a validator; moderate only checks documents that
+// already pass, so older documents can still be updated; strict checks
+// every insert and update.
+const (
+	SchemaValidationOff      = "off"
+	SchemaValidationModerate = "moderate"
+	SchemaValidationStrict   = "strict"
+)
+
+// IndexSpec declares one index of a collection.
+type IndexSpec struct {
+	Name      string
+	Keys      bson.D
+	Unique    bool
+	Collation *options.Collation
+}
+
+func (s IndexSpec) model() mongo.IndexModel {
+	opts := options.Index().SetName(s.Name)
+	if s.Unique {
+		opts.SetUnique(true)
+	}
+	if s.Collation != nil {
+		opts.SetCollation(s.Collation)
+	}
+	return mongo.IndexModel{Keys: s.Keys, Options: opts}
+}
+
+// CollectionSpec declares a collection, its indexes and its $jsonSchema
+// validator. A nil Validator means the collection should not have one.
+type CollectionSpec struct {
+	Name            string
+	Indexes         []IndexSpec
+	Validator       bson.D
+	ValidationLevel string
+}
+
+// emailUniqueIndex backs the email uniqueness of MongoPersonStore.
+var emailUniqueIndex = IndexSpec{
+	Name:      mongoUniqueEmailIndex,
+	Keys:      bson.D{{Key: "email", Value: 1}},
+	Unique:    true,
+	Collation: caseInsensitive,
+}
+
+// PersonCollection declares a collection of Person documents. level is one
+// of the SchemaValidation constants; the validator mirrors PersonValidator
+// as closely as $jsonSchema allows.
+func PersonCollection(name string, cfg ValidationConfig, level string) CollectionSpec {
+	spec := CollectionSpec{
+		Name: name,
+		Indexes: []IndexSpec{
+			emailUniqueIndex,
+			{Name: "created_at", Keys: bson.D{{Key: "created_at", Value: 1}}},
+			{Name: "updated_at", Keys: bson.D{{Key: "updated_at", Value: 1}}},
+			{Name: "deleted_at", Keys: bson.D{{Key: "deleted_at", Value: 1}}},
+		},
+	}
+	if level == "" || level == SchemaValidationOff {
+		return spec
+	}
+	cfg = cfg.withDefaults()
+	email := bson.A{bson.D{{Key: "pattern", Value: `^[^@\s]+@[^@\s]+\.[^@\s.]+$`}}}
+	if len(cfg.AllowedEmailDomains) > 0 {
+		domains := make([]string, len(cfg.AllowedEmailDomains))
+		for i, d := range cfg.AllowedEmailDomains {
+			domains[i] = regexp.QuoteMeta(strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@")))
+		}
+		email = append(email, bson.D{{Key: "pattern", Value: "(?i)@(" + strings.Join(domains, "|") + ")$"}})
+	}
+	spec.Validator = bson.D{{Key: "$jsonSchema", Value: bson.D{
+		{Key: "bsonType", Value: "object"},
+		{Key: "required", Value: bson.A{"name", "email"}},
+		{Key: "properties", Value: bson.D{
+			{Key: "name", Value: bson.D{
+				{Key: "bsonType", Value: "string"},
+				{Key: "maxLength", Value: cfg.NameMaxLen},
+				{Key: "pattern", Value: `^\s*\p{L}[\p{L}\p{M} '’.-]*$`},
+			}},
+			{Key: "email", Value: bson.D{
+				{Key: "bsonType", Value: "string"},
+				{Key: "maxLength", Value: cfg.EmailMaxLen},
+				{Key: "allOf", Value: email},
+			}},
+			{Key: "version", Value: bson.D{
+				{Key: "bsonType", Value: bson.A{"int", "long"}},
+				{Key: "minimum", Value: 0},
+			}},
+			{Key: "created_at", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+			{Key: "updated_at", Value: bson.D{{Key: "bsonType", Value: "date"}}},
+			{Key: "deleted_at", Value: bson.D{{Key: "bsonType", Value: bson.A{"date", "null"}}}},
+		}},
+	}}}
+	spec.ValidationLevel = level
+	return spec
+}
+
+// AuditCollection declares the audit log of a MongoPersonStore, read by
+// record in the order of the writes.
+func AuditCollection(name string) CollectionSpec {
+	return CollectionSpec{
+		Name: name,
+		Indexes: []IndexSpec{
+			{Name: "record_id_at", Keys: bson.D{{Key: "record_id", Value: 1}, {Key: "at", Value: 1}}},
+		},
+	}
+}
+
+// Drift is how a collection differs from its CollectionSpec.
+type Drift struct {
+	Collection string
+	// Missing are declared indexes that do not exist.
+	Missing []string
+	// Changed are declared indexes that exist with other keys or options.
+	// They are never rebuilt automatically.
+	Changed []string
+	// Extra are existing indexes that are not declared.
+	Extra []string
+	// Validator describes a validator that differs from the declared one, or
+	// is "" when it matches.
+	Validator string
+}
+
+func (d Drift) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Changed) == 0 && len(d.Extra) == 0 && d.Validator == ""
+}
+
+func (d Drift) String() string {
+	var parts []string
+	add := func(label string, names []string) {
+		if len(names) > 0 {
+			parts = append(parts, label+" indexes "+strings.Join(names, ", "))
+		}
+	}
+	add("missing", d.Missing)
+	add("changed", d.Changed)
+	add("undeclared", d.Extra)
+	if d.Validator != "" {
+		parts = append(parts, "validator "+d.Validator)
+	}
+	if len(parts) == 0 {
+		return d.Collection + ": in sync"
+	}
+	return d.Collection + ": " + strings.Join(parts, "; ")
+}
+
+// CheckCollection reports the drift of the collection from spec without
+// changing anything.
+func CheckCollection(ctx context.Context, db *mongo.Database, spec CollectionSpec) (Drift, error) {
+	drift, _, err := checkCollection(ctx, db, spec)
+	return drift, err
+}
+
+func checkCollection(ctx context.Context, db *mongo.Database, spec CollectionSpec) (Drift, bool, error) {
+	drift := Drift{Collection: spec.Name}
+	info, exists, err := collectionInfo(ctx, db, spec.Name)
+	if err != nil {
+		return drift, false, err
+	}
+	if !exists {
+		for _, idx := range spec.Indexes {
+			drift.Missing = append(drift.Missing, idx.Name)
+		}
+		if spec.Validator != nil {
+			drift.Validator = "missing"
+		}
+		return drift, false, nil
+	}
+	drift.Validator = validatorDrift(spec, info)
+
+	existing, err := listIndexes(ctx, db.Collection(spec.Name))
+	if err != nil {
+		return drift, true, err
+	}
+	for _, idx := range spec.Indexes {
+		i := slices.IndexFunc(existing, func(e existingIndex) bool { return e.Name == idx.Name })
+		switch {
+		case i < 0:
+			drift.Missing = append(drift.Missing, idx.Name)
+		case !existing[i].matches(idx):
+			drift.Changed = append(drift.Changed, idx.Name)
+		}
+	}
+	for _, e := range existing {
+		declared := slices.ContainsFunc(spec.Indexes, func(idx IndexSpec) bool { return idx.Name == e.Name })
+		if e.Name != "_id_" && !declared {
+			drift.Extra = append(drift.Extra, e.Name)
+		}
+	}
+	return drift, true, nil
+}
+
+// EnsureCollection creates the collection if needed, applies its validator
+// and creates the missing indexes. It returns the drift left afterwards:
+// changed and undeclared indexes are reported rather than dropped.
+func EnsureCollection(ctx context.Context, db *mongo.Database, spec CollectionSpec) (Drift, error) {
+	drift, exists, err := checkCollection(ctx, db, spec)
+	if err != nil {
+		return drift, err
+	}
+	if !exists {
+		opts := options.CreateCollection()
+		if spec.Validator != nil {
+			opts.SetValidator(spec.Validator).SetValidationLevel(spec.ValidationLevel)
+		}
+		if err := db.CreateCollection(ctx, spec.Name, opts); err != nil {
+			return drift, err
+		}
+	} else if drift.Validator != "" {
+		if err := db.RunCommand(ctx, collMod(spec)).Err(); err != nil {
+			return drift, err
+		}
+	}
+	drift.Validator = ""
+
+	var errs []error
+	var missing []string
+	for _, idx := range spec.Indexes {
+		if !slices.Contains(drift.Missing, idx.Name) {
+			continue
+		}
+		if _, err := db.Collection(spec.Name).Indexes().CreateOne(ctx, idx.model()); err != nil {
+			errs = append(errs, fmt.Errorf("index %s: %w", idx.Name, err))
+			missing = append(missing, idx.Name)
+		}
+	}
+	drift.Missing = missing
+	return drift, errors.Join(errs...)
+}
+
+// BootstrapMongo ensures every spec at startup. Failures and drift are
+// logged but do not stop the server, the same as EnforceUniqueEmail; a
+// unique email index cannot be built while the dupemails command finds
+// duplicates.
+func BootstrapMongo(logger *slog.Logger, db *mongo.Database, specs ...CollectionSpec) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	for _, spec := range specs {
+		drift, err := EnsureCollection(ctx, db, spec)
+		if err != nil {
+			logger.Warn("mongo collection bootstrap failed", "collection", spec.Name, "error", err)
+		}
+		if !drift.Empty() {
+			logger.Warn("mongo collection drifted from its declaration", "collection", spec.Name, "drift", drift.String())
+		}
+	}
+}
+
+func collMod(spec CollectionSpec) bson.D {
+	cmd := bson.D{{Key: "collMod", Value: spec.Name}}
+	if spec.Validator == nil {
+		return append(cmd, bson.E{Key: "validator", Value: bson.D{}}, bson.E{Key: "validationLevel", Value: SchemaValidationOff})
+	}
+	return append(cmd, bson.E{Key: "validator", Value: spec.Validator}, bson.E{Key: "validationLevel", Value: spec.ValidationLevel})
+}
+
+type collectionOptions struct {
+	Validator       bson.Raw `bson:"validator"`
+	ValidationLevel string   `bson:"validationLevel"`
+}
+
+func collectionInfo(ctx context.Context, db *mongo.Database, name string) (collectionOptions, bool, error) {
+	cur, err := db.ListCollections(ctx, bson.D{{Key: "name", Value: name}})
+	if err != nil {
+		return collectionOptions{}, false, err
+	}
+	var infos []struct {
+		Options collectionOptions `bson:"options"`
+	}
+	if err := cur.All(ctx, &infos); err != nil {
+		return collectionOptions{}, false, err
+	}
+	if len(infos) == 0 {
+		return collectionOptions{}, false, nil
+	}
+	return infos[0].Options, true, nil
+}
+
+// validatorDrift compares the declared validator with the one the server
+// returned, which keeps the field order it was given.
+func validatorDrift(spec CollectionSpec, info collectionOptions) string {
+	hasValidator := len(info.Validator) > 0 && info.ValidationLevel != SchemaValidationOff
+	if !hasValidator {
+		if spec.Validator == nil {
+			return ""
+		}
+		return "missing"
+	}
+	if spec.Validator == nil {
+		return "undeclared"
+	}
+	want, err := bson.Marshal(spec.Validator)
+	if err != nil || !bytes.Equal(want, info.Validator) {
+		return "changed"
+	}
+	if level := info.ValidationLevel; level != spec.ValidationLevel && !(level == "" && spec.ValidationLevel == SchemaValidationStrict) {
+		return "level is " + level
+	}
+	return ""
+}
+
+type existingIndex struct {
+	Name      string `bson:"name"`
+	Key       bson.D `bson:"key"`
+	Unique    bool   `bson:"unique"`
+	Collation *struct {
+		Locale   string `bson:"locale"`
+		Strength int    `bson:"strength"`
+	} `bson:"collation"`
+}
+
+func listIndexes(ctx context.Context, coll *mongo.Collection) ([]existingIndex, error) {
+	cur, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []existingIndex
+	err = cur.All(ctx, &indexes)
+	return indexes, err
+}
+
+func (e existingIndex) matches(s IndexSpec) bool {
+	if e.Unique != s.Unique || len(e.Key) != len(s.Keys) {
+		return false
+	}
+	for i, k := range s.Keys {
+		// the server may return 1 as an int32, int64 or double
+		if e.Key[i].Key != k.Key || fmt.Sprint(e.Key[i].Value) != fmt.Sprint(k.Value) {
+			return false
+		}
+	}
+	if (e.Collation == nil) != (s.Collation == nil) {
+		return false
+	}
+	return s.Collation == nil || (e.Collation.Locale == s.Collation.Locale && e.Collation.Strength == s.Collation.Strength)
+}
@@ -0,0 +1,93 @@
+package hybridsystem_test
+
+import (
+	"redisDatabase/hybridsystem"
+	"regexp"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPersonCollection(t *testing.T) {
+	off := hybridsystem.PersonCollection("persons", hybridsystem.ValidationConfig{}, hybridsystem.SchemaValidationOff)
+	if off.Validator != nil {
+		t.Errorf("expected no validator when validation is off, got %v", off.Validator)
+	}
+	var names []string
+	for _, idx := range off.Indexes {
+		names = append(names, idx.Name)
+	}
+	if len(names) != 4 || names[0] != "email_unique" || names[1] != "created_at" || names[2] != "updated_at" || names[3] != "deleted_at" || !off.Indexes[0].Unique {
+		t.Errorf("expected a unique email, a created_at, an updated_at and a deleted_at index, got %+v", off.Indexes)
+	}
+
+	cfg := hybridsystem.ValidationConfig{AllowedEmailDomains: []string{"gmail.com"}}
+	strict := hybridsystem.PersonCollection("persons", cfg, hybridsystem.SchemaValidationStrict)
+	if strict.ValidationLevel != hybridsystem.SchemaValidationStrict {
+		t.Fatalf("expected level strict, got %q", strict.ValidationLevel)
+	}
+	raw, err := bson.Marshal(strict.Validator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := bson.Raw(raw).Lookup("$jsonSchema", "properties")
+	namePattern := regexp.MustCompile(schema.Document().Lookup("name", "pattern").StringValue())
+	var emailPatterns []*regexp.Regexp
+	values, _ := schema.Document().Lookup("email", "allOf").Array().Values()
+	for _, v := range values {
+		emailPatterns = append(emailPatterns, regexp.MustCompile(v.Document().Lookup("pattern").StringValue()))
+	}
+	validator := hybridsystem.PersonValidator(cfg)
+
+	// the schema has to accept what the handlers accept, so that it never
+	// rejects a write that passed validation
+	tests := []struct {
+		name   string // description of this test case
+		person hybridsystem.Person
+	}{
+		{name: "valid", person: hybridsystem.Person{Name: "Akash", Email: "akash@gmail.com"}},
+		{name: "unicode name", person: hybridsystem.Person{Name: "Zoë O’Brien", Email: "zoe@gmail.com"}},
+		{name: "upper case domain", person: hybridsystem.Person{Name: "Akash", Email: "akash@GMAIL.com"}},
+		{name: "name with digits", person: hybridsystem.Person{Name: "R2D2", Email: "r2@gmail.com"}},
+		{name: "domain not allowed", person: hybridsystem.Person{Name: "Akash", Email: "akash@yahoo.com"}},
+		{name: "email without at", person: hybridsystem.Person{Name: "Akash", Email: "akash"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wantOK := validator.Validate(tt.person) == nil
+			gotOK := namePattern.MatchString(tt.person.Name)
+			for _, p := range emailPatterns {
+				gotOK = gotOK && p.MatchString(tt.person.Email)
+			}
+			if gotOK != wantOK {
+				t.Errorf("schema accepts %+v: %v, validator accepts it: %v", tt.person, gotOK, wantOK)
+			}
+		})
+	}
+}
+
+func TestDrift(t *testing.T) {
+	tests := []struct {
+		name      string // description of this test case
+		drift     hybridsystem.Drift
+		wantEmpty bool
+		want      string
+	}{
+		{name: "in sync", drift: hybridsystem.Drift{Collection: "persons"}, wantEmpty: true, want: "persons: in sync"},
+		{
+			name:  "everything drifted",
+			drift: hybridsystem.Drift{Collection: "persons", Missing: []string{"created_at"}, Changed: []string{"email_unique"}, Extra: []string{"name_1"}, Validator: "changed"},
+			want:  "persons: missing indexes created_at; changed indexes email_unique; undeclared indexes name_1; validator changed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.drift.Empty() != tt.wantEmpty {
+				t.Errorf("expected Empty() to be %v", tt.wantEmpty)
+			}
+			if got := tt.drift.String(); got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
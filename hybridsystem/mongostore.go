@@ -0,0 +1,753 @@
+package hybridsystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoPersonStore keeps Person documents in a MongoDB collection. Calls are
+// bounded by the caller's context only.
+//
+// With Audit set, every write also appends an AuditEntry to that collection,
+// in the same transaction. MongoDB only has transactions on replica sets (a
+// single-node one will do), see RequireTransactions; without one, leave Audit
+// nil.
+type MongoPersonStore struct {
+	Collection *mongo.Collection
+	Audit      *mongo.Collection
+}
+
+// NewMongoPersonStore is the store of the persons collection. With audit it
+// is audited in "persons_audit", which needs transactions.
+func NewMongoPersonStore(m *MongoInstance1, audit bool) *MongoPersonStore {
+	s := &MongoPersonStore{Collection: m.Persons}
+	if audit {
+		s.Audit = m.DB.Collection(m.Persons.Name() + "_audit")
+	}
+	return s
+}
+
+// ErrNoTransactions is returned by RequireTransactions when MongoDB is a
+// standalone server.
+var ErrNoTransactions = errors.New("mongodb is not a replica set or sharded cluster, it has no transactions")
+
+// RequireTransactions fails with ErrNoTransactions unless MongoDB supports
+// the transactions the audit log is written in.
+func (m *MongoInstance1) RequireTransactions(ctx context.Context) error {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	if err := m.Client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return err
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return ErrNoTransactions
+	}
+	return nil
+}
+
+func (s *MongoPersonStore) Create(ctx context.Context, p *Person) error {
+	p.Normalize()
+	p.Version = 1
+	p.CreatedAt = stamp()
+	p.UpdatedAt = p.CreatedAt
+	p.DeletedAt = nil
+	created := *p
+	err := s.write(ctx, func(ctx context.Context) error {
+		res, err := s.Collection.InsertOne(ctx, p)
+		if err != nil {
+			return err
+		}
+		created.ID = res.InsertedID.(primitive.ObjectID)
+		return s.audit(ctx, newAuditEntry[Person](ctx, AuditCreate, nil, &created))
+	})
+	if err != nil {
+		return s.duplicate(ctx, err, p.Email)
+	}
+	p.ID = created.ID
+	return nil
+}
+
+func (s *MongoPersonStore) Get(ctx context.Context, id string) (*Person, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	var person Person
+	err = s.Collection.FindOne(ctx, live(bson.M{"_id": objID})).Decode(&person)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &person, nil
+}
+
+func (s *MongoPersonStore) Update(ctx context.Context, id string, p *Person) error {
+	if err := p.SetKey(id); err != nil {
+		return err
+	}
+	p.Normalize()
+	now := stamp()
+	update := bson.M{
+		"$set": bson.M{
+			"name":       p.Name,
+			"email":      p.Email,
+			"updated_at": now,
+		},
+		"$inc": bson.M{"version": 1},
+	}
+	filter := live(bson.M{"_id": p.ID})
+	if p.Version != 0 {
+		filter["version"] = p.Version
+	}
+	updated := *p
+	err := s.write(ctx, func(ctx context.Context) error {
+		var before Person
+		err := s.Collection.FindOneAndUpdate(ctx, filter, update).Decode(&before)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return s.missing(ctx, p.ID)
+		}
+		if err != nil {
+			return err
+		}
+		updated.Version = before.Version + 1
+		updated.CreatedAt, updated.UpdatedAt = before.CreatedAt, now
+		updated.DeletedAt = nil
+		return s.audit(ctx, newAuditEntry[Person](ctx, AuditUpdate, &before, &updated))
+	})
+	if err != nil {
+		return s.duplicate(ctx, err, p.Email)
+	}
+	*p = updated
+	return nil
+}
+
+func (s *MongoPersonStore) Delete(ctx context.Context, id string) error {
+	return s.DeleteVersion(ctx, id, 0)
+}
+
+func (s *MongoPersonStore) DeleteVersion(ctx context.Context, id string, version int64) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+	filter := live(bson.M{"_id": objID})
+	if version != 0 {
+		filter["version"] = version
+	}
+	return s.write(ctx, func(ctx context.Context) error {
+		var before Person
+		err := s.Collection.FindOneAndDelete(ctx, filter).Decode(&before)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return s.missing(ctx, objID)
+		}
+		if err != nil {
+			return err
+		}
+		return s.audit(ctx, newAuditEntry[Person](ctx, AuditDelete, &before, nil))
+	})
+}
+
+// write runs fn, in a transaction when writes are audited.
+func (s *MongoPersonStore) write(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.Audit == nil {
+		return fn(ctx)
+	}
+	return s.transaction(ctx, fn)
+}
+
+// transaction runs fn in a transaction, again if it fails with a transient
+// error.
+func (s *MongoPersonStore) transaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	return s.Collection.Database().Client().UseSession(ctx, func(sc mongo.SessionContext) error {
+		_, err := sc.WithTransaction(sc, func(sc mongo.SessionContext) (any, error) {
+			return nil, fn(sc)
+		})
+		return err
+	})
+}
+
+// audit appends entry to the Audit collection, if any.
+func (s *MongoPersonStore) audit(ctx context.Context, entry AuditEntry) error {
+	if s.Audit == nil {
+		return nil
+	}
+	_, err := s.Audit.InsertOne(ctx, entry)
+	return err
+}
+
+func (s *MongoPersonStore) History(ctx context.Context, id string) ([]AuditEntry, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	if s.Audit == nil {
+		return nil, ErrNotFound
+	}
+	cursor, err := s.Audit.Find(ctx, bson.M{"record_id": objID.Hex()}, options.Find().SetSort(bson.D{{Key: "at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var entries []AuditEntry
+	if err := cursor.All(ctx, &entries); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	for i := range entries {
+		entries[i].At = entries[i].At.UTC()
+	}
+	return entries, nil
+}
+
+// missing explains why a write matched no document: the person does not
+// exist, or it does at another version than the one expected.
+func (s *MongoPersonStore) missing(ctx context.Context, id primitive.ObjectID) error {
+	n, err := s.Collection.CountDocuments(ctx, live(bson.M{"_id": id}), options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionMismatch
+}
+
+// live restricts filter to documents that are not in the trash. A missing
+// deleted_at matches too, as in documents written before soft deletes.
+func live(filter bson.M) bson.M {
+	filter["deleted_at"] = nil
+	return filter
+}
+
+func (s *MongoPersonStore) SoftDelete(ctx context.Context, id string, version int64) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+	filter := live(bson.M{"_id": objID})
+	if version != 0 {
+		filter["version"] = version
+	}
+	now := stamp()
+	update := bson.M{
+		"$set": bson.M{"deleted_at": now, "updated_at": now},
+		"$inc": bson.M{"version": 1},
+	}
+	return s.write(ctx, func(ctx context.Context) error {
+		var before Person
+		err := s.Collection.FindOneAndUpdate(ctx, filter, update).Decode(&before)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return s.missing(ctx, objID)
+		}
+		if err != nil {
+			return err
+		}
+		trashed := before
+		trashed.DeletedAt = &now
+		trashed.UpdatedAt = now
+		trashed.Version++
+		return s.audit(ctx, newAuditEntry[Person](ctx, AuditSoftDelete, &before, &trashed))
+	})
+}
+
+func (s *MongoPersonStore) Restore(ctx context.Context, id string) (*Person, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	now := stamp()
+	update := bson.M{
+		"$set":   bson.M{"updated_at": now},
+		"$unset": bson.M{"deleted_at": ""},
+		"$inc":   bson.M{"version": 1},
+	}
+	var restored Person
+	err = s.write(ctx, func(ctx context.Context) error {
+		var before Person
+		err := s.Collection.FindOneAndUpdate(ctx, bson.M{"_id": objID, "deleted_at": bson.M{"$ne": nil}}, update).Decode(&before)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		restored = before
+		restored.DeletedAt = nil
+		restored.UpdatedAt = now
+		restored.Version++
+		return s.audit(ctx, newAuditEntry[Person](ctx, AuditRestore, &before, &restored))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
+
+func (s *MongoPersonStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	filter := bson.M{"deleted_at": bson.M{"$lt": before.UTC()}}
+	if s.Audit == nil {
+		res, err := s.Collection.DeleteMany(ctx, filter)
+		if err != nil {
+			return 0, err
+		}
+		return res.DeletedCount, nil
+	}
+	var purged int64
+	for {
+		var n int
+		err := s.write(ctx, func(ctx context.Context) error {
+			cursor, err := s.Collection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}).SetLimit(purgeBatch))
+			if err != nil {
+				return err
+			}
+			var persons []Person
+			if err := cursor.All(ctx, &persons); err != nil || len(persons) == 0 {
+				return err
+			}
+			ids := make([]primitive.ObjectID, len(persons))
+			for i, p := range persons {
+				ids[i] = p.ID
+			}
+			if _, err := s.Collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+				return err
+			}
+			for i := range persons {
+				if err := s.audit(ctx, newAuditEntry[Person](ctx, AuditPurge, &persons[i], nil)); err != nil {
+					return err
+				}
+			}
+			n = len(persons)
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += int64(n)
+		if n < purgeBatch {
+			return purged, nil
+		}
+	}
+}
+
+// Batch applies ops with one BulkWrite. The persons to update or delete are
+// read first, in one query, to check their versions and audit the writes,
+// which are then conditional on those versions. An atomic batch, or an
+// audited one, runs in a transaction (and so needs a replica set); outside
+// of one the writes are unordered and a write that matched nothing because
+// of a concurrent change is found by reading the persons again.
+func (s *MongoPersonStore) Batch(ctx context.Context, ops []BatchOp[Person], opts BatchOptions) ([]error, error) {
+	transact := opts.Atomic || s.Audit != nil
+	// failed keeps the write errors when a transaction is tried again
+	// without the writes that failed
+	failed := make([]error, len(ops))
+	errs := make([]error, len(ops))
+	written := make([]Person, len(ops))
+	var err error
+	for retry := true; retry; {
+		retry = false
+		apply := func(ctx context.Context) error {
+			copy(errs, failed)
+			b, err := s.prepareBatch(ctx, ops, opts.SoftDelete, errs, written)
+			if err != nil {
+				return err
+			}
+			if opts.Atomic && slices.ContainsFunc(errs, func(err error) bool { return err != nil }) {
+				return errBatchAborted
+			}
+			if len(b.models) == 0 {
+				return nil
+			}
+			res, err := s.Collection.BulkWrite(ctx, b.models, options.BulkWrite().SetOrdered(opts.Atomic))
+			var bulk mongo.BulkWriteException
+			if errors.As(err, &bulk) && len(bulk.WriteErrors) > 0 && bulk.WriteConcernError == nil {
+				for _, we := range bulk.WriteErrors {
+					i := b.index[we.Index]
+					failed[i] = mongo.WriteException{WriteErrors: mongo.WriteErrors{we.WriteError}}
+					errs[i] = failed[i]
+				}
+				if transact {
+					// the server has aborted the transaction; a
+					// best-effort batch goes again without these writes
+					retry = !opts.Atomic
+					return errBatchAborted
+				}
+			} else if err != nil {
+				return err
+			}
+			if err != nil || res.MatchedCount+res.DeletedCount < b.matched {
+				// a write that matched nothing applied nothing: a
+				// best-effort batch keeps the others, an atomic one
+				// rolls them back
+				if err := s.confirmBatch(ctx, ops, b, opts.SoftDelete, errs, written); err != nil {
+					return err
+				}
+				if opts.Atomic && slices.ContainsFunc(errs, func(err error) bool { return err != nil }) {
+					return errBatchAborted
+				}
+			}
+			if s.Audit == nil {
+				return nil
+			}
+			var entries []any
+			for k, i := range b.index {
+				if errs[i] == nil {
+					entries = append(entries, b.entries[k])
+				}
+			}
+			if len(entries) == 0 {
+				return nil
+			}
+			_, err = s.Audit.InsertMany(ctx, entries)
+			return err
+		}
+		if transact {
+			err = s.transaction(ctx, apply)
+		} else {
+			err = apply(ctx)
+		}
+	}
+	switch {
+	case errors.Is(err, errBatchAborted):
+		notApplied(errs)
+	case err != nil:
+		return nil, err
+	}
+	for i, op := range ops {
+		switch {
+		case errs[i] == nil && op.Record != nil:
+			*op.Record = written[i]
+		case errs[i] != nil && op.Record != nil:
+			errs[i] = s.duplicate(ctx, errs[i], written[i].Email)
+		}
+	}
+	return errs, nil
+}
+
+// mongoBatch is a batch ready for BulkWrite: models[k] writes ops[index[k]]
+// and entries[k] audits it. ids are the parsed ids of the operations, and
+// matched is how many documents the updates and deletes should match.
+type mongoBatch struct {
+	ids     []primitive.ObjectID
+	models  []mongo.WriteModel
+	index   []int
+	entries []AuditEntry
+	matched int64
+}
+
+// prepareBatch turns the operations of a batch that have not failed yet
+// into writes, setting errs for those that cannot be applied and written to
+// the persons as they will be stored.
+func (s *MongoPersonStore) prepareBatch(ctx context.Context, ops []BatchOp[Person], soft bool, errs []error, written []Person) (*mongoBatch, error) {
+	b := &mongoBatch{ids: make([]primitive.ObjectID, len(ops))}
+	var lookup []primitive.ObjectID
+	for i, op := range ops {
+		if errs[i] != nil || op.Op == BatchCreate {
+			continue
+		}
+		id, err := primitive.ObjectIDFromHex(op.ID)
+		if err != nil {
+			errs[i] = ErrInvalidID
+			continue
+		}
+		b.ids[i] = id
+		lookup = append(lookup, id)
+	}
+	current := make(map[primitive.ObjectID]Person, len(lookup))
+	if len(lookup) > 0 {
+		cursor, err := s.Collection.Find(ctx, live(bson.M{"_id": bson.M{"$in": lookup}}))
+		if err != nil {
+			return nil, err
+		}
+		var persons []Person
+		if err := cursor.All(ctx, &persons); err != nil {
+			return nil, err
+		}
+		for _, p := range persons {
+			current[p.ID] = p
+		}
+	}
+
+	now := stamp()
+	for i, op := range ops {
+		if errs[i] != nil {
+			continue
+		}
+		var before Person
+		var filter bson.M
+		if op.Op != BatchCreate {
+			var found bool
+			if before, found = current[b.ids[i]]; !found {
+				errs[i] = ErrNotFound
+				continue
+			}
+			if op.Version != 0 && op.Version != before.Version {
+				errs[i] = ErrVersionMismatch
+				continue
+			}
+			filter = live(bson.M{"_id": before.ID})
+			if before.Version != 0 {
+				filter["version"] = before.Version
+			}
+		}
+		var model mongo.WriteModel
+		var entry AuditEntry
+		switch {
+		case op.Op == BatchCreate:
+			p := *op.Record
+			p.Normalize()
+			p.ID, p.Version = primitive.NewObjectID(), 1
+			p.CreatedAt, p.UpdatedAt, p.DeletedAt = now, now, nil
+			written[i] = p
+			model = mongo.NewInsertOneModel().SetDocument(p)
+			entry = newAuditEntry[Person](ctx, AuditCreate, nil, &p)
+		case op.Op == BatchUpdate:
+			p := *op.Record
+			p.Normalize()
+			p.ID, p.Version = before.ID, before.Version+1
+			p.CreatedAt, p.UpdatedAt, p.DeletedAt = before.CreatedAt, now, nil
+			written[i] = p
+			model = mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.M{
+				"$set": bson.M{"name": p.Name, "email": p.Email, "updated_at": now},
+				"$inc": bson.M{"version": 1},
+			})
+			entry = newAuditEntry[Person](ctx, AuditUpdate, &before, &p)
+			b.matched++
+		case op.Op == BatchDelete && soft:
+			trashed := before
+			trashed.DeletedAt, trashed.UpdatedAt = &now, now
+			trashed.Version++
+			written[i] = trashed
+			model = mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(bson.M{
+				"$set": bson.M{"deleted_at": now, "updated_at": now},
+				"$inc": bson.M{"version": 1},
+			})
+			entry = newAuditEntry[Person](ctx, AuditSoftDelete, &before, &trashed)
+			b.matched++
+		case op.Op == BatchDelete:
+			model = mongo.NewDeleteOneModel().SetFilter(filter)
+			entry = newAuditEntry[Person](ctx, AuditDelete, &before, nil)
+			b.matched++
+		default:
+			errs[i] = fmt.Errorf("unknown batch operation %q", op.Op)
+			continue
+		}
+		b.models = append(b.models, model)
+		b.index = append(b.index, i)
+		b.entries = append(b.entries, entry)
+	}
+	return b, nil
+}
+
+// confirmBatch finds the updates and deletes of b that matched nothing,
+// which BulkWrite only reports as a count, by comparing the persons with
+// how they were written. Inside a transaction it sees the batch's own writes.
+func (s *MongoPersonStore) confirmBatch(ctx context.Context, ops []BatchOp[Person], b *mongoBatch, soft bool, errs []error, written []Person) error {
+	var ids []primitive.ObjectID
+	for _, i := range b.index {
+		if errs[i] == nil && ops[i].Op != BatchCreate {
+			ids = append(ids, b.ids[i])
+		}
+	}
+	cursor, err := s.Collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	var persons []Person
+	if err := cursor.All(ctx, &persons); err != nil {
+		return err
+	}
+	stored := make(map[primitive.ObjectID]Person, len(persons))
+	for _, p := range persons {
+		stored[p.ID] = p
+	}
+	for _, i := range b.index {
+		if errs[i] != nil || ops[i].Op == BatchCreate {
+			continue
+		}
+		p, ok := stored[b.ids[i]]
+		switch {
+		case ops[i].Op == BatchDelete && !soft:
+			if ok {
+				errs[i] = ErrVersionMismatch
+			}
+		case !ok:
+			errs[i] = ErrNotFound
+		case p.Version != written[i].Version || (p.DeletedAt == nil) != (written[i].DeletedAt == nil):
+			errs[i] = ErrVersionMismatch
+		}
+	}
+	return nil
+}
+
+func (s *MongoPersonStore) List(ctx context.Context, q ListQuery) (Page[Person], error) {
+	return s.list(ctx, q, false)
+}
+
+func (s *MongoPersonStore) Trash(ctx context.Context, q ListQuery) (Page[Person], error) {
+	return s.list(ctx, q, true)
+}
+
+// list pages through the live persons, or the trashed ones, with keyset
+// pagination on (sort field, _id).
+func (s *MongoPersonStore) list(ctx context.Context, q ListQuery, trashed bool) (Page[Person], error) {
+	q, err := q.normalized()
+	if err != nil {
+		return Page[Person]{}, err
+	}
+	after, err := decodeCursor(q.Cursor, q.Sort)
+	if err != nil {
+		return Page[Person]{}, err
+	}
+	filter := live(bson.M{})
+	if trashed {
+		filter = bson.M{"deleted_at": bson.M{"$ne": nil}}
+	}
+	if q.EmailDomain != "" {
+		filter["email"] = bson.M{"$regex": "@" + regexp.QuoteMeta(q.EmailDomain) + "$", "$options": "i"}
+	}
+	if !q.CreatedSince.IsZero() {
+		filter["created_at"] = bson.M{"$gte": q.CreatedSince}
+	}
+	if !q.UpdatedSince.IsZero() {
+		filter["updated_at"] = bson.M{"$gte": q.UpdatedSince}
+	}
+	page := Page[Person]{Items: []Person{}}
+	if q.WithTotal {
+		total, err := s.Collection.CountDocuments(ctx, filter)
+		if err != nil {
+			return Page[Person]{}, err
+		}
+		page.Total = &total
+	}
+
+	sortField := "_id"
+	if q.Sort != "id" {
+		sortField = q.Sort
+	}
+	if after.ID != "" {
+		lastID, err := primitive.ObjectIDFromHex(after.ID)
+		if err != nil {
+			return Page[Person]{}, ErrInvalidCursor
+		}
+		var position bson.M
+		if sortField == "_id" {
+			position = bson.M{"_id": bson.M{"$gt": lastID}}
+		} else {
+			value, err := cursorValue(q.Sort, after.Value)
+			if err != nil {
+				return Page[Person]{}, err
+			}
+			position = bson.M{"$or": bson.A{
+				bson.M{sortField: bson.M{"$gt": value}},
+				bson.M{sortField: value, "_id": bson.M{"$gt": lastID}},
+			}}
+		}
+		filter = bson.M{"$and": bson.A{filter, position}}
+	}
+	sort := bson.D{{Key: "_id", Value: 1}}
+	if sortField != "_id" {
+		sort = bson.D{{Key: sortField, Value: 1}, {Key: "_id", Value: 1}}
+	}
+	opts := options.Find().SetSort(sort).SetLimit(int64(q.Limit + 1))
+
+	cur, err := s.Collection.Find(ctx, filter, opts)
+	if err != nil {
+		return Page[Person]{}, err
+	}
+	if err := cur.All(ctx, &page.Items); err != nil {
+		return Page[Person]{}, err
+	}
+	more := len(page.Items) > q.Limit
+	if more {
+		page.Items = page.Items[:q.Limit]
+	}
+	page.NextCursor = nextCursor[Person](q, page.Items, more)
+	return page, nil
+}
+
+// mongoUniqueEmailIndex is the unique index EnsureUniqueEmail creates on
+// email. Its collation compares case-insensitively, so documents written
+// before emails were normalized are covered as well.
+const mongoUniqueEmailIndex = "email_unique"
+
+var caseInsensitive = &options.Collation{Locale: "en", Strength: 2}
+
+// duplicate turns a duplicate key error for email into a DuplicateError
+// naming the document that has it. Other errors are returned unchanged.
+func (s *MongoPersonStore) duplicate(ctx context.Context, err error, email string) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	dup := &DuplicateError{Field: "email", Value: email}
+	var existing struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1}).SetCollation(caseInsensitive)
+	if s.Collection.FindOne(ctx, bson.M{"email": email}, opts).Decode(&existing) == nil {
+		dup.ExistingID = existing.ID.Hex()
+	}
+	return dup
+}
+
+func (s *MongoPersonStore) FindDuplicateEmails(ctx context.Context) ([]DuplicateGroup, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+	}
+	cur, err := s.Collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		Email string               `bson:"_id"`
+		IDs   []primitive.ObjectID `bson:"ids"`
+	}
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	groups := make([]DuplicateGroup, len(results))
+	for i, r := range results {
+		groups[i].Email = r.Email
+		for _, id := range r.IDs {
+			groups[i].IDs = append(groups[i].IDs, id.Hex())
+		}
+	}
+	return groups, nil
+}
+
+func (s *MongoPersonStore) EnsureUniqueEmail(ctx context.Context) error {
+	return ensureUniqueEmail(ctx, s, func(ctx context.Context) (bool, error) {
+		specs, err := s.Collection.Indexes().ListSpecifications(ctx)
+		if err != nil {
+			return false, err
+		}
+		for _, spec := range specs {
+			if spec.Name == mongoUniqueEmailIndex {
+				return true, nil
+			}
+		}
+		return false, nil
+	}, func(ctx context.Context) error {
+		_, err := s.Collection.Indexes().CreateOne(ctx, emailUniqueIndex.model())
+		return err
+	})
+}
@@ -0,0 +1,573 @@
+package hybridsystem
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQLUserStore keeps User2 records in the users table.
+type MySQLUserStore struct {
+	MySQL *MySQLInstance1
+}
+
+func NewMySQLUserStore(m *MySQLInstance1) *MySQLUserStore {
+	return &MySQLUserStore{MySQL: m}
+}
+
+func (s *MySQLUserStore) Create(ctx context.Context, u *User2) error {
+	u.Normalize()
+	created := *u
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		return createUser(ctx, tx, &created)
+	})
+	if err != nil {
+		return s.duplicate(ctx, err, u.Email)
+	}
+	*u = created
+	return nil
+}
+
+// createUser, updateUser, deleteUser and softDeleteUser are the writes of
+// the exported methods and Batch, each with its audit entry. On success
+// createUser and updateUser fill in u like Create and Update.
+func createUser(ctx context.Context, tx *sql.Tx, u *User2) error {
+	created := *u
+	now := stamp()
+	res, err := tx.ExecContext(ctx, "INSERT INTO users (name , email, version, created_at, updated_at) VALUES (? , ?, 1, ?, ?)",
+		created.Name, created.Email, now, now)
+	if err != nil {
+		return err
+	}
+	created.CreatedAt, created.UpdatedAt = now, now
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	created.ID = int(id)
+	created.Version = 1
+	created.DeletedAt = nil
+	if err := appendUserAudit(ctx, tx, newAuditEntry[User2](ctx, AuditCreate, nil, &created)); err != nil {
+		return err
+	}
+	*u = created
+	return nil
+}
+
+func (s *MySQLUserStore) Get(ctx context.Context, id string) (*User2, error) {
+	idInt, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	row := s.MySQL.DB.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id=? AND deleted_at IS NULL", idInt)
+
+	user, err := scanUser(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+// userColumns are the columns scanUser reads, in order.
+const userColumns = "id, name, email, version, created_at, updated_at, deleted_at"
+
+func scanUser(row interface{ Scan(dest ...any) error }) (*User2, error) {
+	var user User2
+	var deletedAt sql.NullTime
+	if err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Version, &user.CreatedAt, &user.UpdatedAt, &deletedAt); err != nil {
+		return nil, err
+	}
+	user.CreatedAt, user.UpdatedAt = user.CreatedAt.UTC(), user.UpdatedAt.UTC()
+	if deletedAt.Valid {
+		at := deletedAt.Time.UTC()
+		user.DeletedAt = &at
+	}
+	return &user, nil
+}
+
+func (s *MySQLUserStore) Update(ctx context.Context, id string, u *User2) error {
+	if err := u.SetKey(id); err != nil {
+		return err
+	}
+	u.Normalize()
+	updated := *u
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		return updateUser(ctx, tx, &updated)
+	})
+	if err != nil {
+		return s.duplicate(ctx, err, u.Email)
+	}
+	*u = updated
+	return nil
+}
+
+func updateUser(ctx context.Context, tx *sql.Tx, u *User2) error {
+	current, err := lockUser(ctx, tx, u.ID, false)
+	if err != nil {
+		return err
+	}
+	if u.Version != 0 && u.Version != current.Version {
+		return ErrVersionMismatch
+	}
+	updated := *u
+	updated.Version = current.Version + 1
+	updated.CreatedAt, updated.UpdatedAt = current.CreatedAt, stamp()
+	updated.DeletedAt = nil
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET name=?,email=?,version=?,updated_at=? WHERE id=?",
+		updated.Name, updated.Email, updated.Version, updated.UpdatedAt, updated.ID); err != nil {
+		return err
+	}
+	if err := appendUserAudit(ctx, tx, newAuditEntry[User2](ctx, AuditUpdate, current, &updated)); err != nil {
+		return err
+	}
+	*u = updated
+	return nil
+}
+
+func (s *MySQLUserStore) Delete(ctx context.Context, id string) error {
+	return s.DeleteVersion(ctx, id, 0)
+}
+
+func (s *MySQLUserStore) DeleteVersion(ctx context.Context, id string, version int64) error {
+	idInt, err := strconv.Atoi(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return deleteUser(ctx, tx, idInt, version)
+	})
+}
+
+func deleteUser(ctx context.Context, tx *sql.Tx, id int, version int64) error {
+	current, err := lockUser(ctx, tx, id, false)
+	if err != nil {
+		return err
+	}
+	if version != 0 && version != current.Version {
+		return ErrVersionMismatch
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id=?", id); err != nil {
+		return err
+	}
+	return appendUserAudit(ctx, tx, newAuditEntry[User2](ctx, AuditDelete, current, nil))
+}
+
+func (s *MySQLUserStore) SoftDelete(ctx context.Context, id string, version int64) error {
+	idInt, err := strconv.Atoi(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		return softDeleteUser(ctx, tx, idInt, version)
+	})
+}
+
+func softDeleteUser(ctx context.Context, tx *sql.Tx, id int, version int64) error {
+	current, err := lockUser(ctx, tx, id, false)
+	if err != nil {
+		return err
+	}
+	if version != 0 && version != current.Version {
+		return ErrVersionMismatch
+	}
+	trashed := *current
+	now := stamp()
+	trashed.DeletedAt = &now
+	trashed.UpdatedAt = now
+	trashed.Version++
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET deleted_at=?, updated_at=?, version=? WHERE id=?", now, now, trashed.Version, id); err != nil {
+		return err
+	}
+	return appendUserAudit(ctx, tx, newAuditEntry[User2](ctx, AuditSoftDelete, current, &trashed))
+}
+
+// Batch applies ops in one transaction. In best-effort mode each operation
+// runs after a savepoint, so a failing one is rolled back on its own and
+// the others are still committed.
+func (s *MySQLUserStore) Batch(ctx context.Context, ops []BatchOp[User2], opts BatchOptions) ([]error, error) {
+	errs := make([]error, len(ops))
+	written := make([]User2, len(ops))
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		for i, op := range ops {
+			if !opts.Atomic {
+				if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_op"); err != nil {
+					return err
+				}
+			}
+			errs[i] = batchUserOp(ctx, tx, op, &written[i], opts.SoftDelete)
+			if errs[i] == nil {
+				continue
+			}
+			if opts.Atomic {
+				return errBatchAborted
+			}
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_op"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, errBatchAborted):
+		notApplied(errs)
+	case err != nil:
+		return nil, err
+	}
+	for i, op := range ops {
+		switch {
+		case errs[i] == nil && op.Record != nil:
+			*op.Record = written[i]
+		case errs[i] != nil && op.Record != nil:
+			errs[i] = s.duplicate(ctx, errs[i], op.Record.Email)
+		}
+	}
+	return errs, nil
+}
+
+// batchUserOp applies one operation of a batch, writing the record it
+// stores to written.
+func batchUserOp(ctx context.Context, tx *sql.Tx, op BatchOp[User2], written *User2, soft bool) error {
+	if op.Op == BatchCreate {
+		*written = *op.Record
+		written.Normalize()
+		return createUser(ctx, tx, written)
+	}
+	id, err := strconv.Atoi(op.ID)
+	if err != nil {
+		return ErrInvalidID
+	}
+	switch {
+	case op.Op == BatchUpdate:
+		*written = *op.Record
+		written.ID, written.Version = id, op.Version
+		written.Normalize()
+		return updateUser(ctx, tx, written)
+	case op.Op == BatchDelete && soft:
+		return softDeleteUser(ctx, tx, id, op.Version)
+	case op.Op == BatchDelete:
+		return deleteUser(ctx, tx, id, op.Version)
+	}
+	return fmt.Errorf("unknown batch operation %q", op.Op)
+}
+
+func (s *MySQLUserStore) Restore(ctx context.Context, id string) (*User2, error) {
+	idInt, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	var restored User2
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		current, err := lockUser(ctx, tx, idInt, true)
+		if err != nil {
+			return err
+		}
+		restored = *current
+		restored.DeletedAt = nil
+		restored.UpdatedAt = stamp()
+		restored.Version++
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET deleted_at=NULL, updated_at=?, version=? WHERE id=?", restored.UpdatedAt, restored.Version, idInt); err != nil {
+			return err
+		}
+		return appendUserAudit(ctx, tx, newAuditEntry[User2](ctx, AuditRestore, current, &restored))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
+
+// inTx runs fn in a transaction, so a write and its audit entry are
+// committed or rolled back together.
+func (s *MySQLUserStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.MySQL.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockUser reads a live user, or a trashed one, and locks its row until the
+// transaction ends.
+func lockUser(ctx context.Context, tx *sql.Tx, id int, trashed bool) (*User2, error) {
+	cond := "deleted_at IS NULL"
+	if trashed {
+		cond = "deleted_at IS NOT NULL"
+	}
+	user, err := scanUser(tx.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id=? AND "+cond+" FOR UPDATE", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return user, err
+}
+
+// appendUserAudit adds entry to users_audit, the append-only log of user
+// writes.
+func appendUserAudit(ctx context.Context, tx *sql.Tx, entry AuditEntry) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO users_audit (user_id, op, actor, request_id, at, version, changes) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		entry.RecordID, entry.Op, entry.Actor, entry.RequestID, entry.At, entry.Version, changes)
+	return err
+}
+
+func (s *MySQLUserStore) History(ctx context.Context, id string) ([]AuditEntry, error) {
+	idInt, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	rows, err := s.MySQL.DB.QueryContext(ctx, "SELECT user_id, op, actor, request_id, at, version, changes FROM users_audit WHERE user_id=? ORDER BY id", idInt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var changes []byte
+		if err := rows.Scan(&e.RecordID, &e.Op, &e.Actor, &e.RequestID, &e.At, &e.Version, &changes); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changes, &e.Changes); err != nil {
+			return nil, err
+		}
+		e.At = e.At.UTC()
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return entries, nil
+}
+
+// purgeBatch bounds each DELETE of Purge so it does not hold locks on a
+// large part of the table.
+const purgeBatch = 1000
+
+func (s *MySQLUserStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+	for {
+		var n int
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			rows, err := tx.QueryContext(ctx, "SELECT "+userColumns+" FROM users WHERE deleted_at IS NOT NULL AND deleted_at < ? ORDER BY id LIMIT ? FOR UPDATE", before.UTC(), purgeBatch)
+			if err != nil {
+				return err
+			}
+			var users []*User2
+			for rows.Next() {
+				user, err := scanUser(rows)
+				if err != nil {
+					rows.Close()
+					return err
+				}
+				users = append(users, user)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil || len(users) == 0 {
+				return err
+			}
+			ids := make([]any, len(users))
+			for i, u := range users {
+				ids[i] = u.ID
+			}
+			placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+			if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id IN ("+placeholders+")", ids...); err != nil {
+				return err
+			}
+			for _, u := range users {
+				if err := appendUserAudit(ctx, tx, newAuditEntry[User2](ctx, AuditPurge, u, nil)); err != nil {
+					return err
+				}
+			}
+			n = len(users)
+			return nil
+		})
+		if err != nil {
+			return purged, err
+		}
+		purged += int64(n)
+		if n < purgeBatch {
+			return purged, nil
+		}
+	}
+}
+
+func (s *MySQLUserStore) List(ctx context.Context, q ListQuery) (Page[User2], error) {
+	return s.list(ctx, q, false)
+}
+
+func (s *MySQLUserStore) Trash(ctx context.Context, q ListQuery) (Page[User2], error) {
+	return s.list(ctx, q, true)
+}
+
+// list pages through the live users, or the trashed ones, with keyset
+// pagination: instead of an OFFSET the query continues strictly after the
+// (sort value, id) of the cursor.
+func (s *MySQLUserStore) list(ctx context.Context, q ListQuery, trashed bool) (Page[User2], error) {
+	q, err := q.normalized()
+	if err != nil {
+		return Page[User2]{}, err
+	}
+	after, err := decodeCursor(q.Cursor, q.Sort)
+	if err != nil {
+		return Page[User2]{}, err
+	}
+	where := []string{"deleted_at IS NULL"}
+	if trashed {
+		where = []string{"deleted_at IS NOT NULL"}
+	}
+	var args []any
+	if q.EmailDomain != "" {
+		where = append(where, "email LIKE ?")
+		args = append(args, "%@"+escapeLike(q.EmailDomain))
+	}
+	if !q.CreatedSince.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, q.CreatedSince)
+	}
+	if !q.UpdatedSince.IsZero() {
+		where = append(where, "updated_at >= ?")
+		args = append(args, q.UpdatedSince)
+	}
+	filter := where
+	filterArgs := args
+
+	if after.ID != "" {
+		lastID, err := strconv.Atoi(after.ID)
+		if err != nil {
+			return Page[User2]{}, ErrInvalidCursor
+		}
+		if q.Sort == "id" {
+			where = append(where, "id > ?")
+			args = append(args, lastID)
+		} else {
+			value, err := cursorValue(q.Sort, after.Value)
+			if err != nil {
+				return Page[User2]{}, err
+			}
+			where = append(where, "("+q.Sort+" > ? OR ("+q.Sort+" = ? AND id > ?))")
+			args = append(args, value, value, lastID)
+		}
+	}
+	order := "id"
+	if q.Sort != "id" {
+		order = q.Sort + ", id"
+	}
+	query := "SELECT " + userColumns + " FROM users" + whereClause(where) + " ORDER BY " + order + " LIMIT ?"
+	rows, err := s.MySQL.DB.QueryContext(ctx, query, append(args, q.Limit+1)...)
+	if err != nil {
+		return Page[User2]{}, err
+	}
+	defer rows.Close()
+
+	page := Page[User2]{Items: []User2{}}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return Page[User2]{}, err
+		}
+		page.Items = append(page.Items, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return Page[User2]{}, err
+	}
+	more := len(page.Items) > q.Limit
+	if more {
+		page.Items = page.Items[:q.Limit]
+	}
+	page.NextCursor = nextCursor[User2](q, page.Items, more)
+
+	if q.WithTotal {
+		var total int64
+		if err := s.MySQL.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM users"+whereClause(filter), filterArgs...).Scan(&total); err != nil {
+			return Page[User2]{}, err
+		}
+		page.Total = &total
+	}
+	return page, nil
+}
+
+// mysqlUniqueEmailIndex is the unique index on users.email. Migration 1
+// creates it with the table; EnsureUniqueEmail adds it to tables created
+// before that. The column's collation is case-insensitive, so it also rejects
+// emails that only differ in case.
+const mysqlUniqueEmailIndex = "users_email_unique"
+
+// duplicate turns a duplicate entry error for email into a DuplicateError
+// naming the user that has it. Other errors are returned unchanged.
+func (s *MySQLUserStore) duplicate(ctx context.Context, err error, email string) error {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) || mysqlErr.Number != 1062 {
+		return err
+	}
+	dup := &DuplicateError{Field: "email", Value: email}
+	var id int
+	if s.MySQL.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE email=? LIMIT 1", email).Scan(&id) == nil {
+		dup.ExistingID = strconv.Itoa(id)
+	}
+	return dup
+}
+
+func (s *MySQLUserStore) FindDuplicateEmails(ctx context.Context) ([]DuplicateGroup, error) {
+	rows, err := s.MySQL.DB.QueryContext(ctx, `SELECT LOWER(TRIM(email)) AS normalized, GROUP_CONCAT(id ORDER BY id)
+		FROM users GROUP BY normalized HAVING COUNT(*) > 1 ORDER BY normalized`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []DuplicateGroup
+	for rows.Next() {
+		var g DuplicateGroup
+		var ids string
+		if err := rows.Scan(&g.Email, &ids); err != nil {
+			return nil, err
+		}
+		g.IDs = strings.Split(ids, ",")
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+func (s *MySQLUserStore) EnsureUniqueEmail(ctx context.Context) error {
+	return ensureUniqueEmail(ctx, s, func(ctx context.Context) (bool, error) {
+		var n int
+		err := s.MySQL.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM information_schema.statistics
+			WHERE table_schema = DATABASE() AND table_name = 'users' AND index_name = ?`, mysqlUniqueEmailIndex).Scan(&n)
+		return n > 0, err
+	}, func(ctx context.Context) error {
+		_, err := s.MySQL.DB.ExecContext(ctx, "ALTER TABLE users ADD UNIQUE INDEX "+mysqlUniqueEmailIndex+" (email)")
+		return err
+	})
+}
+
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,103 @@
+package hybridsystem_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// countingStore counts the lookups that reach the database.
+type countingStore struct {
+	hybridsystem.UserStore[hybridsystem.User2]
+	gets atomic.Int64
+}
+
+func (s *countingStore) Get(ctx context.Context, id string) (*hybridsystem.User2, error) {
+	s.gets.Add(1)
+	return s.UserStore.Get(ctx, id)
+}
+
+func getUser(handle *hybridsystem.HybridHandler3, id string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/users/"+id, nil)
+	r = mux.SetURLVars(r, map[string]string{"id": id})
+	w := httptest.NewRecorder()
+	handle.GetUserHandler3(w, r)
+	return w
+}
+
+func TestHybridHandler3_NegativeCache(t *testing.T) {
+	store := &countingStore{UserStore: hybridsystem.NewMemoryUserStore()}
+	cache := hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)
+	cache.NotFoundTTL = time.Minute
+	handle := &hybridsystem.HybridHandler3{Users: store, UserCache: cache}
+
+	for i := 0; i < 3; i++ {
+		if w := getUser(handle, "1"); w.Code != http.StatusNotFound {
+			t.Fatalf("expected not found, got %d", w.Code)
+		}
+	}
+	if got := store.gets.Load(); got != 1 {
+		t.Fatalf("expected one database lookup for repeated misses, got %d", got)
+	}
+	if got := cache.Stats().NotFound; got != 2 {
+		t.Errorf("expected 2 reads answered by the tombstone, got %d", got)
+	}
+
+	// the memory store hands out id 1 next, which replaces the tombstone
+	body, _ := json.Marshal(hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com"})
+	w := httptest.NewRecorder()
+	handle.CreateUserHandler3(w, httptest.NewRequest(http.MethodPost, "/users", bytes.NewBuffer(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status created, got %d", w.Code)
+	}
+	if w := getUser(handle, "1"); w.Code != http.StatusOK {
+		t.Fatalf("expected the new record after create, got %d", w.Code)
+	}
+}
+
+func TestCache_TombstoneExpires(t *testing.T) {
+	ctx := context.Background()
+	cache := hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)
+	cache.NotFoundTTL = 20 * time.Millisecond
+
+	loads := 0
+	load := func(ctx context.Context) (*hybridsystem.User2, error) {
+		loads++
+		return nil, hybridsystem.ErrNotFound
+	}
+	cache.Fetch(ctx, "9648", load)
+	cache.Fetch(ctx, "9648", load)
+	time.Sleep(40 * time.Millisecond)
+	cache.Fetch(ctx, "9648", load)
+	if loads != 2 {
+		t.Fatalf("expected the tombstone to expire after NotFoundTTL, loader ran %d times", loads)
+	}
+}
+
+// The legacy handlers share one cache across requests, so its tombstones
+// and counters outlive a request.
+func TestHybridHandler3_ServerCache(t *testing.T) {
+	store := &countingStore{UserStore: hybridsystem.NewMemoryUserStore()}
+	cache := hybridsystem.ServerCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, hybridsystem.TimeoutConfig{})
+	handle := &hybridsystem.HybridHandler3{Users: store, UserCache: cache}
+
+	for i := 0; i < 3; i++ {
+		if w := getUser(handle, "1"); w.Code != http.StatusNotFound {
+			t.Fatalf("expected not found, got %d", w.Code)
+		}
+	}
+	if got := store.gets.Load(); got != 1 {
+		t.Errorf("expected one database lookup for repeated misses, got %d", got)
+	}
+	if stats := handle.UserCacheStats(); stats.Misses != 1 || stats.NotFound != 2 {
+		t.Errorf("expected 1 miss and 2 tombstone reads, got %+v", stats)
+	}
+}
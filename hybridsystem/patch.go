@@ -0,0 +1,367 @@
+package hybridsystem
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Media types of the patch documents PATCH accepts. Plain application/json
+// is read as a merge patch.
+const (
+	MergePatchContentType = "application/merge-patch+json"
+	JSONPatchContentType  = "application/json-patch+json"
+)
+
+var (
+	// ErrMalformedPatch is returned for a patch document that is not valid
+	// JSON or not shaped like a patch at all.
+	ErrMalformedPatch = errors.New("malformed patch")
+	// ErrPatchFailed is returned when a JSON Patch operation cannot be
+	// applied to the record, e.g. because its path does not exist.
+	ErrPatchFailed = errors.New("patch cannot be applied")
+	// ErrPatchTestFailed is returned when a JSON Patch "test" operation
+	// does not match: the record is not in the state the client expected.
+	ErrPatchTestFailed = errors.New("patch test failed")
+)
+
+// MergePatch applies an RFC 7396 JSON Merge Patch to doc: members of patch
+// replace those of doc, nested objects are merged, and null removes a member.
+func MergePatch(doc, patch []byte) ([]byte, error) {
+	target, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	p, err := decodeJSON(patch)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedPatch, err)
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = map[string]any{}
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+		} else {
+			t[name] = mergeValue(t[name], value)
+		}
+	}
+	return t
+}
+
+// jsonPatchOp is one operation of an RFC 6902 JSON Patch. hasValue tells a
+// "value" of null, which is valid, from a missing one.
+type jsonPatchOp struct {
+	Op       string          `json:"op"`
+	Path     *string         `json:"path"`
+	From     *string         `json:"from"`
+	Value    json.RawMessage `json:"-"`
+	hasValue bool
+}
+
+func (op *jsonPatchOp) UnmarshalJSON(data []byte) error {
+	type fields jsonPatchOp
+	if err := json.Unmarshal(data, (*fields)(op)); err != nil {
+		return err
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	op.Value, op.hasValue = members["value"]
+	return nil
+}
+
+// JSONPatch applies an RFC 6902 JSON Patch to doc. The operations are applied
+// in order and all or nothing: the first failing one aborts the patch.
+func JSONPatch(doc, patch []byte) ([]byte, error) {
+	target, err := decodeJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: a JSON Patch is an array of operations: %v", ErrMalformedPatch, err)
+	}
+	for i, op := range ops {
+		if target, err = op.apply(target); err != nil {
+			return nil, fmt.Errorf("operation %d (%s): %w", i, op.Op, err)
+		}
+	}
+	return json.Marshal(target)
+}
+
+func (op jsonPatchOp) apply(doc any) (any, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("%w: missing path", ErrMalformedPatch)
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if !op.hasValue {
+			return nil, fmt.Errorf("%w: missing value", ErrMalformedPatch)
+		}
+		if value, err = decodeJSON(op.Value); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedPatch, err)
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, fmt.Errorf("%w: missing from", ErrMalformedPatch)
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = pointerGet(doc, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if isPrefix(from, path) && len(from) < len(path) {
+				return nil, fmt.Errorf("%w: cannot move %q into itself", ErrPatchFailed, *op.From)
+			}
+			if doc, err = pointerRemove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			value = deepCopy(value)
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrMalformedPatch, op.Op)
+	}
+
+	switch op.Op {
+	case "remove":
+		return pointerRemove(doc, path)
+	case "replace":
+		if len(path) == 0 {
+			// the empty path is the whole document, which always exists
+			return value, nil
+		}
+		if _, err := pointerGet(doc, path); err != nil {
+			return nil, err
+		}
+		if doc, err = pointerRemove(doc, path); err != nil {
+			return nil, err
+		}
+		return pointerAdd(doc, path, value)
+	case "test":
+		current, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(current, value) {
+			return nil, fmt.Errorf("%w: %q does not have the expected value", ErrPatchTestFailed, *op.Path)
+		}
+		return doc, nil
+	}
+	return pointerAdd(doc, path, value)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must be empty or start with /", ErrMalformedPatch, pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+func pointerGet(doc any, path []string) (any, error) {
+	for i, token := range path {
+		switch node := doc.(type) {
+		case map[string]any:
+			v, ok := node[token]
+			if !ok {
+				return nil, missingPath(path[:i+1])
+			}
+			doc = v
+		case []any:
+			idx, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, missingPath(path[:i+1])
+			}
+			doc = node[idx]
+		default:
+			return nil, missingPath(path[:i+1])
+		}
+	}
+	return doc, nil
+}
+
+// pointerAdd adds value at path: it sets an object member, inserts into an
+// array ("-" appends) or replaces the whole document for the empty path.
+func pointerAdd(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = value
+		return doc, nil
+	case []any:
+		idx := len(node)
+		if last != "-" {
+			if idx, err = arrayIndex(last, len(node)); err != nil {
+				return nil, missingPath(path)
+			}
+		}
+		node = append(node[:idx], append([]any{value}, node[idx:]...)...)
+		return setParent(doc, path[:len(path)-1], node)
+	}
+	return nil, missingPath(path)
+}
+
+func pointerRemove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: cannot remove the whole record", ErrPatchFailed)
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		if _, ok := node[last]; !ok {
+			return nil, missingPath(path)
+		}
+		delete(node, last)
+		return doc, nil
+	case []any:
+		idx, err := arrayIndex(last, len(node)-1)
+		if err != nil {
+			return nil, missingPath(path)
+		}
+		return setParent(doc, path[:len(path)-1], append(node[:idx], node[idx+1:]...))
+	}
+	return nil, missingPath(path)
+}
+
+// setParent stores a resized array back into its parent.
+func setParent(doc any, path []string, array []any) (any, error) {
+	if len(path) == 0 {
+		return array, nil
+	}
+	parent, err := pointerGet(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]any:
+		node[last] = array
+	case []any:
+		idx, _ := arrayIndex(last, len(node)-1)
+		node[idx] = array
+	}
+	return doc, nil
+}
+
+// arrayIndex parses an array index token no greater than max. Leading zeros
+// are not allowed by RFC 6901.
+func arrayIndex(token string, max int) (int, error) {
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || idx > max || (len(token) > 1 && token[0] == '0') {
+		return 0, fmt.Errorf("bad index %q", token)
+	}
+	return idx, nil
+}
+
+func missingPath(path []string) error {
+	escaped := make([]string, len(path))
+	for i, t := range path {
+		escaped[i] = strings.NewReplacer("~", "~0", "/", "~1").Replace(t)
+	}
+	return fmt.Errorf("%w: path %q does not exist", ErrPatchFailed, "/"+strings.Join(escaped, "/"))
+}
+
+func isPrefix(prefix, path []string) bool {
+	return len(prefix) <= len(path) && reflect.DeepEqual(prefix, path[:len(prefix)])
+}
+
+func deepCopy(v any) any {
+	data, _ := json.Marshal(v)
+	copied, _ := decodeJSON(data)
+	return copied
+}
+
+// jsonEqual compares decoded JSON values; numbers compare by value, so 1 and
+// 1.0 are equal.
+func jsonEqual(a, b any) bool {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		x, errA := a.Float64()
+		y, errB := b.Float64()
+		return errA == nil && errB == nil && x == y
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			if w, ok := b[k]; !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !jsonEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// decodeJSON decodes a single JSON value keeping numbers exact.
+func decodeJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the JSON value")
+	}
+	return v, nil
+}
@@ -0,0 +1,243 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// sameJSON compares two JSON documents ignoring member order.
+func sameJSON(t *testing.T, got []byte, want string) bool {
+	t.Helper()
+	var a, b any
+	if err := json.Unmarshal(got, &a); err != nil {
+		t.Fatalf("invalid JSON %s: %v", got, err)
+	}
+	if err := json.Unmarshal([]byte(want), &b); err != nil {
+		t.Fatalf("invalid JSON %s: %v", want, err)
+	}
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
+
+func TestMergePatch(t *testing.T) {
+	// cases from RFC 7396, appendix A
+	tests := []struct {
+		name  string // description of this test case
+		doc   string
+		patch string
+		want  string
+	}{
+		{name: "replace member", doc: `{"a":"b"}`, patch: `{"a":"c"}`, want: `{"a":"c"}`},
+		{name: "add member", doc: `{"a":"b"}`, patch: `{"b":"c"}`, want: `{"a":"b","b":"c"}`},
+		{name: "null removes", doc: `{"a":"b"}`, patch: `{"a":null}`, want: `{}`},
+		{name: "nested merge", doc: `{"a":{"b":"c"}}`, patch: `{"a":{"b":"d","c":null}}`, want: `{"a":{"b":"d"}}`},
+		{name: "arrays are replaced", doc: `{"a":[{"b":"c"}]}`, patch: `{"a":[1]}`, want: `{"a":[1]}`},
+		{name: "non-object patch replaces", doc: `{"a":"foo"}`, patch: `["c"]`, want: `["c"]`},
+		{name: "null inside new object is dropped", doc: `{}`, patch: `{"a":{"bb":{"ccc":null}}}`, want: `{"a":{"bb":{}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := hybridsystem.MergePatch([]byte(tt.doc), []byte(tt.patch))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !sameJSON(t, got, tt.want) {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+	if _, err := hybridsystem.MergePatch([]byte(`{}`), []byte(`{"a":`)); !errors.Is(err, hybridsystem.ErrMalformedPatch) {
+		t.Errorf("expected ErrMalformedPatch, got %v", err)
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		name    string // description of this test case
+		doc     string
+		patch   string
+		want    string
+		wantErr error
+	}{
+		{name: "add member", doc: `{"foo":"bar"}`, patch: `[{"op":"add","path":"/baz","value":"qux"}]`, want: `{"foo":"bar","baz":"qux"}`},
+		{name: "add into array", doc: `{"foo":["bar","baz"]}`, patch: `[{"op":"add","path":"/foo/1","value":"qux"}]`, want: `{"foo":["bar","qux","baz"]}`},
+		{name: "append to array", doc: `{"foo":[1]}`, patch: `[{"op":"add","path":"/foo/-","value":2}]`, want: `{"foo":[1,2]}`},
+		{name: "remove", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"remove","path":"/baz"}]`, want: `{"foo":"bar"}`},
+		{name: "remove from array", doc: `{"foo":["bar","qux","baz"]}`, patch: `[{"op":"remove","path":"/foo/1"}]`, want: `{"foo":["bar","baz"]}`},
+		{name: "replace", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"replace","path":"/baz","value":"boo"}]`, want: `{"baz":"boo","foo":"bar"}`},
+		{name: "replace the whole document", doc: `{"baz":"qux"}`, patch: `[{"op":"replace","path":"","value":{"foo":"bar"}}]`, want: `{"foo":"bar"}`},
+		{name: "replace with null", doc: `{"baz":"qux","foo":"bar"}`, patch: `[{"op":"replace","path":"/baz","value":null}]`, want: `{"baz":null,"foo":"bar"}`},
+		{name: "add null and test it", doc: `{}`, patch: `[{"op":"add","path":"/a","value":null},{"op":"test","path":"/a","value":null}]`, want: `{"a":null}`},
+		{name: "move", doc: `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, patch: `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, want: `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{name: "copy", doc: `{"a":{"b":1}}`, patch: `[{"op":"copy","from":"/a","path":"/c"}]`, want: `{"a":{"b":1},"c":{"b":1}}`},
+		{name: "escaped pointer", doc: `{"a/b":1,"m~n":2}`, patch: `[{"op":"replace","path":"/a~1b","value":3},{"op":"remove","path":"/m~0n"}]`, want: `{"a/b":3}`},
+		{name: "test passes", doc: `{"baz":"qux","foo":[1,2]}`, patch: `[{"op":"test","path":"/foo","value":[1,2.0]},{"op":"test","path":"/baz","value":"qux"}]`, want: `{"baz":"qux","foo":[1,2]}`},
+		{name: "test fails", doc: `{"baz":"qux"}`, patch: `[{"op":"test","path":"/baz","value":"bar"}]`, wantErr: hybridsystem.ErrPatchTestFailed},
+		{name: "all or nothing", doc: `{"a":1}`, patch: `[{"op":"add","path":"/b","value":2},{"op":"remove","path":"/c"}]`, wantErr: hybridsystem.ErrPatchFailed},
+		{name: "replace missing member", doc: `{}`, patch: `[{"op":"replace","path":"/a","value":1}]`, wantErr: hybridsystem.ErrPatchFailed},
+		{name: "add to missing parent", doc: `{}`, patch: `[{"op":"add","path":"/a/b","value":1}]`, wantErr: hybridsystem.ErrPatchFailed},
+		{name: "index out of range", doc: `{"a":[1]}`, patch: `[{"op":"add","path":"/a/3","value":1}]`, wantErr: hybridsystem.ErrPatchFailed},
+		{name: "move into itself", doc: `{"a":{"b":1}}`, patch: `[{"op":"move","from":"/a","path":"/a/c"}]`, wantErr: hybridsystem.ErrPatchFailed},
+		{name: "unknown op", doc: `{}`, patch: `[{"op":"merge","path":"/a"}]`, wantErr: hybridsystem.ErrMalformedPatch},
+		{name: "missing value", doc: `{}`, patch: `[{"op":"add","path":"/a"}]`, wantErr: hybridsystem.ErrMalformedPatch},
+		{name: "relative path", doc: `{}`, patch: `[{"op":"remove","path":"a"}]`, wantErr: hybridsystem.ErrMalformedPatch},
+		{name: "not an array", doc: `{}`, patch: `{"a":1}`, wantErr: hybridsystem.ErrMalformedPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := hybridsystem.JSONPatch([]byte(tt.doc), []byte(tt.patch))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !sameJSON(t, got, tt.want) {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResource_Patch(t *testing.T) {
+	tests := []struct {
+		name        string // description of this test case
+		contentType string
+		patch       string
+		wantCode    int
+		wantName    string
+		wantEmail   string
+	}{
+		{
+			name:        "merge patch keeps omitted fields",
+			contentType: hybridsystem.MergePatchContentType,
+			patch:       `{"name":"Akash Paul"}`,
+			wantCode:    http.StatusOK,
+			wantName:    "Akash Paul",
+			wantEmail:   "akash@gmail.com",
+		},
+		{
+			name:        "plain json is a merge patch",
+			contentType: "application/json; charset=utf-8",
+			patch:       `{"email":"Paul@Gmail.com"}`,
+			wantCode:    http.StatusOK,
+			wantName:    "Akash",
+			wantEmail:   "paul@gmail.com",
+		},
+		{
+			name:        "json patch",
+			contentType: hybridsystem.JSONPatchContentType,
+			patch:       `[{"op":"test","path":"/name","value":"Akash"},{"op":"replace","path":"/name","value":"Paul"}]`,
+			wantCode:    http.StatusOK,
+			wantName:    "Paul",
+			wantEmail:   "akash@gmail.com",
+		},
+		{
+			name:        "merged result is validated",
+			contentType: hybridsystem.MergePatchContentType,
+			patch:       `{"email":null}`,
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "id cannot change",
+			contentType: hybridsystem.MergePatchContentType,
+			patch:       `{"id":2}`,
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "failed test",
+			contentType: hybridsystem.JSONPatchContentType,
+			patch:       `[{"op":"test","path":"/name","value":"Someone"}]`,
+			wantCode:    http.StatusConflict,
+		},
+		{
+			name:        "missing path",
+			contentType: hybridsystem.JSONPatchContentType,
+			patch:       `[{"op":"remove","path":"/nickname"}]`,
+			wantCode:    http.StatusUnprocessableEntity,
+		},
+		{
+			name:        "malformed patch",
+			contentType: hybridsystem.JSONPatchContentType,
+			patch:       `{"name":"Paul"}`,
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			name:        "unsupported media type",
+			contentType: "text/plain",
+			patch:       `name=Paul`,
+			wantCode:    http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := hybridsystem.NewMemoryUserStore()
+			store.Create(ctx, &hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com"})
+			backend := hybridsystem.NewMemoryBackend()
+			cache := hybridsystem.NewCache[hybridsystem.User2](backend, hybridsystem.UserKeys, time.Minute)
+			cache.Locker = backend
+			res := &hybridsystem.Resource[hybridsystem.User2, *hybridsystem.User2]{Name: "user", Store: store, Cache: cache, Validate: hybridsystem.ValidateUser}
+			router := mux.NewRouter()
+			res.Register(router, "/users")
+
+			r := httptest.NewRequest(http.MethodPatch, "/users/1", strings.NewReader(tt.patch))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, w.Code, w.Body)
+			}
+
+			stored, _ := store.Get(ctx, "1")
+			cached, err := backend.Get(ctx, hybridsystem.UserKeys.Key("1"))
+			if tt.wantCode != http.StatusOK {
+				if stored.Name != "Akash" || err == nil {
+					t.Errorf("a failed patch must change nothing, got %+v and cache %s", stored, cached)
+				}
+				return
+			}
+			if stored.ID != 1 || stored.Name != tt.wantName || stored.Email != tt.wantEmail {
+				t.Errorf("expected %s <%s>, stored %+v", tt.wantName, tt.wantEmail, stored)
+			}
+			want, _ := json.Marshal(stored)
+			if string(cached) != string(want) || !sameJSON(t, w.Body.Bytes(), string(want)) {
+				t.Errorf("expected response and cache to be %s, got %s and %s", want, w.Body, cached)
+			}
+		})
+	}
+}
+
+func TestCache_LockBusy(t *testing.T) {
+	backend := hybridsystem.NewMemoryBackend()
+	cache := hybridsystem.NewCache[hybridsystem.User2](backend, hybridsystem.UserKeys, time.Minute)
+	cache.Locker = backend
+	cache.LockWait = 50 * time.Millisecond
+
+	release, err := cache.Lock(context.Background(), "1")
+	if err != nil {
+		t.Fatalf("lock failed: %v", err)
+	}
+	if _, err := cache.Lock(context.Background(), "1"); !errors.Is(err, hybridsystem.ErrRecordBusy) {
+		t.Fatalf("expected ErrRecordBusy while held, got %v", err)
+	}
+	release()
+	if release, err := cache.Lock(context.Background(), "1"); err != nil {
+		t.Fatalf("expected the lock once released, got %v", err)
+	} else {
+		release()
+	}
+}
@@ -0,0 +1,158 @@
+package hybridsystem
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"redisDatabase/validation"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Problem types. They are relative URI references as allowed by RFC 7807
+// and identify the kind of error independently of its wording.
+const (
+	ProblemTypeValidation           = "/problems/validation"
+	ProblemTypeMalformedBody        = "/problems/malformed-body"
+	ProblemTypeInvalidID            = "/problems/invalid-id"
+	ProblemTypeBadQuery             = "/problems/bad-query"
+	ProblemTypeNotFound             = "/problems/not-found"
+	ProblemTypeConflict             = "/problems/conflict"
+	ProblemTypePatchFailed          = "/problems/patch-failed"
+	ProblemTypePreconditionFailed   = "/problems/precondition-failed"
+	ProblemTypeUnsupportedMediaType = "/problems/unsupported-media-type"
+	ProblemTypeNotApplied           = "/problems/not-applied"
+	ProblemTypeTimeout              = "/problems/timeout"
+	ProblemTypeInternal             = "/problems/internal"
+)
+
+// ProblemContentType is the media type of a Problem.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object. Every handler error is
+// written as one.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	// ExistingID is the record a conflicting write collided with.
+	ExistingID string `json:"existing_id,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Title + ": " + p.Detail
+	}
+	return p.Title
+}
+
+// FieldError is one invalid field of a request body.
+type FieldError = validation.FieldError
+
+// NewProblem returns a problem of the given type whose title is the status
+// text.
+func NewProblem(status int, problemType, detail string) *Problem {
+	return &Problem{Type: problemType, Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// ProblemFor maps an error from a store, cache or validator to the problem
+// the client should see. Unknown errors become a 500 that does not leak the
+// underlying message.
+func ProblemFor(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		copied := *p
+		return &copied
+	}
+	var invalid validation.Errors
+	if errors.As(err, &invalid) {
+		p := NewProblem(http.StatusBadRequest, ProblemTypeValidation, "the request body has invalid fields")
+		p.Errors = invalid
+		return p
+	}
+	var dup *DuplicateError
+	if errors.As(err, &dup) {
+		p := NewProblem(http.StatusConflict, ProblemTypeConflict, fmt.Sprintf("%s %q is already in use", dup.Field, dup.Value))
+		p.Errors = []FieldError{{Field: dup.Field, Message: "is already in use"}}
+		p.ExistingID = dup.ExistingID
+		return p
+	}
+	var mysqlErr *mysql.MySQLError
+	var mongoErr mongo.ServerError
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, sql.ErrNoRows), errors.Is(err, mongo.ErrNoDocuments):
+		return NewProblem(http.StatusNotFound, ProblemTypeNotFound, "")
+	case errors.Is(err, ErrInvalidID):
+		return NewProblem(http.StatusBadRequest, ProblemTypeInvalidID, "invalid id format")
+	case errors.Is(err, ErrInvalidCursor), errors.Is(err, ErrInvalidSort):
+		return NewProblem(http.StatusBadRequest, ProblemTypeBadQuery, err.Error())
+	case errors.Is(err, ErrVersionMismatch):
+		return NewProblem(http.StatusPreconditionFailed, ProblemTypePreconditionFailed, "the record has been modified since the version in If-Match; fetch it again")
+	case errors.Is(err, ErrMalformedPatch):
+		return NewProblem(http.StatusBadRequest, ProblemTypeMalformedBody, err.Error())
+	case errors.Is(err, ErrPatchTestFailed):
+		return NewProblem(http.StatusConflict, ProblemTypeConflict, err.Error())
+	case errors.Is(err, ErrPatchFailed):
+		return NewProblem(http.StatusUnprocessableEntity, ProblemTypePatchFailed, err.Error())
+	case errors.Is(err, ErrNotApplied):
+		return NewProblem(http.StatusFailedDependency, ProblemTypeNotApplied, err.Error())
+	case errors.Is(err, ErrRecordBusy):
+		return NewProblem(http.StatusConflict, ProblemTypeConflict, err.Error()+", retry")
+	case mongo.IsDuplicateKeyError(err):
+		return NewProblem(http.StatusConflict, ProblemTypeConflict, "a record with the same unique value already exists")
+	case errors.As(err, &mysqlErr):
+		return mysqlProblem(mysqlErr)
+	case errors.As(err, &mongoErr) && mongoErr.HasErrorCode(121): // DocumentValidationFailure
+		return NewProblem(http.StatusBadRequest, ProblemTypeValidation, "the document failed schema validation")
+	case errors.Is(err, context.DeadlineExceeded), mongo.IsTimeout(err):
+		return NewProblem(http.StatusGatewayTimeout, ProblemTypeTimeout, "the database did not answer in time")
+	}
+	return NewProblem(http.StatusInternalServerError, ProblemTypeInternal, "")
+}
+
+// mysqlProblem maps the MySQL server errors caused by the request itself.
+// See https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+func mysqlProblem(err *mysql.MySQLError) *Problem {
+	switch err.Number {
+	case 1062: // ER_DUP_ENTRY
+		return NewProblem(http.StatusConflict, ProblemTypeConflict, "a record with the same unique value already exists")
+	case 1048, 1364, 1366, 1406: // null, missing, incorrect or too long value
+		return NewProblem(http.StatusBadRequest, ProblemTypeValidation, err.Message)
+	}
+	return NewProblem(http.StatusInternalServerError, ProblemTypeInternal, "")
+}
+
+// WriteProblem writes p, filling in the instance and request id from r.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	out := *p
+	if out.Instance == "" {
+		out.Instance = r.URL.Path
+	}
+	if out.RequestID == "" {
+		out.RequestID = RequestIDFrom(r.Context())
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(out.Status)
+	json.NewEncoder(w).Encode(out)
+}
+
+// UseProblems makes the router answer unknown routes and methods with
+// problems too.
+func UseProblems(r *mux.Router) {
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteProblem(w, r, NewProblem(http.StatusNotFound, ProblemTypeNotFound, "no route for "+r.URL.Path))
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteProblem(w, r, NewProblem(http.StatusMethodNotAllowed, "about:blank", fmt.Sprintf("%s is not allowed on %s", r.Method, r.URL.Path)))
+	})
+}
@@ -0,0 +1,154 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"redisDatabase/validation"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestProblemFor(t *testing.T) {
+	tests := []struct {
+		name       string // description of this test case
+		err        error
+		wantStatus int
+		wantType   string
+	}{
+		{name: "store not found", err: hybridsystem.ErrNotFound, wantStatus: http.StatusNotFound, wantType: hybridsystem.ProblemTypeNotFound},
+		{name: "sql no rows", err: fmt.Errorf("get: %w", sql.ErrNoRows), wantStatus: http.StatusNotFound, wantType: hybridsystem.ProblemTypeNotFound},
+		{name: "mongo no documents", err: mongo.ErrNoDocuments, wantStatus: http.StatusNotFound, wantType: hybridsystem.ProblemTypeNotFound},
+		{name: "invalid id", err: hybridsystem.ErrInvalidID, wantStatus: http.StatusBadRequest, wantType: hybridsystem.ProblemTypeInvalidID},
+		{name: "invalid cursor", err: hybridsystem.ErrInvalidCursor, wantStatus: http.StatusBadRequest, wantType: hybridsystem.ProblemTypeBadQuery},
+		{name: "mysql duplicate entry", err: &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, wantStatus: http.StatusConflict, wantType: hybridsystem.ProblemTypeConflict},
+		{name: "duplicate email", err: &hybridsystem.DuplicateError{Field: "email", Value: "a@b.com", ExistingID: "7"}, wantStatus: http.StatusConflict, wantType: hybridsystem.ProblemTypeConflict},
+		{name: "mysql value too long", err: &mysql.MySQLError{Number: 1406, Message: "Data too long for column 'name'"}, wantStatus: http.StatusBadRequest, wantType: hybridsystem.ProblemTypeValidation},
+		{
+			name:       "mongo duplicate key",
+			err:        mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000, Message: "E11000 duplicate key error"}}},
+			wantStatus: http.StatusConflict,
+			wantType:   hybridsystem.ProblemTypeConflict,
+		},
+		{name: "deadline", err: context.DeadlineExceeded, wantStatus: http.StatusGatewayTimeout, wantType: hybridsystem.ProblemTypeTimeout},
+		{
+			name:       "validation",
+			err:        validation.Errors{{Field: "email", Message: "is required"}},
+			wantStatus: http.StatusBadRequest,
+			wantType:   hybridsystem.ProblemTypeValidation,
+		},
+		{name: "unknown error", err: errors.New("dial tcp 10.0.0.5:3306: connection refused"), wantStatus: http.StatusInternalServerError, wantType: hybridsystem.ProblemTypeInternal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := hybridsystem.ProblemFor(tt.err)
+			if p.Status != tt.wantStatus || p.Type != tt.wantType {
+				t.Fatalf("expected %d %s, got %d %s", tt.wantStatus, tt.wantType, p.Status, p.Type)
+			}
+			if p.Title != http.StatusText(tt.wantStatus) {
+				t.Errorf("unexpected title %q", p.Title)
+			}
+			if p.Status == http.StatusInternalServerError && strings.Contains(p.Detail, "10.0.0.5") {
+				t.Errorf("internal error leaked to the client: %q", p.Detail)
+			}
+		})
+	}
+}
+
+func TestResource_Problems(t *testing.T) {
+	handle := &hybridsystem.HybridHandler3{Users: hybridsystem.NewMemoryUserStore()}
+	r := mux.NewRouter()
+	hybridsystem.UseProblems(r)
+	r.Use(hybridsystem.RequestID)
+	r.HandleFunc("/users", handle.CreateUserHandler3).Methods("POST")
+	r.HandleFunc("/users/{id}", handle.GetUserHandler3).Methods("GET")
+
+	tests := []struct {
+		name       string // description of this test case
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantType   string
+		wantDetail string
+	}{
+		{
+			name:       "malformed body",
+			method:     http.MethodPost,
+			path:       "/users",
+			body:       `{"name":`,
+			wantStatus: http.StatusBadRequest,
+			wantType:   hybridsystem.ProblemTypeMalformedBody,
+		},
+		{
+			name:       "invalid fields",
+			method:     http.MethodPost,
+			path:       "/users",
+			body:       `{"name":"","email":"someone@gmail.com"}`,
+			wantStatus: http.StatusBadRequest,
+			wantType:   hybridsystem.ProblemTypeValidation,
+		},
+		{
+			name:       "unknown user",
+			method:     http.MethodGet,
+			path:       "/users/42",
+			wantStatus: http.StatusNotFound,
+			wantType:   hybridsystem.ProblemTypeNotFound,
+			wantDetail: "user not found",
+		},
+		{
+			name:       "bad id",
+			method:     http.MethodGet,
+			path:       "/users/abc",
+			wantStatus: http.StatusBadRequest,
+			wantType:   hybridsystem.ProblemTypeInvalidID,
+		},
+		{
+			name:       "unknown route",
+			method:     http.MethodGet,
+			path:       "/nothing",
+			wantStatus: http.StatusNotFound,
+			wantType:   hybridsystem.ProblemTypeNotFound,
+		},
+		{
+			name:       "method not allowed",
+			method:     http.MethodDelete,
+			path:       "/users",
+			wantStatus: http.StatusMethodNotAllowed,
+			wantType:   "about:blank",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != hybridsystem.ProblemContentType {
+				t.Fatalf("expected %s, got %q", hybridsystem.ProblemContentType, ct)
+			}
+			var p hybridsystem.Problem
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatalf("failed to decode problem: %v", err)
+			}
+			if p.Type != tt.wantType || p.Status != tt.wantStatus || p.Instance != tt.path {
+				t.Errorf("unexpected problem %+v", p)
+			}
+			if tt.wantDetail != "" && p.Detail != tt.wantDetail {
+				t.Errorf("expected detail %q, got %q", tt.wantDetail, p.Detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,500 @@
+package hybridsystem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Resource serves the create/get/update/delete routes for one kind of
+// record. It only talks to Store, so the same handlers run on top of MySQL,
+// MongoDB or the in-memory store. Cache is optional; nil disables caching.
+// Logger receives unexpected errors; nil means slog.Default(). With Trash
+// set, DELETE moves records to the trash instead of deleting them, and the
+// trash is served at "<prefix>/trash" and "<prefix>/{id}/restore". With
+// History set, the audit log of a record is served at "<prefix>/{id}/history".
+// With Batch set, many records are written at once through "<prefix>:batch".
+//
+// Every store and cache call derives from the request's context, so a client
+// that goes away cancels the work done on its behalf. Timeouts adds
+// per-operation deadlines on top.
+type Resource[T any, P Entity[T]] struct {
+	Name     string
+	Store    UserStore[T]
+	Cache    *Cache[T]
+	Trash    TrashStore[T]
+	History  AuditLog
+	Batch    BatchStore[T]
+	Validate func(T) error
+	Logger   *slog.Logger
+	Timeouts TimeoutConfig
+}
+
+func (res *Resource[T, P]) readContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return withTimeout(r.Context(), res.Timeouts.DBRead)
+}
+
+func (res *Resource[T, P]) writeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return withTimeout(r.Context(), res.Timeouts.DBWrite)
+}
+
+// withTimeout is context.WithTimeout where d <= 0 means no deadline.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// afterWrite is the context for cache maintenance once a write has been
+// committed: it must not be skipped because the client left, or the cache
+// would keep serving the old record. Cache.Timeout still bounds it.
+func afterWrite(ctx context.Context) context.Context {
+	return context.WithoutCancel(ctx)
+}
+
+// Register makes a Resource a Module: it serves the collection at prefix
+// and every record below it, at "<prefix>/{id}", plus the trash, history and
+// batch routes when Trash, History and Batch are set.
+func (res *Resource[T, P]) Register(r *mux.Router, prefix string) {
+	r.HandleFunc(prefix, res.Create).Methods("POST")
+	r.HandleFunc(prefix, res.List).Methods("GET")
+	if res.Batch != nil {
+		r.HandleFunc(prefix+":batch", res.ApplyBatch).Methods("POST")
+	}
+	if res.Trash != nil {
+		// before "/{id}", which would take "trash" for an id
+		r.HandleFunc(prefix+"/trash", res.ListTrash).Methods("GET")
+		r.HandleFunc(prefix+"/{id}/restore", res.Restore).Methods("POST")
+	}
+	if res.History != nil {
+		r.HandleFunc(prefix+"/{id}/history", res.AuditHistory).Methods("GET")
+	}
+	r.HandleFunc(prefix+"/{id}", res.Get).Methods("GET")
+	r.HandleFunc(prefix+"/{id}", res.Update).Methods("PUT")
+	r.HandleFunc(prefix+"/{id}", res.Patch).Methods("PATCH")
+	r.HandleFunc(prefix+"/{id}", res.Delete).Methods("DELETE")
+}
+
+func (res *Resource[T, P]) Create(w http.ResponseWriter, r *http.Request) {
+	res.CreateWithStatus(w, r, http.StatusCreated)
+}
+
+// CreateWithStatus is Create answering with status instead of 201 Created,
+// for the legacy handlers whose clients have always been sent 200.
+func (res *Resource[T, P]) CreateWithStatus(w http.ResponseWriter, r *http.Request, status int) {
+	var v T
+	if !res.decode(w, r, &v) || !res.valid(w, r, v) {
+		return
+	}
+	ctx, cancel := res.writeContext(r)
+	defer cancel()
+	if err := res.Store.Create(ctx, &v); err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	res.logAttrs(r, P(&v).Key())
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	res.Cache.Put(afterWrite(ctx), P(&v).Key(), &v)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", ETag(P(&v).CurrentVersion()))
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+func (res *Resource[T, P]) Get(w http.ResponseWriter, r *http.Request) {
+	id := res.id(r)
+
+	ctx, cancel := res.readContext(r)
+	defer cancel()
+	v, outcome, err := res.Cache.Lookup(ctx, id, func(ctx context.Context) (*T, error) {
+		return res.Store.Get(ctx, id)
+	})
+	res.logAttrs(r, id, slog.String("cache", string(outcome)))
+	if err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	tag := ETag(P(v).CurrentVersion())
+	w.Header().Set("ETag", tag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && matchETag(inm, tag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		res.fail(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonData)
+}
+
+// List serves one page of records, see ParseListQuery for the parameters.
+func (res *Resource[T, P]) List(w http.ResponseWriter, r *http.Request) {
+	q, err := ParseListQuery(r)
+	if err != nil {
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, ProblemTypeBadQuery, err.Error()))
+		return
+	}
+	ctx, cancel := res.readContext(r)
+	defer cancel()
+	page, err := res.Store.List(ctx, q)
+	if err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func (res *Resource[T, P]) Update(w http.ResponseWriter, r *http.Request) {
+	id := res.id(r)
+	res.logAttrs(r, id)
+
+	var v T
+	if !res.decode(w, r, &v) || !res.valid(w, r, v) {
+		return
+	}
+	ctx, cancel := res.writeContext(r)
+	defer cancel()
+	// the version comes from If-Match, never from the body
+	expected, err := res.ifMatch(ctx, r, id)
+	if err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	P(&v).SetVersion(expected)
+	if err := res.Store.Update(ctx, id, &v); err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	res.written(ctx, w, r, id, &v)
+}
+
+// written answers a successful update with the record and its new ETag,
+// and caches it.
+func (res *Resource[T, P]) written(ctx context.Context, w http.ResponseWriter, r *http.Request, id string, v *T) {
+	jsonData, err := json.Marshal(v)
+	if err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	res.Cache.Put(afterWrite(ctx), id, v)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", ETag(P(v).CurrentVersion()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+// Patch applies a JSON Merge Patch (RFC 7396) or, sent as
+// application/json-patch+json, a JSON Patch (RFC 6902) to the stored record.
+// The result is validated like a PUT body and replaces the record and its
+// cache entry. Patches of one record are serialized through the cache's
+// Locker, and the write is conditional on the version the patch was applied
+// to, so concurrent ones do not undo each other's changes. If-Match is
+// checked against that version too.
+func (res *Resource[T, P]) Patch(w http.ResponseWriter, r *http.Request) {
+	id := res.id(r)
+	res.logAttrs(r, id)
+
+	apply, ok := patcher(r.Header.Get("Content-Type"))
+	if !ok {
+		w.Header().Set("Accept-Patch", MergePatchContentType+", "+JSONPatchContentType)
+		WriteProblem(w, r, NewProblem(http.StatusUnsupportedMediaType, ProblemTypeUnsupportedMediaType,
+			"send a "+MergePatchContentType+" or "+JSONPatchContentType+" document"))
+		return
+	}
+	patch, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, ProblemTypeMalformedBody, "cannot read the request body: "+err.Error()))
+		return
+	}
+	ctx, cancel := res.writeContext(r)
+	defer cancel()
+	release, err := res.Cache.Lock(ctx, id)
+	if err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	defer release()
+
+	current, err := res.Store.Get(ctx, id)
+	if err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	if im := r.Header.Get("If-Match"); im != "" && !matchETag(im, ETag(P(current).CurrentVersion()), false) {
+		res.fail(w, r, ErrVersionMismatch)
+		return
+	}
+	doc, err := json.Marshal(current)
+	if err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	merged, err := apply(doc, patch)
+	if err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	var v T
+	if err := json.Unmarshal(merged, &v); err != nil {
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, ProblemTypeMalformedBody, "the patched record is invalid: "+err.Error()))
+		return
+	}
+	if P(&v).Key() != P(current).Key() {
+		p := NewProblem(http.StatusBadRequest, ProblemTypeValidation, "the id of a record cannot be changed")
+		p.Errors = []FieldError{{Field: "id", Message: "cannot be changed"}}
+		WriteProblem(w, r, p)
+		return
+	}
+	if !res.valid(w, r, v) {
+		return
+	}
+	P(&v).SetVersion(P(current).CurrentVersion())
+	if err := res.Store.Update(ctx, id, &v); err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	res.written(ctx, w, r, id, &v)
+}
+
+// patcher picks the patch format from the request's Content-Type.
+func patcher(contentType string) (func(doc, patch []byte) ([]byte, error), bool) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case MergePatchContentType, "application/json":
+		return MergePatch, true
+	case JSONPatchContentType:
+		return JSONPatch, true
+	}
+	return nil, false
+}
+
+// Delete answers with the id of the deleted record, {"deleted": id}.
+func (res *Resource[T, P]) Delete(w http.ResponseWriter, r *http.Request) {
+	id, ok := res.remove(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"deleted": id})
+}
+
+// DeleteWithMessage is Delete answering with a plain text message, the one
+// the legacy handlers have always sent.
+func (res *Resource[T, P]) DeleteWithMessage(w http.ResponseWriter, r *http.Request, message string) {
+	if _, ok := res.remove(w, r); !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(message))
+}
+
+// remove deletes (or trashes) the record of r and drops it from the cache.
+// On failure it has answered r already and returns false.
+func (res *Resource[T, P]) remove(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id := res.id(r)
+	res.logAttrs(r, id)
+
+	ctx, cancel := res.writeContext(r)
+	defer cancel()
+	expected, err := res.ifMatch(ctx, r, id)
+	if err != nil {
+		res.fail(w, r, err)
+		return "", false
+	}
+	if res.Trash != nil {
+		err = res.Trash.SoftDelete(ctx, id, expected)
+	} else {
+		err = res.Store.DeleteVersion(ctx, id, expected)
+	}
+	if err != nil {
+		res.fail(w, r, err)
+		return "", false
+	}
+	res.Cache.Invalidate(afterWrite(ctx), id)
+	return id, true
+}
+
+// ListTrash serves one page of trashed records, with the parameters of List.
+func (res *Resource[T, P]) ListTrash(w http.ResponseWriter, r *http.Request) {
+	q, err := ParseListQuery(r)
+	if err != nil {
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, ProblemTypeBadQuery, err.Error()))
+		return
+	}
+	ctx, cancel := res.readContext(r)
+	defer cancel()
+	page, err := res.Trash.Trash(ctx, q)
+	if err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// Restore takes a record out of the trash and answers with it like an
+// update. A record that is not in the trash is not found.
+func (res *Resource[T, P]) Restore(w http.ResponseWriter, r *http.Request) {
+	id := res.id(r)
+	res.logAttrs(r, id)
+
+	ctx, cancel := res.writeContext(r)
+	defer cancel()
+	v, err := res.Trash.Restore(ctx, id)
+	if err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	res.written(ctx, w, r, id, v)
+}
+
+// AuditHistory serves the audit log of a record, oldest entry first. It
+// outlives the record, so deleted records have a history too.
+func (res *Resource[T, P]) AuditHistory(w http.ResponseWriter, r *http.Request) {
+	id := res.id(r)
+	res.logAttrs(r, id)
+
+	if res.History == nil {
+		res.fail(w, r, ErrNotFound)
+		return
+	}
+	ctx, cancel := res.readContext(r)
+	defer cancel()
+	entries, err := res.History.History(ctx, id)
+	if err != nil {
+		res.fail(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Page[AuditEntry]{Items: entries})
+}
+
+// id returns the id of the record r is for, spelled as the store keys it, so
+// that "/users/007" and "/users/7" share a cache entry. An invalid id is
+// returned as it is for the store to reject.
+func (res *Resource[T, P]) id(r *http.Request) string {
+	id := mux.Vars(r)["id"]
+	if key, err := canonicalKey[T, P](id); err == nil {
+		return key
+	}
+	return id
+}
+
+// ifMatch returns the version the If-Match header of r requires the record
+// to be at, 0 if there is no header. A header naming several ETags, or "*",
+// is resolved against the stored record. The stores check the version as
+// part of the write, so a concurrent change is still caught.
+func (res *Resource[T, P]) ifMatch(ctx context.Context, r *http.Request, id string) (int64, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, nil
+	}
+	if !strings.Contains(header, ",") && strings.TrimSpace(header) != "*" {
+		version, ok := parseETag(strings.TrimSpace(header))
+		if !ok {
+			return 0, ErrVersionMismatch
+		}
+		if version != 0 {
+			return version, nil
+		}
+		// records written before versions existed are at 0, which
+		// stores read as "unconditional"; compare with the stored one
+	}
+	current, err := res.Store.Get(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if !matchETag(header, ETag(P(current).CurrentVersion()), false) {
+		return 0, ErrVersionMismatch
+	}
+	return P(current).CurrentVersion(), nil
+}
+
+func (res *Resource[T, P]) decode(w http.ResponseWriter, r *http.Request, v *T) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		WriteProblem(w, r, NewProblem(http.StatusBadRequest, ProblemTypeMalformedBody, "request body is not valid JSON: "+err.Error()))
+		return false
+	}
+	return true
+}
+
+func (res *Resource[T, P]) valid(w http.ResponseWriter, r *http.Request, v T) bool {
+	if p := res.invalid(v); p != nil {
+		WriteProblem(w, r, p)
+		return false
+	}
+	return true
+}
+
+// invalid returns the problem with v, nil if it passes Validate.
+func (res *Resource[T, P]) invalid(v T) *Problem {
+	if res.Validate == nil {
+		return nil
+	}
+	err := res.Validate(v)
+	if err == nil {
+		return nil
+	}
+	p := ProblemFor(err)
+	if p.Status == http.StatusInternalServerError {
+		// a validator that does not return a ValidationError
+		p = NewProblem(http.StatusBadRequest, ProblemTypeValidation, err.Error())
+	}
+	return p
+}
+
+// logAttrs adds the entity and its id to the access log line of r.
+func (res *Resource[T, P]) logAttrs(r *http.Request, id string, attrs ...slog.Attr) {
+	AddLogAttrs(r.Context(), append([]slog.Attr{slog.String("entity", res.Name), slog.String("entity_id", id)}, attrs...)...)
+}
+
+// statusClientClosedRequest is the nginx convention for a request the
+// client abandoned before it was answered.
+const statusClientClosedRequest = 499
+
+// fail answers with the problem for err. Unexpected errors are logged since
+// the client only sees a generic message.
+func (res *Resource[T, P]) fail(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+		// nobody is listening; the status is only for logs and metrics
+		w.WriteHeader(statusClientClosedRequest)
+		return
+	}
+	WriteProblem(w, r, res.problem(r, err))
+}
+
+// problem is ProblemFor err, naming the record in a not found problem and
+// logging unexpected errors.
+func (res *Resource[T, P]) problem(r *http.Request, err error) *Problem {
+	p := ProblemFor(err)
+	switch {
+	case p.Status == http.StatusNotFound && p.Detail == "":
+		p.Detail = res.Name + " not found"
+	case p.Status == http.StatusGatewayTimeout:
+		RequestLogger(res.Logger, r).Warn(res.Name+" store timed out", "error", err)
+	case p.Status >= http.StatusInternalServerError:
+		RequestLogger(res.Logger, r).Error(res.Name+" store failed", "error", err)
+	}
+	return p
+}
@@ -0,0 +1,209 @@
+package hybridsystem_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// These tests run the HTTP layer on top of the in-memory stores, so they
+// need neither MySQL, MongoDB nor Redis.
+func TestHybridHandler3_MemoryUsers(t *testing.T) {
+	handle := &hybridsystem.HybridHandler3{
+		Users:   hybridsystem.NewMemoryUserStore(),
+		Persons: hybridsystem.NewMemoryPersonStore(),
+	}
+
+	tests := []struct {
+		name     string // description of this test case
+		user     hybridsystem.User2
+		willpass bool
+	}{
+		{
+			name:     "valid name and valid email",
+			user:     hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com"},
+			willpass: true,
+		},
+		{
+			name:     "invalid name and valid email",
+			user:     hybridsystem.User2{Name: "", Email: "akash@gmail.com"},
+			willpass: false,
+		},
+		{
+			name:     "valid name and email without prefix",
+			user:     hybridsystem.User2{Name: "Akash", Email: "@gmail.com"},
+			willpass: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userBytes, _ := json.Marshal(tt.user)
+			r := httptest.NewRequest(http.MethodPost, "/users", bytes.NewBuffer(userBytes))
+			w := httptest.NewRecorder()
+
+			handle.CreateUserHandler3(w, r)
+
+			if !tt.willpass {
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("expected bad request, got %d", w.Code)
+				}
+				return
+			}
+			if w.Code != http.StatusCreated {
+				t.Fatalf("expected status created, got %d", w.Code)
+			}
+			var created hybridsystem.User2
+			if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+
+			id := created.Key()
+			r = httptest.NewRequest(http.MethodGet, "/users/"+id, nil)
+			r = mux.SetURLVars(r, map[string]string{"id": id})
+			w = httptest.NewRecorder()
+			handle.GetUserHandler3(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status ok, got %d", w.Code)
+			}
+
+			update, _ := json.Marshal(hybridsystem.User2{Name: "Akash paul", Email: "akashpaul@gmail.com"})
+			r = httptest.NewRequest(http.MethodPut, "/users/"+id, bytes.NewBuffer(update))
+			r = mux.SetURLVars(r, map[string]string{"id": id})
+			w = httptest.NewRecorder()
+			handle.UpdateUserHandler3(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected status ok on update, got %d", w.Code)
+			}
+
+			r = httptest.NewRequest(http.MethodDelete, "/users/"+id, nil)
+			r = mux.SetURLVars(r, map[string]string{"id": id})
+			w = httptest.NewRecorder()
+			handle.DeleteUserHandler3(w, r)
+			if w.Code != http.StatusOK || w.Body.String() != "user deleted" {
+				t.Fatalf("expected 'user deleted', got %d %s", w.Code, w.Body.String())
+			}
+
+			r = httptest.NewRequest(http.MethodGet, "/users/"+id, nil)
+			r = mux.SetURLVars(r, map[string]string{"id": id})
+			w = httptest.NewRecorder()
+			handle.GetUserHandler3(w, r)
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("expected not found after delete, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestHybridHandler3_MemoryPersons(t *testing.T) {
+	handle := &hybridsystem.HybridHandler3{Persons: hybridsystem.NewMemoryPersonStore()}
+
+	tests := []struct {
+		name     string // description of this test case
+		id       string
+		wantCode int
+	}{
+		{
+			name:     "invalid id format",
+			id:       "5347",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "nonexistent person",
+			id:       "65a000000000000000000000",
+			wantCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/persons/"+tt.id, nil)
+			r = mux.SetURLVars(r, map[string]string{"id": tt.id})
+			w := httptest.NewRecorder()
+
+			handle.GetUserHandler4(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected status %d, got %d", tt.wantCode, w.Code)
+			}
+		})
+	}
+}
+
+func TestResource_CanonicalIDs(t *testing.T) {
+	tests := []struct {
+		name  string // description of this test case
+		read  string
+		write string
+	}{
+		{name: "leading zeros", read: "/users/007", write: "/users/7"},
+		{name: "plus sign", read: "/users/+7", write: "/users/7"},
+		{name: "written with leading zeros", read: "/users/7", write: "/users/007"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := hybridsystem.NewMemoryUserStore()
+			for i := range 7 {
+				store.Create(context.Background(), &hybridsystem.User2{Name: "Akash", Email: fmt.Sprintf("akash%d@gmail.com", i)})
+			}
+			cache := hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)
+			res := &hybridsystem.Resource[hybridsystem.User2, *hybridsystem.User2]{Name: "user", Store: store, Cache: cache}
+			router := mux.NewRouter()
+			res.Register(router, "/users")
+			send := func(method, path, body string) *httptest.ResponseRecorder {
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+				return w
+			}
+
+			if w := send(http.MethodGet, tt.read, ""); w.Code != http.StatusOK {
+				t.Fatalf("expected user 7, got %d: %s", w.Code, w.Body)
+			}
+			if w := send(http.MethodPut, tt.write, `{"name":"Paul","email":"akash6@gmail.com"}`); w.Code != http.StatusOK {
+				t.Fatalf("expected the update to succeed, got %d: %s", w.Code, w.Body)
+			}
+			var user hybridsystem.User2
+			json.NewDecoder(send(http.MethodGet, tt.read, "").Body).Decode(&user)
+			if user.ID != 7 || user.Name != "Paul" {
+				t.Errorf("expected the updated user through %s, got %+v", tt.read, user)
+			}
+			if w := send(http.MethodDelete, tt.write, ""); w.Code != http.StatusOK {
+				t.Fatalf("expected the delete to succeed, got %d: %s", w.Code, w.Body)
+			}
+			if w := send(http.MethodGet, tt.read, ""); w.Code != http.StatusNotFound {
+				t.Errorf("expected the deleted user gone through %s, got %d", tt.read, w.Code)
+			}
+		})
+	}
+
+	t.Run("object id case", func(t *testing.T) {
+		store := hybridsystem.NewMemoryPersonStore()
+		person := &hybridsystem.Person{Name: "Akash", Email: "akash@gmail.com"}
+		store.Create(context.Background(), person)
+		cache := hybridsystem.NewCache[hybridsystem.Person](hybridsystem.NewMemoryBackend(), hybridsystem.PersonKeys, time.Minute)
+		res := &hybridsystem.Resource[hybridsystem.Person, *hybridsystem.Person]{Name: "person", Store: store, Cache: cache}
+		router := mux.NewRouter()
+		res.Register(router, "/persons")
+		upper := "/persons/" + strings.ToUpper(person.ID.Hex())
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, upper, nil))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/persons/"+person.ID.Hex(), strings.NewReader(`{"name":"Paul","email":"akash@gmail.com"}`)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected the update to succeed, got %d: %s", w.Code, w.Body)
+		}
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, upper, nil))
+		var got hybridsystem.Person
+		if json.NewDecoder(w.Body).Decode(&got); got.Name != "Paul" {
+			t.Errorf("expected the updated person through %s, got %d: %+v", upper, w.Code, got)
+		}
+	})
+}
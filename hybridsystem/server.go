@@ -0,0 +1,116 @@
+package hybridsystem
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// ServerConfig holds the HTTP server settings shared by every entry point.
+type ServerConfig struct {
+	Addr            string        `yaml:"addr"`
+	ReadTimeout     time.Duration `yaml:"read_timeout"`
+	WriteTimeout    time.Duration `yaml:"write_timeout"`
+	IdleTimeout     time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Addr:            ":8080",
+		ReadTimeout:     5 * time.Second,
+		WriteTimeout:    10 * time.Second,
+		IdleTimeout:     60 * time.Second,
+		ShutdownTimeout: 15 * time.Second,
+	}
+}
+
+// Server runs an http.Handler until it is told to stop, lets in-flight
+// requests finish and then closes Closers in order (typically Redis, MySQL
+// and Mongo clients).
+type Server struct {
+	Config  ServerConfig
+	Handler http.Handler
+	Closers []io.Closer
+	Logger  *slog.Logger
+}
+
+// Run listens on Config.Addr and serves until SIGINT or SIGTERM.
+func (s *Server) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ln, err := net.Listen("tcp", s.Config.Addr)
+	if err != nil {
+		s.close()
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve serves on ln until ctx is done, then shuts down gracefully.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{
+		Handler:      s.Handler,
+		ReadTimeout:  s.Config.ReadTimeout,
+		WriteTimeout: s.Config.WriteTimeout,
+		IdleTimeout:  s.Config.IdleTimeout,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		s.logger().Info("server running", "addr", ln.Addr().String())
+		serveErr <- srv.Serve(ln)
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		s.logger().Info("shutting down, draining in-flight requests")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), durationOr(s.Config.ShutdownTimeout, 15*time.Second))
+		defer cancel()
+		err = srv.Shutdown(shutdownCtx)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return errors.Join(err, s.close())
+}
+
+func (s *Server) logger() *slog.Logger {
+	if s.Logger == nil {
+		return slog.Default()
+	}
+	return s.Logger
+}
+
+func (s *Server) close() error {
+	var errs []error
+	for _, c := range s.Closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *RedisInstance1) Close() error {
+	return r.Client.Close()
+}
+
+func (m *MySQLInstance1) Close() error {
+	return m.DB.Close()
+}
+
+func (m *MongoInstance1) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return m.Client.Disconnect(ctx)
+}
@@ -0,0 +1,85 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"redisDatabase/hybridsystem"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingCloser appends its name to a shared log when closed.
+type recordingCloser struct {
+	name string
+	mu   *sync.Mutex
+	log  *[]string
+}
+
+func (c recordingCloser) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	*c.log = append(*c.log, c.name)
+	return nil
+}
+
+func TestServer_DrainsAndClosesInOrder(t *testing.T) {
+	var mu sync.Mutex
+	var closed []string
+	closer := func(name string) io.Closer { return recordingCloser{name: name, mu: &mu, log: &closed} }
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &hybridsystem.Server{
+		Config:  hybridsystem.DefaultServerConfig(),
+		Handler: handler,
+		Closers: []io.Closer{closer("redis"), closer("mysql"), closer("mongo")},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, ln) }()
+
+	response := make(chan string, 1)
+	go func() {
+		res, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			response <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		response <- string(body)
+	}()
+
+	<-started
+	cancel()
+
+	if got := <-response; got != "done" {
+		t.Fatalf("in-flight request was not drained, got %q", got)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("serve returned %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"redis", "mysql", "mongo"}
+	if len(closed) != len(want) {
+		t.Fatalf("expected closers %v, got %v", want, closed)
+	}
+	for i := range want {
+		if closed[i] != want[i] {
+			t.Fatalf("expected closers %v, got %v", want, closed)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package hybridsystem
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Locker is an optional capability of a CacheBackend used to make sure only
+// one replica recomputes a missing entry. Lock reports acquired=false when
+// another holder owns key; release must be called once the work is done.
+type Locker interface {
+	Lock(ctx context.Context, key string, ttl time.Duration) (release func(), acquired bool, err error)
+}
+
+const (
+	defaultLockTTL  = 5 * time.Second
+	defaultLockWait = 2 * time.Second
+	lockPollEvery   = 20 * time.Millisecond
+)
+
+// unlockScript deletes the lock only if it still holds our token, so a
+// holder whose lock expired cannot release somebody else's.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func (b *RedisBackend) Lock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	token := newLockToken()
+	ok, err := b.Client.SetNX(ctx, key, token, ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return func() {
+		unlockScript.Run(context.Background(), b.Client, []string{key}, token)
+	}, true, nil
+}
+
+func (b *MemoryBackend) Lock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if item, ok := b.items[key]; ok && (item.expires.IsZero() || time.Now().Before(item.expires)) {
+		return nil, false, nil
+	}
+	token := newLockToken()
+	b.items[key] = memoryItem{value: []byte(token), expires: time.Now().Add(ttl)}
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if item, ok := b.items[key]; ok && string(item.value) == token {
+			delete(b.items, key)
+		}
+	}, true, nil
+}
+
+// ErrRecordBusy is returned by Cache.Lock when another writer held the
+// record for longer than LockWait.
+var ErrRecordBusy = errors.New("the record is being modified by another request")
+
+// Lock serializes read-modify-write cycles on id, such as a PATCH, across
+// replicas. Without a Locker (or a cache) it does nothing. It waits up to
+// LockWait for the current holder; release must be called when done.
+func (c *Cache[T]) Lock(ctx context.Context, id string) (release func(), err error) {
+	if c == nil || c.Locker == nil {
+		return func() {}, nil
+	}
+	key := c.key(id) + ":write"
+	deadline := time.Now().Add(durationOr(c.LockWait, defaultLockWait))
+	for {
+		lockCtx, cancel := c.backendContext(ctx)
+		release, acquired, err := c.Locker.Lock(lockCtx, key, durationOr(c.LockTTL, defaultLockTTL))
+		cancel()
+		switch {
+		case err != nil:
+			// the cache being down must not block writes
+			c.errors.Add(1)
+			return func() {}, nil
+		case acquired:
+			return release, nil
+		case time.Now().After(deadline):
+			return nil, ErrRecordBusy
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollEvery):
+		}
+	}
+}
+
+func newLockToken() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// load is called once per key and process for a miss (see Fetch). Without a
+// Locker it simply runs the loader. With one, the replica that wins the lock
+// loads while the others poll the cache for up to LockWait, then fall back to
+// the stale copy and, failing that, load themselves.
+func (c *Cache[T]) load(ctx context.Context, id, key string, load func(ctx context.Context) (*T, error)) (loaded[T], error) {
+	if c.Locker != nil {
+		lockCtx, cancel := c.backendContext(ctx)
+		release, acquired, err := c.Locker.Lock(lockCtx, key+":lock", durationOr(c.LockTTL, defaultLockTTL))
+		cancel()
+		switch {
+		case err != nil:
+			c.errors.Add(1)
+		case acquired:
+			defer release()
+		default:
+			if v, err := c.waitFor(ctx, key, durationOr(c.LockWait, defaultLockWait)); !errors.Is(err, ErrCacheMiss) {
+				return loaded[T]{v: v, outcome: CacheMiss}, err
+			}
+			if v, err := c.get(ctx, key+":stale"); err == nil {
+				c.stale.Add(1)
+				return loaded[T]{v: v, outcome: CacheStale}, nil
+			}
+		}
+	}
+	v, err := load(ctx)
+	if errors.Is(err, ErrNotFound) {
+		c.putNotFound(ctx, key)
+	}
+	if err != nil {
+		return loaded[T]{}, err
+	}
+	c.Put(ctx, id, v)
+	return loaded[T]{v: v, outcome: CacheMiss}, nil
+}
+
+// call is one load shared by every concurrent miss for a key.
+type call[T any] struct {
+	done    chan struct{}
+	result  loaded[T]
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// coalesce runs fn once per key for all callers that arrive while it is in
+// flight. The load is not tied to the request that happened to start it:
+// it keeps running while at least one caller still waits, and is cancelled
+// once all of them have given up. It still inherits the first caller's
+// deadline, so a hung dependency cannot keep it alive forever.
+func (c *Cache[T]) coalesce(ctx context.Context, key string, fn func(ctx context.Context) (loaded[T], error)) (loaded[T], error) {
+	c.mu.Lock()
+	cl, ok := c.calls[key]
+	if !ok {
+		loadCtx, cancelLoad := context.WithCancel(context.WithoutCancel(ctx))
+		cancel := cancelLoad
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancelDeadline context.CancelFunc
+			loadCtx, cancelDeadline = context.WithDeadline(loadCtx, deadline)
+			cancel = func() { cancelDeadline(); cancelLoad() }
+		}
+		cl = &call[T]{done: make(chan struct{}), cancel: cancel}
+		if c.calls == nil {
+			c.calls = make(map[string]*call[T])
+		}
+		c.calls[key] = cl
+		go func() {
+			defer cancel()
+			cl.result, cl.err = fn(loadCtx)
+			c.mu.Lock()
+			if c.calls[key] == cl {
+				delete(c.calls, key)
+			}
+			c.mu.Unlock()
+			close(cl.done)
+		}()
+	}
+	cl.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-cl.done:
+		return cl.result, cl.err
+	case <-ctx.Done():
+		c.mu.Lock()
+		cl.waiters--
+		if cl.waiters == 0 {
+			// Nobody is left to use the result: stop the load, and let
+			// the next caller start a fresh one.
+			cl.cancel()
+			if c.calls[key] == cl {
+				delete(c.calls, key)
+			}
+		}
+		c.mu.Unlock()
+		return loaded[T]{}, ctx.Err()
+	}
+}
+
+// waitFor polls the backend until key shows up, wait elapses or ctx ends.
+// It returns ErrCacheMiss if nothing showed up.
+func (c *Cache[T]) waitFor(ctx context.Context, key string, wait time.Duration) (*T, error) {
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, ErrCacheMiss
+		case <-time.After(lockPollEvery):
+		}
+		if v, err := c.get(ctx, key); !errors.Is(err, ErrCacheMiss) {
+			return v, err
+		}
+	}
+	return nil, ErrCacheMiss
+}
+
+func durationOr(d, fallback time.Duration) time.Duration {
+	if d <= 0 {
+		return fallback
+	}
+	return d
+}
@@ -0,0 +1,111 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"redisDatabase/hybridsystem"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowLoader stands in for a database query that takes a while, counting
+// how many times it actually ran.
+func slowLoader(calls *atomic.Int64) func(ctx context.Context) (*hybridsystem.User2, error) {
+	return func(ctx context.Context) (*hybridsystem.User2, error) {
+		calls.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		return &hybridsystem.User2{ID: 1, Name: "Akash", Email: "akash@gmail.com"}, nil
+	}
+}
+
+func TestCache_CoalescesConcurrentMisses(t *testing.T) {
+	cache := hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)
+
+	var calls atomic.Int64
+	load := slowLoader(&calls)
+
+	const n = 50
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			user, err := cache.Fetch(context.Background(), "1", load)
+			if err != nil || user.Name != "Akash" {
+				t.Errorf("unexpected result %v, %v", user, err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected exactly one database query for %d parallel misses, got %d", n, got)
+	}
+}
+
+// Two caches sharing one backend behave like two replicas sharing Redis:
+// the lock makes sure only one of them queries the database.
+func TestCache_LockAcrossReplicas(t *testing.T) {
+	backend := hybridsystem.NewMemoryBackend()
+	replica := func() *hybridsystem.Cache[hybridsystem.User2] {
+		c := hybridsystem.NewCache[hybridsystem.User2](backend, hybridsystem.UserKeys, time.Minute)
+		c.Locker = backend
+		c.LockWait = time.Second
+		return c
+	}
+	a, b := replica(), replica()
+
+	var calls atomic.Int64
+	load := slowLoader(&calls)
+
+	var wg sync.WaitGroup
+	for _, c := range []*hybridsystem.Cache[hybridsystem.User2]{a, b} {
+		wg.Add(1)
+		go func(c *hybridsystem.Cache[hybridsystem.User2]) {
+			defer wg.Done()
+			if _, err := c.Fetch(context.Background(), "1", load); err != nil {
+				t.Errorf("fetch failed: %v", err)
+			}
+		}(c)
+	}
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("expected one database query across replicas, got %d", got)
+	}
+}
+
+func TestCache_ServesStaleWhileLocked(t *testing.T) {
+	ctx := context.Background()
+	backend := hybridsystem.NewMemoryBackend()
+	cache := hybridsystem.NewCache[hybridsystem.User2](backend, hybridsystem.UserKeys, 20*time.Millisecond)
+	cache.Locker = backend
+	cache.LockWait = 30 * time.Millisecond
+	cache.StaleTTL = time.Minute
+
+	cache.Put(ctx, "1", &hybridsystem.User2{ID: 1, Name: "old"})
+	time.Sleep(30 * time.Millisecond)
+
+	// another replica is busy reloading the entry
+	release, acquired, _ := backend.Lock(ctx, hybridsystem.UserKeys.Key("1")+":lock", time.Minute)
+	if !acquired {
+		t.Fatal("expected to take the lock")
+	}
+	defer release()
+
+	var calls atomic.Int64
+	user, err := cache.Fetch(ctx, "1", slowLoader(&calls))
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if user.Name != "old" || calls.Load() != 0 {
+		t.Fatalf("expected the stale copy without a query, got %q after %d queries", user.Name, calls.Load())
+	}
+	if cache.Stats().Stale != 1 {
+		t.Errorf("expected one stale read, got %+v", cache.Stats())
+	}
+}
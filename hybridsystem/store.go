@@ -0,0 +1,540 @@
+package hybridsystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrNotFound  = errors.New("record not found")
+	ErrInvalidID = errors.New("invalid id format")
+	// ErrVersionMismatch is returned by a conditional write when the record
+	// has been changed since the version the caller expected.
+	ErrVersionMismatch = errors.New("record has been modified")
+)
+
+// Entity is the constraint every stored record satisfies. Key is the id as it
+// appears in URLs and cache keys, SetKey parses it back into the record and
+// Field returns a sortable or filterable field such as "name" or "email".
+// Stores call Normalize before every write. CurrentVersion and SetVersion
+// access the version the stores maintain, Timestamps and SetTimestamps its
+// creation and last write times, Deleted and SetDeleted the time the record
+// was moved to the trash.
+type Entity[T any] interface {
+	*T
+	Key() string
+	SetKey(id string) error
+	Field(name string) string
+	Normalize()
+	CurrentVersion() int64
+	SetVersion(version int64)
+	Timestamps() (created, updated time.Time)
+	SetTimestamps(created, updated time.Time)
+	Deleted() *time.Time
+	SetDeleted(at *time.Time)
+}
+
+// UserStore hides which database a record lives in so the handlers can be
+// written once and the backend picked when the server is wired up.
+//
+// Records are versioned: Create stores version 1 and every Update adds one,
+// setting the new version in v. When v already carries a version, Update only
+// succeeds if the stored record still has it and fails with
+// ErrVersionMismatch otherwise; DeleteVersion is the same check for Delete.
+type UserStore[T any] interface {
+	Create(ctx context.Context, v *T) error
+	Get(ctx context.Context, id string) (*T, error)
+	Update(ctx context.Context, id string, v *T) error
+	Delete(ctx context.Context, id string) error
+	DeleteVersion(ctx context.Context, id string, version int64) error
+	List(ctx context.Context, q ListQuery) (Page[T], error)
+}
+
+// TrashStore is implemented by stores that can soft-delete. SoftDelete moves
+// a record to the trash (with the version check of DeleteVersion) and bumps
+// its version; from then on the UserStore methods treat it as absent, but
+// its email stays taken. Trash lists the trashed records, Restore takes one
+// out of the trash and Purge deletes for good those trashed before a time.
+type TrashStore[T any] interface {
+	SoftDelete(ctx context.Context, id string, version int64) error
+	Trash(ctx context.Context, q ListQuery) (Page[T], error)
+	Restore(ctx context.Context, id string) (*T, error)
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+func (u *User2) Key() string {
+	return strconv.Itoa(u.ID)
+}
+
+func (u *User2) SetKey(id string) error {
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+	u.ID = n
+	return nil
+}
+
+func (u *User2) Field(name string) string {
+	switch name {
+	case "name":
+		return u.Name
+	case "email":
+		return u.Email
+	case "created_at":
+		return timeKey(u.CreatedAt)
+	case "updated_at":
+		return timeKey(u.UpdatedAt)
+	}
+	return ""
+}
+
+func (u *User2) Normalize() {
+	u.Email = NormalizeEmail(u.Email)
+}
+
+func (u *User2) CurrentVersion() int64 {
+	return u.Version
+}
+
+func (u *User2) SetVersion(version int64) {
+	u.Version = version
+}
+
+func (u *User2) Timestamps() (created, updated time.Time) {
+	return u.CreatedAt, u.UpdatedAt
+}
+
+func (u *User2) SetTimestamps(created, updated time.Time) {
+	u.CreatedAt, u.UpdatedAt = created, updated
+}
+
+func (u *User2) Deleted() *time.Time {
+	return u.DeletedAt
+}
+
+func (u *User2) SetDeleted(at *time.Time) {
+	u.DeletedAt = at
+}
+
+func (p *Person) Key() string {
+	return p.ID.Hex()
+}
+
+func (p *Person) SetKey(id string) error {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrInvalidID
+	}
+	p.ID = objID
+	return nil
+}
+
+func (p *Person) Field(name string) string {
+	switch name {
+	case "name":
+		return p.Name
+	case "email":
+		return p.Email
+	case "created_at":
+		return timeKey(p.CreatedAt)
+	case "updated_at":
+		return timeKey(p.UpdatedAt)
+	}
+	return ""
+}
+
+func (p *Person) Normalize() {
+	p.Email = NormalizeEmail(p.Email)
+}
+
+func (p *Person) CurrentVersion() int64 {
+	return p.Version
+}
+
+func (p *Person) SetVersion(version int64) {
+	p.Version = version
+}
+
+func (p *Person) Timestamps() (created, updated time.Time) {
+	return p.CreatedAt, p.UpdatedAt
+}
+
+func (p *Person) SetTimestamps(created, updated time.Time) {
+	p.CreatedAt, p.UpdatedAt = created, updated
+}
+
+func (p *Person) Deleted() *time.Time {
+	return p.DeletedAt
+}
+
+func (p *Person) SetDeleted(at *time.Time) {
+	p.DeletedAt = at
+}
+
+// canonicalKey parses id like SetKey and returns it as Key spells it, so that
+// every spelling of an id, such as "007" for 7, names the same record.
+func canonicalKey[T any, P Entity[T]](id string) (string, error) {
+	v := P(new(T))
+	if err := v.SetKey(id); err != nil {
+		return "", err
+	}
+	return v.Key(), nil
+}
+
+// stamp is the time of a write. It is kept to the millisecond, the precision
+// of BSON dates, so a record reads back the same from every store and the
+// cache.
+func stamp() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// MemoryStore keeps records in a map. It is meant for tests and for running
+// the HTTP layer without MySQL or MongoDB. Like the database stores it allows
+// each email only once and keeps an audit log.
+type MemoryStore[T any, P Entity[T]] struct {
+	mu      sync.RWMutex
+	records map[string]T
+	order   []string
+	audit   []AuditEntry
+	newKey  func() string
+}
+
+func NewMemoryStore[T any, P Entity[T]](newKey func() string) *MemoryStore[T, P] {
+	return &MemoryStore[T, P]{records: make(map[string]T), newKey: newKey}
+}
+
+// NewMemoryUserStore hands out sequential integer ids like AUTO_INCREMENT.
+func NewMemoryUserStore() *MemoryStore[User2, *User2] {
+	next := 0
+	return NewMemoryStore[User2](func() string {
+		next++
+		return strconv.Itoa(next)
+	})
+}
+
+// NewMemoryPersonStore hands out ObjectIDs like MongoDB.
+func NewMemoryPersonStore() *MemoryStore[Person, *Person] {
+	return NewMemoryStore[Person](func() string {
+		return primitive.NewObjectID().Hex()
+	})
+}
+
+func (m *MemoryStore[T, P]) Create(ctx context.Context, v *T) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.create(ctx, v)
+}
+
+// create, update, deleteVersion and softDelete are the writes of the exported
+// methods and Batch; the caller holds the lock.
+func (m *MemoryStore[T, P]) create(ctx context.Context, v *T) error {
+	P(v).Normalize()
+	if err := m.checkUnique(v, ""); err != nil {
+		return err
+	}
+	if err := P(v).SetKey(m.newKey()); err != nil {
+		return err
+	}
+	P(v).SetVersion(1)
+	now := stamp()
+	P(v).SetTimestamps(now, now)
+	P(v).SetDeleted(nil)
+	id := P(v).Key()
+	m.records[id] = *v
+	m.order = append(m.order, id)
+	m.audit = append(m.audit, newAuditEntry[T, P](ctx, AuditCreate, nil, v))
+	return nil
+}
+
+func (m *MemoryStore[T, P]) Get(ctx context.Context, id string) (*T, error) {
+	id, err := canonicalKey[T, P](id)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	v, ok := m.records[id]
+	if !ok || P(&v).Deleted() != nil {
+		return nil, ErrNotFound
+	}
+	return &v, nil
+}
+
+func (m *MemoryStore[T, P]) Update(ctx context.Context, id string, v *T) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.update(ctx, id, v)
+}
+
+func (m *MemoryStore[T, P]) update(ctx context.Context, id string, v *T) error {
+	if err := P(v).SetKey(id); err != nil {
+		return err
+	}
+	id = P(v).Key()
+	P(v).Normalize()
+	current, ok := m.records[id]
+	if !ok || P(&current).Deleted() != nil {
+		return ErrNotFound
+	}
+	if expected := P(v).CurrentVersion(); expected != 0 && expected != P(&current).CurrentVersion() {
+		return ErrVersionMismatch
+	}
+	if err := m.checkUnique(v, id); err != nil {
+		return err
+	}
+	P(v).SetVersion(P(&current).CurrentVersion() + 1)
+	created, _ := P(&current).Timestamps()
+	P(v).SetTimestamps(created, stamp())
+	P(v).SetDeleted(nil)
+	m.records[id] = *v
+	m.audit = append(m.audit, newAuditEntry[T, P](ctx, AuditUpdate, &current, v))
+	return nil
+}
+
+// checkUnique fails if a record other than id already has the email of v.
+func (m *MemoryStore[T, P]) checkUnique(v *T, id string) error {
+	email := P(v).Field("email")
+	if email == "" {
+		return nil
+	}
+	for key, other := range m.records {
+		if key != id && P(&other).Field("email") == email {
+			return &DuplicateError{Field: "email", Value: email, ExistingID: key}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore[T, P]) Delete(ctx context.Context, id string) error {
+	return m.DeleteVersion(ctx, id, 0)
+}
+
+func (m *MemoryStore[T, P]) DeleteVersion(ctx context.Context, id string, version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.deleteVersion(ctx, id, version)
+}
+
+func (m *MemoryStore[T, P]) deleteVersion(ctx context.Context, id string, version int64) error {
+	id, err := canonicalKey[T, P](id)
+	if err != nil {
+		return err
+	}
+	current, ok := m.records[id]
+	if !ok || P(&current).Deleted() != nil {
+		return ErrNotFound
+	}
+	if version != 0 && version != P(&current).CurrentVersion() {
+		return ErrVersionMismatch
+	}
+	m.remove(id)
+	m.audit = append(m.audit, newAuditEntry[T, P](ctx, AuditDelete, &current, nil))
+	return nil
+}
+
+// remove drops id; the caller holds the lock.
+func (m *MemoryStore[T, P]) remove(id string) {
+	delete(m.records, id)
+	for i, k := range m.order {
+		if k == id {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (m *MemoryStore[T, P]) SoftDelete(ctx context.Context, id string, version int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.softDelete(ctx, id, version)
+}
+
+func (m *MemoryStore[T, P]) softDelete(ctx context.Context, id string, version int64) error {
+	id, err := canonicalKey[T, P](id)
+	if err != nil {
+		return err
+	}
+	current, ok := m.records[id]
+	if !ok || P(&current).Deleted() != nil {
+		return ErrNotFound
+	}
+	if version != 0 && version != P(&current).CurrentVersion() {
+		return ErrVersionMismatch
+	}
+	trashed := current
+	now := stamp()
+	created, _ := P(&current).Timestamps()
+	P(&trashed).SetTimestamps(created, now)
+	P(&trashed).SetDeleted(&now)
+	P(&trashed).SetVersion(P(&current).CurrentVersion() + 1)
+	m.records[id] = trashed
+	m.audit = append(m.audit, newAuditEntry[T, P](ctx, AuditSoftDelete, &current, &trashed))
+	return nil
+}
+
+// Batch applies ops under one lock. An atomic batch that fails puts the
+// records and the audit log back as they were.
+func (m *MemoryStore[T, P]) Batch(ctx context.Context, ops []BatchOp[T], opts BatchOptions) ([]error, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var records map[string]T
+	var order []string
+	if opts.Atomic {
+		records, order = maps.Clone(m.records), slices.Clone(m.order)
+	}
+	audit := len(m.audit)
+	errs := make([]error, len(ops))
+	for i, op := range ops {
+		switch {
+		case op.Op == BatchCreate:
+			errs[i] = m.create(ctx, op.Record)
+		case op.Op == BatchUpdate:
+			P(op.Record).SetVersion(op.Version)
+			errs[i] = m.update(ctx, op.ID, op.Record)
+		case op.Op == BatchDelete && opts.SoftDelete:
+			errs[i] = m.softDelete(ctx, op.ID, op.Version)
+		case op.Op == BatchDelete:
+			errs[i] = m.deleteVersion(ctx, op.ID, op.Version)
+		default:
+			errs[i] = fmt.Errorf("unknown batch operation %q", op.Op)
+		}
+		if errs[i] != nil && opts.Atomic {
+			m.records, m.order, m.audit = records, order, m.audit[:audit]
+			notApplied(errs)
+			break
+		}
+	}
+	return errs, nil
+}
+
+func (m *MemoryStore[T, P]) Restore(ctx context.Context, id string) (*T, error) {
+	id, err := canonicalKey[T, P](id)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.records[id]
+	if !ok || P(&current).Deleted() == nil {
+		return nil, ErrNotFound
+	}
+	restored := current
+	created, _ := P(&current).Timestamps()
+	P(&restored).SetTimestamps(created, stamp())
+	P(&restored).SetDeleted(nil)
+	P(&restored).SetVersion(P(&current).CurrentVersion() + 1)
+	m.records[id] = restored
+	m.audit = append(m.audit, newAuditEntry[T, P](ctx, AuditRestore, &current, &restored))
+	return &restored, nil
+}
+
+func (m *MemoryStore[T, P]) Purge(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var purged int64
+	for _, id := range slices.Clone(m.order) {
+		v := m.records[id]
+		if at := P(&v).Deleted(); at != nil && at.Before(before) {
+			m.remove(id)
+			m.audit = append(m.audit, newAuditEntry[T, P](ctx, AuditPurge, &v, nil))
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *MemoryStore[T, P]) History(ctx context.Context, id string) ([]AuditEntry, error) {
+	id, err := canonicalKey[T, P](id)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var entries []AuditEntry
+	for _, e := range m.audit {
+		if e.RecordID == id {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, ErrNotFound
+	}
+	return entries, nil
+}
+
+func (m *MemoryStore[T, P]) List(ctx context.Context, q ListQuery) (Page[T], error) {
+	return m.list(q, false)
+}
+
+func (m *MemoryStore[T, P]) Trash(ctx context.Context, q ListQuery) (Page[T], error) {
+	return m.list(q, true)
+}
+
+// list pages through the live records, or the trashed ones.
+func (m *MemoryStore[T, P]) list(q ListQuery, trashed bool) (Page[T], error) {
+	q, err := q.normalized()
+	if err != nil {
+		return Page[T]{}, err
+	}
+	after, err := decodeCursor(q.Cursor, q.Sort)
+	if err != nil {
+		return Page[T]{}, err
+	}
+	m.mu.RLock()
+	matched := make([]T, 0, len(m.order))
+	for _, id := range m.order {
+		v := m.records[id]
+		if (P(&v).Deleted() != nil) != trashed {
+			continue
+		}
+		if q.EmailDomain != "" && !strings.HasSuffix(strings.ToLower(P(&v).Field("email")), "@"+q.EmailDomain) {
+			continue
+		}
+		created, updated := P(&v).Timestamps()
+		if created.Before(q.CreatedSince) || updated.Before(q.UpdatedSince) {
+			continue
+		}
+		matched = append(matched, v)
+	}
+	m.mu.RUnlock()
+
+	// position orders a record against a (sort value, id) pair.
+	position := func(v P, value, id string) int {
+		if q.Sort != "id" {
+			if c := strings.Compare(v.Field(q.Sort), value); c != 0 {
+				return c
+			}
+		}
+		return compareKeys(v.Key(), id)
+	}
+	slices.SortFunc(matched, func(a, b T) int {
+		return position(&a, P(&b).Field(q.Sort), P(&b).Key())
+	})
+
+	page := Page[T]{Items: []T{}}
+	if q.WithTotal {
+		total := int64(len(matched))
+		page.Total = &total
+	}
+	for _, v := range matched {
+		if after.ID != "" && position(&v, after.Value, after.ID) <= 0 {
+			continue
+		}
+		if len(page.Items) == q.Limit {
+			page.NextCursor = nextCursor[T, P](q, page.Items, true)
+			break
+		}
+		page.Items = append(page.Items, v)
+	}
+	return page, nil
+}
@@ -0,0 +1,101 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"errors"
+	"redisDatabase/hybridsystem"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMemoryStore_Users(t *testing.T) {
+	ctx := context.Background()
+	var store hybridsystem.UserStore[hybridsystem.User2] = hybridsystem.NewMemoryUserStore()
+
+	user := hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com"}
+	if err := store.Create(ctx, &user); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if user.ID == 0 {
+		t.Fatalf("expected non zero ID")
+	}
+
+	tests := []struct {
+		name    string // description of this test case
+		id      string
+		wantErr error
+	}{
+		{
+			name:    "existing id",
+			id:      user.Key(),
+			wantErr: nil,
+		},
+		{
+			name:    "unknown id",
+			id:      "9648",
+			wantErr: hybridsystem.ErrNotFound,
+		},
+		{
+			name:    "invalid id format",
+			id:      "abc",
+			wantErr: hybridsystem.ErrInvalidID,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.Get(ctx, tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if err == nil && got.Email != user.Email {
+				t.Errorf("expected email %s, got %s", user.Email, got.Email)
+			}
+		})
+	}
+
+	updated := hybridsystem.User2{Name: "Akash paul", Email: "akashpaul@gmail.com"}
+	if err := store.Update(ctx, user.Key(), &updated); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if updated.ID != user.ID {
+		t.Errorf("expected id %d, got %d", user.ID, updated.ID)
+	}
+	if err := store.Update(ctx, "9648", &updated); !errors.Is(err, hybridsystem.ErrNotFound) {
+		t.Errorf("expected not found on update, got %v", err)
+	}
+
+	page, err := store.List(ctx, hybridsystem.ListQuery{})
+	if err != nil || len(page.Items) != 1 || page.Items[0].Name != "Akash paul" {
+		t.Fatalf("unexpected list %v, err %v", page.Items, err)
+	}
+
+	if err := store.Delete(ctx, user.Key()); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if err := store.Delete(ctx, user.Key()); !errors.Is(err, hybridsystem.ErrNotFound) {
+		t.Errorf("expected not found on second delete, got %v", err)
+	}
+}
+
+func TestMemoryStore_Persons(t *testing.T) {
+	ctx := context.Background()
+	var store hybridsystem.UserStore[hybridsystem.Person] = hybridsystem.NewMemoryPersonStore()
+
+	person := hybridsystem.Person{Name: "Akash", Email: "akash@gmail.com"}
+	if err := store.Create(ctx, &person); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if person.ID.IsZero() {
+		t.Fatalf("expected an ObjectID to be assigned")
+	}
+	if _, err := store.Get(ctx, person.ID.Hex()); err != nil {
+		t.Fatalf("get failed: %v", err)
+	}
+	if _, err := store.Get(ctx, primitive.NewObjectID().Hex()); !errors.Is(err, hybridsystem.ErrNotFound) {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if _, err := store.Get(ctx, "5347"); !errors.Is(err, hybridsystem.ErrInvalidID) {
+		t.Errorf("expected invalid id, got %v", err)
+	}
+}
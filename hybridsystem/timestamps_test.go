@@ -0,0 +1,62 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestResource_Timestamps(t *testing.T) {
+	store := hybridsystem.NewMemoryUserStore()
+	backend := hybridsystem.NewMemoryBackend()
+	cache := hybridsystem.NewCache[hybridsystem.User2](backend, hybridsystem.UserKeys, time.Minute)
+	res := &hybridsystem.Resource[hybridsystem.User2, *hybridsystem.User2]{Name: "user", Store: store, Cache: cache, Validate: hybridsystem.ValidateUser}
+	router := mux.NewRouter()
+	res.Register(router, "/users")
+	send := func(method, path, body string) hybridsystem.User2 {
+		t.Helper()
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", hybridsystem.MergePatchContentType)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code >= 300 {
+			t.Fatalf("%s %s: got %d: %s", method, path, w.Code, w.Body)
+		}
+		var u hybridsystem.User2
+		json.NewDecoder(w.Body).Decode(&u)
+		return u
+	}
+	forged := `"created_at":"2000-01-01T00:00:00Z","updated_at":"2000-01-01T00:00:00Z","version":42`
+
+	created := send(http.MethodPost, "/users", `{"name":"Akash","email":"akash@gmail.com",`+forged+`}`)
+	if created.CreatedAt.Year() == 2000 || !created.CreatedAt.Equal(created.UpdatedAt) || created.Version != 1 {
+		t.Fatalf("expected the server to set created_at, updated_at and version, got %+v", created)
+	}
+	time.Sleep(2 * time.Millisecond)
+	updated := send(http.MethodPut, "/users/1", `{"name":"Paul","email":"akash@gmail.com",`+forged+`}`)
+	if !updated.CreatedAt.Equal(created.CreatedAt) || !updated.UpdatedAt.After(created.UpdatedAt) || updated.Version != 2 {
+		t.Fatalf("expected created_at kept and updated_at moved on, got %+v after %+v", updated, created)
+	}
+	time.Sleep(2 * time.Millisecond)
+	patched := send(http.MethodPatch, "/users/1", `{`+forged+`}`)
+	if !patched.CreatedAt.Equal(created.CreatedAt) || !patched.UpdatedAt.After(updated.UpdatedAt) || patched.Version != 3 {
+		t.Fatalf("expected a patch not to set the metadata, got %+v", patched)
+	}
+
+	stored, _ := store.Get(context.Background(), "1")
+	cached, _ := backend.Get(context.Background(), hybridsystem.UserKeys.Key("1"))
+	want, _ := json.Marshal(stored)
+	if string(cached) != string(want) {
+		t.Errorf("expected the cache to hold %s, got %s", want, cached)
+	}
+	if got := send(http.MethodGet, "/users/1", ""); !got.UpdatedAt.Equal(stored.UpdatedAt) {
+		t.Errorf("expected updated_at %s from the cache, got %s", stored.UpdatedAt, got.UpdatedAt)
+	}
+}
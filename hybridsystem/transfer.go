@@ -0,0 +1,144 @@
+package hybridsystem
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"strings"
+)
+
+// Export writes every record of store to w as one JSON object per line, in
+// id order, and returns how many it wrote.
+func Export[T any](ctx context.Context, store UserStore[T], w io.Writer) (int, error) {
+	enc := json.NewEncoder(w)
+	q := ListQuery{Limit: MaxListLimit}
+	n := 0
+	for {
+		page, err := store.List(ctx, q)
+		if err != nil {
+			return n, err
+		}
+		for _, v := range page.Items {
+			if err := enc.Encode(v); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if page.NextCursor == "" {
+			return n, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// ImportResult counts what Import did with the lines it read.
+type ImportResult struct {
+	Created int
+	// Skipped are lines whose email was already taken.
+	Skipped int
+}
+
+// Import creates a record for every JSON line of r, as written by Export.
+// The ids in the file are dropped and the store hands out new ones. validate,
+// if not nil, checks each record first. A line whose email is already taken
+// is skipped when skipDuplicates is set; otherwise it, like any other error,
+// stops the import and is reported with its line number.
+func Import[T any](ctx context.Context, store UserStore[T], r io.Reader, validate func(T) error, skipDuplicates bool) (ImportResult, error) {
+	var result ImportResult
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := strings.TrimSpace(scanner.Text())
+		if data == "" {
+			continue
+		}
+		v, err := decodeWithoutID[T]([]byte(data))
+		if err == nil && validate != nil {
+			err = validate(v)
+		}
+		if err == nil {
+			err = store.Create(ctx, &v)
+		}
+		var dup *DuplicateError
+		switch {
+		case err == nil:
+			result.Created++
+		case skipDuplicates && errors.As(err, &dup):
+			result.Skipped++
+		default:
+			return result, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return result, scanner.Err()
+}
+
+// decodeWithoutID decodes a JSON object into a T, ignoring its "id".
+func decodeWithoutID[T any](data []byte) (T, error) {
+	var v T
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return v, err
+	}
+	delete(fields, "id")
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return v, err
+	}
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+var (
+	seedFirstNames = []string{"Akash", "Priya", "Rahul", "Ananya", "Vikram", "Sneha", "Arjun", "Meera", "Rohan", "Kavya"}
+	seedLastNames  = []string{"Paul", "Sharma", "Iyer", "Das", "Gupta", "Nair", "Reddy", "Bose", "Khan", "Mehta"}
+)
+
+// Seed creates count made-up records with newRecord, for filling a
+// development database. Names pass the default validation and emails are
+// unique at domain, also across runs.
+func Seed[T any](ctx context.Context, store UserStore[T], count int, domain string, newRecord func(name, email string) T) (int, error) {
+	run := make([]byte, 4)
+	rand.Read(run)
+	for i := 0; i < count; i++ {
+		first := seedFirstNames[mathrand.IntN(len(seedFirstNames))]
+		last := seedLastNames[mathrand.IntN(len(seedLastNames))]
+		email := fmt.Sprintf("%s.%s.%s-%d@%s", strings.ToLower(first), strings.ToLower(last), hex.EncodeToString(run), i, domain)
+		v := newRecord(first+" "+last, email)
+		if err := store.Create(ctx, &v); err != nil {
+			return i, err
+		}
+	}
+	return count, nil
+}
+
+// WarmCache loads up to limit records of store, in id order, into c.
+func WarmCache[T any, P Entity[T]](ctx context.Context, c *Cache[T], store UserStore[T], limit int) (int, error) {
+	q := ListQuery{Limit: min(limit, MaxListLimit)}
+	n := 0
+	for n < limit {
+		page, err := store.List(ctx, q)
+		if err != nil {
+			return n, err
+		}
+		for i := range page.Items {
+			if n == limit {
+				break
+			}
+			if err := c.Put(ctx, P(&page.Items[i]).Key(), &page.Items[i]); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	return n, nil
+}
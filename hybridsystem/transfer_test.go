@@ -0,0 +1,161 @@
+package hybridsystem_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createUsers(t *testing.T, store hybridsystem.UserStore[hybridsystem.User2], n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		if err := store.Create(context.Background(), &hybridsystem.User2{Name: "Akash", Email: fmt.Sprintf("user%d@example.com", i)}); err != nil {
+			t.Fatalf("create failed: %v", err)
+		}
+	}
+}
+
+func listRecords[T any](t *testing.T, store hybridsystem.UserStore[T]) []T {
+	t.Helper()
+	var all []T
+	q := hybridsystem.ListQuery{Limit: hybridsystem.MaxListLimit}
+	for {
+		page, err := store.List(context.Background(), q)
+		if err != nil {
+			t.Fatalf("list failed: %v", err)
+		}
+		all = append(all, page.Items...)
+		if page.NextCursor == "" {
+			return all
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func TestExportImport_RoundTrip(t *testing.T) {
+	ctx := context.Background()
+	source := hybridsystem.NewMemoryUserStore()
+	createUsers(t, source, 150)
+
+	var buf bytes.Buffer
+	n, err := hybridsystem.Export[hybridsystem.User2](ctx, source, &buf)
+	if err != nil || n != 150 {
+		t.Fatalf("expected 150 exported, got %d: %v", n, err)
+	}
+
+	target := hybridsystem.NewMemoryUserStore()
+	target.Create(ctx, &hybridsystem.User2{Name: "Existing", Email: "existing@example.com"})
+	result, err := hybridsystem.Import[hybridsystem.User2](ctx, target, &buf, hybridsystem.ValidateUser, false)
+	if err != nil || result.Created != 150 {
+		t.Fatalf("expected 150 created, got %+v: %v", result, err)
+	}
+	all := listRecords[hybridsystem.User2](t, target)
+	if len(all) != 151 || all[0].Name != "Existing" || all[1].ID != 2 {
+		t.Errorf("expected the imports to get new ids after the existing user, got %d users starting %+v", len(all), all[:2])
+	}
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name           string // description of this test case
+		input          string
+		skipDuplicates bool
+		wantResult     hybridsystem.ImportResult
+		wantErr        string
+	}{
+		{
+			name:       "blank lines are ignored",
+			input:      "{\"name\":\"Ann\",\"email\":\"ann@example.com\"}\n\n{\"name\":\"Bob\",\"email\":\"bob@example.com\"}\n",
+			wantResult: hybridsystem.ImportResult{Created: 2},
+		},
+		{
+			name:           "duplicates skipped",
+			input:          "{\"name\":\"Ann\",\"email\":\"taken@example.com\"}\n{\"name\":\"Bob\",\"email\":\"bob@example.com\"}\n",
+			skipDuplicates: true,
+			wantResult:     hybridsystem.ImportResult{Created: 1, Skipped: 1},
+		},
+		{
+			name:       "duplicate stops the import",
+			input:      "{\"name\":\"Bob\",\"email\":\"bob@example.com\"}\n{\"name\":\"Ann\",\"email\":\"TAKEN@example.com\"}\n",
+			wantResult: hybridsystem.ImportResult{Created: 1},
+			wantErr:    "line 2",
+		},
+		{
+			name:    "invalid record",
+			input:   "{\"name\":\"R2D2\",\"email\":\"r2@example.com\"}\n",
+			wantErr: "line 1: name",
+		},
+		{
+			name:    "malformed json",
+			input:   "{\"name\":",
+			wantErr: "line 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := hybridsystem.NewMemoryUserStore()
+			store.Create(ctx, &hybridsystem.User2{Name: "Taken", Email: "taken@example.com"})
+			result, err := hybridsystem.Import[hybridsystem.User2](ctx, store, strings.NewReader(tt.input), hybridsystem.ValidateUser, tt.skipDuplicates)
+			if result != tt.wantResult {
+				t.Errorf("expected %+v, got %+v", tt.wantResult, result)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected an error mentioning %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSeed(t *testing.T) {
+	ctx := context.Background()
+	store := hybridsystem.NewMemoryPersonStore()
+	newPerson := func(name, email string) hybridsystem.Person { return hybridsystem.Person{Name: name, Email: email} }
+
+	// two runs must not collide on emails
+	for run := 0; run < 2; run++ {
+		if n, err := hybridsystem.Seed[hybridsystem.Person](ctx, store, 50, "example.org", newPerson); err != nil || n != 50 {
+			t.Fatalf("expected 50 seeded, got %d: %v", n, err)
+		}
+	}
+	persons := listRecords[hybridsystem.Person](t, store)
+	if len(persons) != 100 {
+		t.Fatalf("expected 100 persons, got %d", len(persons))
+	}
+	validator := hybridsystem.PersonValidator(hybridsystem.ValidationConfig{AllowedEmailDomains: []string{"example.org"}})
+	for _, p := range persons {
+		if err := validator.Validate(p); err != nil {
+			t.Fatalf("seeded an invalid person %+v: %v", p, err)
+		}
+	}
+}
+
+func TestWarmCache(t *testing.T) {
+	ctx := context.Background()
+	store := hybridsystem.NewMemoryUserStore()
+	createUsers(t, store, 120)
+	backend := hybridsystem.NewMemoryBackend()
+	cache := hybridsystem.NewCache[hybridsystem.User2](backend, hybridsystem.UserKeys, time.Minute)
+
+	n, err := hybridsystem.WarmCache[hybridsystem.User2](ctx, cache, store, 110)
+	if err != nil || n != 110 {
+		t.Fatalf("expected 110 cached, got %d: %v", n, err)
+	}
+	for id, want := range map[string]bool{"1": true, "110": true, "111": false} {
+		_, err := backend.Get(ctx, hybridsystem.UserKeys.Key(id))
+		if (err == nil) != want {
+			t.Errorf("user %s cached: %v, want %v", id, err == nil, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package hybridsystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// SoftDeleteConfig turns DELETE into a move to the trash. Trashed records
+// are kept for Retention and then deleted for good by a purge job that runs
+// every PurgeInterval.
+type SoftDeleteConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Retention     time.Duration `yaml:"retention"`
+	PurgeInterval time.Duration `yaml:"purge_interval"`
+}
+
+// Purgeable is the part of a TrashStore the purge job needs.
+type Purgeable interface {
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Purger deletes the records that have been in the trash of Stores, keyed
+// by a name for the logs, for longer than Retention. Logger nil means
+// slog.Default().
+type Purger struct {
+	Retention time.Duration
+	Interval  time.Duration
+	Stores    map[string]Purgeable
+	Logger    *slog.Logger
+}
+
+// PurgeOnce purges every store once. A failing store does not stop the
+// others; the errors are returned together.
+func (p *Purger) PurgeOnce(ctx context.Context) error {
+	before := time.Now().Add(-p.Retention)
+	var errs []error
+	for _, name := range sortedKeys(p.Stores) {
+		n, err := p.Stores[name].Purge(ctx, before)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("purge %s: %w", name, err))
+			continue
+		}
+		if n > 0 {
+			p.logger().Info("purged trash", "store", name, "deleted", n, "before", before)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run purges right away and then every Interval until ctx is done.
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		if err := p.PurgeOnce(ctx); err != nil && ctx.Err() == nil {
+			p.logger().Error("trash purge failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Start runs p in the background. Closing the result stops it and waits for
+// a purge in progress, so it can go in Server.Closers ahead of the
+// databases.
+func (p *Purger) Start() *PurgerHandle {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &PurgerHandle{cancel: cancel}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		p.Run(ctx)
+	}()
+	return h
+}
+
+// PurgerHandle stops a started Purger.
+type PurgerHandle struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func (h *PurgerHandle) Close() error {
+	h.cancel()
+	h.wg.Wait()
+	return nil
+}
+
+func (p *Purger) logger() *slog.Logger {
+	if p.Logger == nil {
+		return slog.Default()
+	}
+	return p.Logger
+}
@@ -0,0 +1,202 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMemoryStore_Trash(t *testing.T) {
+	ctx := context.Background()
+	store := hybridsystem.NewMemoryUserStore()
+	store.Create(ctx, &hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com"})
+	store.Create(ctx, &hybridsystem.User2{Name: "Paul", Email: "paul@gmail.com"})
+
+	if err := store.SoftDelete(ctx, "1", 2); !errors.Is(err, hybridsystem.ErrVersionMismatch) {
+		t.Fatalf("expected ErrVersionMismatch for a stale soft delete, got %v", err)
+	}
+	if err := store.SoftDelete(ctx, "1", 1); err != nil {
+		t.Fatalf("soft delete failed: %v", err)
+	}
+	if _, err := store.Get(ctx, "1"); !errors.Is(err, hybridsystem.ErrNotFound) {
+		t.Errorf("expected a trashed user to be not found, got %v", err)
+	}
+	if err := store.Update(ctx, "1", &hybridsystem.User2{Name: "Lost", Email: "akash@gmail.com"}); !errors.Is(err, hybridsystem.ErrNotFound) {
+		t.Errorf("expected a trashed user not to be updated, got %v", err)
+	}
+	if err := store.SoftDelete(ctx, "1", 0); !errors.Is(err, hybridsystem.ErrNotFound) {
+		t.Errorf("expected a second soft delete to be not found, got %v", err)
+	}
+	if page, _ := store.List(ctx, hybridsystem.ListQuery{}); len(page.Items) != 1 || page.Items[0].ID != 2 {
+		t.Errorf("expected only user 2 listed, got %+v", page.Items)
+	}
+	trash, err := store.Trash(ctx, hybridsystem.ListQuery{})
+	if err != nil || len(trash.Items) != 1 || trash.Items[0].ID != 1 || trash.Items[0].DeletedAt == nil {
+		t.Fatalf("expected user 1 in the trash, got %+v: %v", trash.Items, err)
+	}
+
+	restored, err := store.Restore(ctx, "1")
+	if err != nil || restored.DeletedAt != nil || restored.Version != 3 {
+		t.Fatalf("expected user 1 restored at version 3, got %+v: %v", restored, err)
+	}
+	if _, err := store.Restore(ctx, "1"); !errors.Is(err, hybridsystem.ErrNotFound) {
+		t.Errorf("expected restoring a live user to be not found, got %v", err)
+	}
+	if _, err := store.Get(ctx, "1"); err != nil {
+		t.Errorf("expected a restored user to be found, got %v", err)
+	}
+
+	store.SoftDelete(ctx, "2", 0)
+	if n, err := store.Purge(ctx, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("expected nothing trashed an hour ago, purged %d: %v", n, err)
+	}
+	if n, err := store.Purge(ctx, time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("expected user 2 purged, purged %d: %v", n, err)
+	}
+	if _, err := store.Restore(ctx, "2"); !errors.Is(err, hybridsystem.ErrNotFound) {
+		t.Errorf("expected a purged user to be gone, got %v", err)
+	}
+}
+
+func TestResource_Trash(t *testing.T) {
+	ctx := context.Background()
+	store := hybridsystem.NewMemoryUserStore()
+	store.Create(ctx, &hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com"})
+	cache := hybridsystem.NewCache[hybridsystem.User2](hybridsystem.NewMemoryBackend(), hybridsystem.UserKeys, time.Minute)
+	cache.NotFoundTTL = time.Minute
+	res := &hybridsystem.Resource[hybridsystem.User2, *hybridsystem.User2]{Name: "user", Store: store, Cache: cache, Trash: store, Validate: hybridsystem.ValidateUser}
+	router := mux.NewRouter()
+	res.Register(router, "/users")
+
+	// the steps run in order against the same record
+	steps := []struct {
+		name      string // description of this test case
+		method    string
+		path      string
+		wantCode  int
+		wantETag  string
+		wantTrash int
+	}{
+		{name: "cache the user", method: http.MethodGet, path: "/users/1", wantCode: http.StatusOK, wantETag: `"1"`},
+		{name: "empty trash", method: http.MethodGet, path: "/users/trash", wantCode: http.StatusOK},
+		{name: "restore a live user", method: http.MethodPost, path: "/users/1/restore", wantCode: http.StatusNotFound},
+		{name: "soft delete", method: http.MethodDelete, path: "/users/1", wantCode: http.StatusOK},
+		{name: "trashed user is not found", method: http.MethodGet, path: "/users/1", wantCode: http.StatusNotFound},
+		{name: "not found from the cache", method: http.MethodGet, path: "/users/1", wantCode: http.StatusNotFound},
+		{name: "delete again", method: http.MethodDelete, path: "/users/1", wantCode: http.StatusNotFound},
+		{name: "listed in the trash", method: http.MethodGet, path: "/users/trash", wantCode: http.StatusOK, wantTrash: 1},
+		{name: "restore", method: http.MethodPost, path: "/users/1/restore", wantCode: http.StatusOK, wantETag: `"3"`},
+		{name: "found from the cache", method: http.MethodGet, path: "/users/1", wantCode: http.StatusOK, wantETag: `"3"`},
+		{name: "trash is empty again", method: http.MethodGet, path: "/users/trash", wantCode: http.StatusOK},
+	}
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, w.Code, w.Body)
+			}
+			if got := w.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("expected ETag %s, got %s", tt.wantETag, got)
+			}
+			if tt.path == "/users/trash" {
+				var page hybridsystem.Page[hybridsystem.User2]
+				if err := json.NewDecoder(w.Body).Decode(&page); err != nil || len(page.Items) != tt.wantTrash {
+					t.Errorf("expected %d trashed users, got %+v: %v", tt.wantTrash, page.Items, err)
+				}
+			}
+		})
+	}
+	if stats := cache.Stats(); stats.NotFound != 1 {
+		t.Errorf("expected one read answered by a tombstone, got %+v", stats)
+	}
+}
+
+func TestResource_NoTrash(t *testing.T) {
+	ctx := context.Background()
+	store := hybridsystem.NewMemoryUserStore()
+	store.Create(ctx, &hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com"})
+	res := &hybridsystem.Resource[hybridsystem.User2, *hybridsystem.User2]{Name: "user", Store: store}
+	router := mux.NewRouter()
+	res.Register(router, "/users")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/users/1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" || strings.TrimSpace(w.Body.String()) != `{"deleted":"1"}` {
+		t.Errorf("expected the deleted id as JSON, got %s %s", ct, w.Body)
+	}
+	if page, _ := store.Trash(ctx, hybridsystem.ListQuery{}); len(page.Items) != 0 {
+		t.Errorf("expected a hard delete without Trash, got %+v in the trash", page.Items)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/users/1/restore", nil))
+	if w.Code != http.StatusNotFound && w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected no restore route, got %d", w.Code)
+	}
+}
+
+// failingPurge is a trash that cannot be purged.
+type failingPurge struct{}
+
+func (failingPurge) Purge(context.Context, time.Time) (int64, error) {
+	return 0, errors.New("database is down")
+}
+
+func TestPurger_PurgeOnce(t *testing.T) {
+	ctx := context.Background()
+	users := hybridsystem.NewMemoryUserStore()
+	users.Create(ctx, &hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com"})
+	users.SoftDelete(ctx, "1", 0)
+
+	purger := &hybridsystem.Purger{Retention: time.Hour, Stores: map[string]hybridsystem.Purgeable{"users": users}}
+	if err := purger.PurgeOnce(ctx); err != nil {
+		t.Fatalf("purge failed: %v", err)
+	}
+	if page, _ := users.Trash(ctx, hybridsystem.ListQuery{}); len(page.Items) != 1 {
+		t.Fatalf("expected the retention period to keep user 1, got %+v", page.Items)
+	}
+
+	purger.Retention = -time.Minute
+	purger.Stores["broken"] = failingPurge{}
+	if err := purger.PurgeOnce(ctx); err == nil {
+		t.Errorf("expected the failing store to be reported")
+	}
+	if page, _ := users.Trash(ctx, hybridsystem.ListQuery{}); len(page.Items) != 0 {
+		t.Errorf("expected user 1 purged despite the failing store, got %+v", page.Items)
+	}
+}
+
+func TestPurger_Start(t *testing.T) {
+	ctx := context.Background()
+	users := hybridsystem.NewMemoryUserStore()
+	users.Create(ctx, &hybridsystem.User2{Name: "Akash", Email: "akash@gmail.com"})
+	users.SoftDelete(ctx, "1", 0)
+
+	purger := &hybridsystem.Purger{Retention: -time.Minute, Interval: time.Hour, Stores: map[string]hybridsystem.Purgeable{"users": users}}
+	handle := purger.Start()
+	deadline := time.Now().Add(time.Second)
+	for {
+		page, _ := users.Trash(ctx, hybridsystem.ListQuery{})
+		if len(page.Items) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a purge on start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := handle.Close(); err != nil {
+		t.Errorf("close failed: %v", err)
+	}
+}
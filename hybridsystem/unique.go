@@ -0,0 +1,81 @@
+package hybridsystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// ErrDuplicateEmails is returned by EnsureUniqueEmail while records still
+// share an email; the unique index cannot be created until they are cleaned up.
+var ErrDuplicateEmails = errors.New("emails are used by more than one record")
+
+// NormalizeEmail is the form an email is stored and compared in. Addresses
+// are treated as case-insensitive as a whole, so "Jane@Example.com" and
+// "jane@example.com" are the same user.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// DuplicateError is returned by a store when a write would give a second
+// record the same unique value. ExistingID is the record that already has it,
+// or "" if it could not be looked up.
+type DuplicateError struct {
+	Field      string
+	Value      string
+	ExistingID string
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("%s %q is already used by record %s", e.Field, e.Value, e.ExistingID)
+}
+
+// DuplicateGroup is an email shared by several records, as found by
+// FindDuplicateEmails.
+type DuplicateGroup struct {
+	Email string   `json:"email"`
+	IDs   []string `json:"ids"`
+}
+
+// UniqueEmails is implemented by the database stores. FindDuplicateEmails
+// lists the emails that, once normalized, belong to more than one record.
+// EnsureUniqueEmail creates the unique email index unless it exists; it fails
+// with ErrDuplicateEmails while there are duplicates.
+type UniqueEmails interface {
+	FindDuplicateEmails(ctx context.Context) ([]DuplicateGroup, error)
+	EnsureUniqueEmail(ctx context.Context) error
+}
+
+// ensureUniqueEmail is the shared body of EnsureUniqueEmail: it does nothing
+// if the index exists, refuses while there are duplicates and otherwise calls
+// create.
+func ensureUniqueEmail(ctx context.Context, s UniqueEmails, exists func(context.Context) (bool, error), create func(context.Context) error) error {
+	ok, err := exists(ctx)
+	if err != nil || ok {
+		return err
+	}
+	groups, err := s.FindDuplicateEmails(ctx)
+	if err != nil {
+		return err
+	}
+	if len(groups) > 0 {
+		return fmt.Errorf("%w: %d emails are duplicated, e.g. %q", ErrDuplicateEmails, len(groups), groups[0].Email)
+	}
+	return create(ctx)
+}
+
+// EnforceUniqueEmail creates the unique email index of the store called name
+// at startup. A failure is logged but does not stop the server: existing
+// duplicates have to be resolved first, see the dupemails command.
+func EnforceUniqueEmail(logger *slog.Logger, name string, s UniqueEmails) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	if err := s.EnsureUniqueEmail(ctx); err != nil {
+		logger.Warn("unique email index not in place", "store", name, "error", err)
+	}
+}
@@ -0,0 +1,100 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMemoryStore_UniqueEmail(t *testing.T) {
+	ctx := context.Background()
+	store := hybridsystem.NewMemoryUserStore()
+	alice := hybridsystem.User2{Name: "Alice", Email: " Alice@Example.com"}
+	if err := store.Create(ctx, &alice); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if alice.Email != "alice@example.com" {
+		t.Fatalf("expected a normalized email, got %q", alice.Email)
+	}
+	bob := hybridsystem.User2{Name: "Bob", Email: "bob@example.com"}
+	if err := store.Create(ctx, &bob); err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string // description of this test case
+		update   string
+		email    string
+		wantDupe bool
+	}{
+		{name: "create with the same email in another case", email: "ALICE@example.com", wantDupe: true},
+		{name: "create with a new email", email: "carol@example.com"},
+		{name: "update to another user's email", update: bob.Key(), email: "alice@EXAMPLE.com", wantDupe: true},
+		{name: "update keeping its own email", update: alice.Key(), email: "Alice@example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := hybridsystem.User2{Name: "Someone", Email: tt.email}
+			var err error
+			if tt.update != "" {
+				err = store.Update(ctx, tt.update, &u)
+			} else {
+				err = store.Create(ctx, &u)
+			}
+			var dup *hybridsystem.DuplicateError
+			if !tt.wantDupe {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &dup) {
+				t.Fatalf("expected a DuplicateError, got %v", err)
+			}
+			if dup.ExistingID != alice.Key() || dup.Field != "email" {
+				t.Errorf("expected a conflict with user %s, got %+v", alice.Key(), dup)
+			}
+		})
+	}
+}
+
+func TestResource_DuplicateEmailConflict(t *testing.T) {
+	handle := &hybridsystem.HybridHandler3{Persons: hybridsystem.NewMemoryPersonStore()}
+	r := mux.NewRouter()
+	r.HandleFunc("/persons", handle.CreateUserHandlers4).Methods("POST")
+
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/persons", strings.NewReader(body)))
+		return w
+	}
+	// the legacy handler answers a create with 200
+	first := post(`{"name":"Akash","email":"akash@gmail.com"}`)
+	if first.Code != http.StatusOK {
+		t.Fatalf("expected status ok, got %d: %s", first.Code, first.Body.String())
+	}
+	var created hybridsystem.Person
+	json.NewDecoder(first.Body).Decode(&created)
+
+	w := post(`{"name":"Akash Paul","email":"Akash@Gmail.com"}`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected conflict, got %d: %s", w.Code, w.Body.String())
+	}
+	var p hybridsystem.Problem
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatalf("failed to decode problem: %v", err)
+	}
+	if p.Type != hybridsystem.ProblemTypeConflict || p.ExistingID != created.ID.Hex() {
+		t.Errorf("expected a conflict with %s, got %+v", created.ID.Hex(), p)
+	}
+	if len(p.Errors) != 1 || p.Errors[0].Field != "email" {
+		t.Errorf("expected the email field to be named, got %+v", p.Errors)
+	}
+}
@@ -9,7 +9,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
-	hybridsystem "redisDatabase/Hybridsystem"
+	hybridsystem "redisDatabase/hybridsystem"
 	"strconv"
 	"testing"
 
@@ -0,0 +1,56 @@
+package hybridsystem
+
+import "redisDatabase/validation"
+
+// ValidationConfig holds the rules shared by every create and update
+// handler. No AllowedEmailDomains means any domain is accepted.
+type ValidationConfig struct {
+	AllowedEmailDomains []string `yaml:"allowed_email_domains"`
+	NameMaxLen          int      `yaml:"name_max_len"`
+	EmailMaxLen         int      `yaml:"email_max_len"`
+}
+
+func DefaultValidationConfig() ValidationConfig {
+	// 254 is the longest address that fits in an SMTP forward path
+	return ValidationConfig{NameMaxLen: 100, EmailMaxLen: 254}
+}
+
+func (c ValidationConfig) withDefaults() ValidationConfig {
+	defaults := DefaultValidationConfig()
+	if c.NameMaxLen == 0 {
+		c.NameMaxLen = defaults.NameMaxLen
+	}
+	if c.EmailMaxLen == 0 {
+		c.EmailMaxLen = defaults.EmailMaxLen
+	}
+	return c
+}
+
+// contactValidator declares the name and email rules of any record that
+// has both.
+func contactValidator[T any](cfg ValidationConfig, name, email func(*T) string) *validation.Validator[T] {
+	cfg = cfg.withDefaults()
+	return validation.New[T]().
+		Field("name", name,
+			validation.Required(), validation.MaxLen(cfg.NameMaxLen), validation.PersonName()).
+		Field("email", email,
+			validation.Required(), validation.MaxLen(cfg.EmailMaxLen), validation.Email(), validation.EmailDomain(cfg.AllowedEmailDomains...))
+}
+
+func UserValidator(cfg ValidationConfig) *validation.Validator[User2] {
+	return contactValidator(cfg, func(u *User2) string { return u.Name }, func(u *User2) string { return u.Email })
+}
+
+func PersonValidator(cfg ValidationConfig) *validation.Validator[Person] {
+	return contactValidator(cfg, func(p *Person) string { return p.Name }, func(p *Person) string { return p.Email })
+}
+
+// ValidateUser and ValidateUser1 check a user or person with the default
+// rules.
+func ValidateUser(user User2) error {
+	return UserValidator(DefaultValidationConfig()).Validate(user)
+}
+
+func ValidateUser1(person Person) error {
+	return PersonValidator(DefaultValidationConfig()).Validate(person)
+}
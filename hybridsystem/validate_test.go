@@ -0,0 +1,67 @@
+package hybridsystem_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"redisDatabase/hybridsystem"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHybridHandler3_Validation(t *testing.T) {
+	store := hybridsystem.NewMemoryUserStore()
+	store.Create(context.Background(), &hybridsystem.User2{Name: "alice", Email: "alice@gmail.com"})
+	handle := &hybridsystem.HybridHandler3{
+		Users:      store,
+		Validation: hybridsystem.ValidationConfig{AllowedEmailDomains: []string{"gmail.com"}},
+	}
+
+	tests := []struct {
+		name       string // description of this test case
+		method     string
+		body       string
+		wantFields []string
+	}{
+		{name: "valid create", method: http.MethodPost, body: `{"name":"Akash","email":"akash@gmail.com"}`},
+		{name: "every invalid field reported", method: http.MethodPost, body: `{"name":"4kash","email":"akash"}`, wantFields: []string{"name", "email"}},
+		{name: "domain outside the allowed list", method: http.MethodPost, body: `{"name":"Akash","email":"akash@yahoo.com"}`, wantFields: []string{"email"}},
+		{name: "update is validated too", method: http.MethodPut, body: `{"name":"","email":"alice@gmail.com"}`, wantFields: []string{"name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/users", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			if tt.method == http.MethodPut {
+				handle.UpdateUserHandler3(w, mux.SetURLVars(r, map[string]string{"id": "1"}))
+			} else {
+				handle.CreateUserHandler3(w, r)
+			}
+
+			if len(tt.wantFields) == 0 {
+				if w.Code != http.StatusCreated {
+					t.Fatalf("expected status created, got %d: %s", w.Code, w.Body.String())
+				}
+				return
+			}
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("expected bad request, got %d", w.Code)
+			}
+			var p hybridsystem.Problem
+			if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+				t.Fatalf("failed to decode problem: %v", err)
+			}
+			if len(p.Errors) != len(tt.wantFields) {
+				t.Fatalf("expected errors for %v, got %+v", tt.wantFields, p.Errors)
+			}
+			for i, f := range tt.wantFields {
+				if p.Errors[i].Field != f {
+					t.Errorf("expected an error for %s, got %+v", f, p.Errors[i])
+				}
+			}
+		})
+	}
+}
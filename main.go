@@ -1,11 +1,157 @@
+// Command redisDatabase runs the users and persons API and the chores around
+// it. Run it without arguments for the list of commands, and
+// "redisDatabase <command> -h" for the flags of one.
 package main
 
 import (
-	hybridsystem "redisDatabase/Hybridsystem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"redisDatabase/hybridsystem"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+const progName = "redisDatabase"
+
+// Exit codes. exitFound is used by the checks that succeed but find
+// something to fix, such as duplicate emails or index drift.
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+	exitFound = 3
 )
 
+// command is one subcommand; run gets the arguments after its name.
+type command struct {
+	name    string
+	summary string
+	run     func(e *env, args []string) error
+}
+
+var commands = []command{
+	{"serve", "run the HTTP API", serve},
+	{"migrate", "apply or revert MySQL schema migrations", migrate},
+	{"seed", "create made-up records for development", seed},
+	{"cache", "flush, inspect or warm the Redis cache", cacheCommand},
+	{"export", "write every record as JSON lines", export},
+	{"import", "create records from JSON lines", importCommand},
+	{"dupemails", "list emails used by more than one record", dupEmails},
+	{"mongo-schema", "check or apply MongoDB indexes and validators", mongoSchema},
+}
+
+// env is what a command reads from and writes to.
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// usageError is a command line mistake. An empty message means the flag
+// package already explained it.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+// errFound makes a check exit with exitFound after printing its findings.
+var errFound = errors.New("found problems")
+
 func main() {
-	// redisDatabase.Redisexample()
-	// redisDatabase.CRUDoperations1()
-	hybridsystem.CRUDoperations2()
+	godotenv.Load()
+	os.Exit(run(os.Args[1:], &env{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}))
+}
+
+func run(args []string, e *env) int {
+	if len(args) == 0 {
+		printUsage(e.stderr)
+		return exitUsage
+	}
+	name := args[0]
+	switch name {
+	case "help", "-h", "-help", "--help":
+		if len(args) > 1 {
+			return run([]string{args[1], "-h"}, e)
+		}
+		printUsage(e.stdout)
+		return exitOK
+	}
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		err := c.run(e, args[1:])
+		var usage usageError
+		switch {
+		case err == nil, errors.Is(err, flag.ErrHelp):
+			return exitOK
+		case errors.Is(err, errFound):
+			return exitFound
+		case errors.As(err, &usage):
+			if usage != "" {
+				fmt.Fprintf(e.stderr, "%s %s: %s\n", progName, name, usage)
+			}
+			fmt.Fprintf(e.stderr, "run '%s %s -h' for help\n", progName, name)
+			return exitUsage
+		}
+		fmt.Fprintf(e.stderr, "%s %s: %v\n", progName, name, err)
+		return exitError
+	}
+	fmt.Fprintf(e.stderr, "%s: unknown command %q\n\n", progName, name)
+	printUsage(e.stderr)
+	return exitUsage
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s <command> [flags] [arguments]\n\ncommands:\n", progName)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-13s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nEvery command also takes the config flags (see '%s serve -h').\n", progName)
+	fmt.Fprintln(w, "Exit codes: 0 success, 1 error, 2 bad usage, 3 a check found problems.")
+}
+
+// flagSet starts the flags of a command. synopsis is what follows the
+// flags on the command line.
+func (e *env) flagSet(name, synopsis, summary string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		line := strings.TrimSpace(fmt.Sprintf("usage: %s %s [flags] %s", progName, name, synopsis))
+		fmt.Fprintf(fs.Output(), "%s\n\n%s\n\nflags:\n", line, summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse adds the config flags to fs, parses args and loads the
+// configuration and the logger.
+func (e *env) parse(fs *flag.FlagSet, args []string) (hybridsystem.Config, *slog.Logger, error) {
+	load := hybridsystem.ConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return hybridsystem.Config{}, nil, err
+		}
+		return hybridsystem.Config{}, nil, usageError("")
+	}
+	cfg, err := load()
+	if err != nil {
+		return cfg, nil, usageError(err.Error())
+	}
+	logger, err := hybridsystem.NewLogger(e.stderr, cfg.Log)
+	return cfg, logger, err
+}
+
+// oneOf checks a flag or argument against its allowed values.
+func oneOf(what, value string, allowed ...string) error {
+	for _, a := range allowed {
+		if value == a {
+			return nil
+		}
+	}
+	return usageError(fmt.Sprintf("invalid %s %q, want %s", what, value, strings.Join(allowed, ", ")))
 }
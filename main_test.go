@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRun_Usage(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+
+	tests := []struct {
+		name       string // description of this test case
+		args       []string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "no command", args: nil, wantCode: exitUsage, wantStderr: "commands:"},
+		{name: "help", args: []string{"help"}, wantCode: exitOK, wantStdout: "mongo-schema"},
+		{name: "help for a command", args: []string{"help", "seed"}, wantCode: exitOK, wantStderr: "-count"},
+		{name: "command help", args: []string{"cache", "-h"}, wantCode: exitOK, wantStderr: "flush | inspect <id> | warm | cleanup-legacy"},
+		{name: "unknown command", args: []string{"launch"}, wantCode: exitUsage, wantStderr: `unknown command "launch"`},
+		{name: "unknown flag", args: []string{"serve", "-colour"}, wantCode: exitUsage, wantStderr: "-colour"},
+		{name: "bad mode", args: []string{"serve", "--mode=postgres"}, wantCode: exitUsage, wantStderr: `invalid mode "postgres"`},
+		{name: "bad config", args: []string{"serve", "-log-format", "xml"}, wantCode: exitUsage, wantStderr: "invalid log format"},
+		{name: "migrate without action", args: []string{"migrate"}, wantCode: exitUsage, wantStderr: "missing up, down or version"},
+		{name: "migrate down by zero", args: []string{"migrate", "down", "0"}, wantCode: exitUsage, wantStderr: "steps must be a positive number"},
+		{name: "seed nothing", args: []string{"seed", "--count=0"}, wantCode: exitUsage, wantStderr: "count must be positive"},
+		{name: "seed unknown entity", args: []string{"seed", "--entity=orders"}, wantCode: exitUsage, wantStderr: "single entity"},
+		{name: "export every entity", args: []string{"export", "--entity=all"}, wantCode: exitUsage, wantStderr: "single entity"},
+		{name: "cache without action", args: []string{"cache"}, wantCode: exitUsage, wantStderr: "missing flush, inspect, warm or cleanup-legacy"},
+		{name: "cache inspect without id", args: []string{"cache", "inspect"}, wantCode: exitUsage, wantStderr: "exactly one id"},
+		{name: "cache warm everything", args: []string{"cache", "warm", "--entity=all"}, wantCode: exitUsage, wantStderr: "single entity"},
+		{name: "cache cleanup-legacy with an id", args: []string{"cache", "cleanup-legacy", "42"}, wantCode: exitUsage, wantStderr: "exactly one id"},
+		{name: "cache flush dry run", args: []string{"cache", "flush", "--dry-run"}, wantCode: exitUsage, wantStderr: "only for cleanup-legacy"},
+		{name: "mongo-schema bad action", args: []string{"mongo-schema", "drop"}, wantCode: exitUsage, wantStderr: `invalid action "drop"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(tt.args, &env{stdin: strings.NewReader(""), stdout: &stdout, stderr: &stderr})
+			if code != tt.wantCode {
+				t.Fatalf("expected exit code %d, got %d\nstderr: %s", tt.wantCode, code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("expected stdout to contain %q, got %q", tt.wantStdout, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("expected stderr to contain %q, got %q", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
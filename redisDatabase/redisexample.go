@@ -3,155 +3,79 @@ package redisDatabase
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"strconv"
-	"strings"
-	"time"
+	"redisDatabase/hybridsystem"
+	"sync"
 
 	_ "github.com/go-sql-driver/mysql"
-	"github.com/gorilla/mux"
 	"github.com/redis/go-redis/v9"
 )
 
 type App struct {
-	DB  *sql.DB
-	RDB *redis.Client
+	DB         *sql.DB
+	RDB        *redis.Client
+	Store      hybridsystem.UserStore[User]
+	Cache      *hybridsystem.Cache[User]
+	Logger     *slog.Logger
+	Timeouts   hybridsystem.TimeoutConfig
+	Validation hybridsystem.ValidationConfig
+
+	usersOnce sync.Once
+	usersRes  *hybridsystem.Resource[User, *User]
+
+	// Deprecated: handlers use the request's context; Ctx is ignored.
 	Ctx context.Context
 }
 
-type User struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+// User is the MySQL users row, shared with the hybrid system.
+type User = hybridsystem.User2
+
+// users is built on the first request and shared by all of them, cache
+// included.
+func (a *App) users() *hybridsystem.Resource[User, *User] {
+	a.usersOnce.Do(func() {
+		store := a.Store
+		if store == nil {
+			store = hybridsystem.NewMySQLUserStore(&hybridsystem.MySQLInstance1{DB: a.DB})
+		}
+		cache := a.Cache
+		if cache == nil && a.RDB != nil {
+			cache = hybridsystem.ServerCache[User](&hybridsystem.RedisBackend{Client: a.RDB}, hybridsystem.UserKeys, a.Timeouts)
+		}
+		a.usersRes = &hybridsystem.Resource[User, *User]{Name: "user", Store: store, Cache: cache, Validate: hybridsystem.UserValidator(a.Validation).Validate, Logger: a.Logger, Timeouts: a.Timeouts}
+	})
+	return a.usersRes
 }
 
-func (a *App) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
-	var users User
-	if err := json.NewDecoder(r.Body).Decode(&users); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	// Validation
-	if strings.TrimSpace(users.Name) == "" {
-		http.Error(w, "name cannot be empty", http.StatusBadRequest)
-		return
-	}
-	if !strings.Contains(users.Email, "@") || strings.TrimSpace(users.Email) == "" {
-		http.Error(w, "invalid email", http.StatusBadRequest)
-		return
-	}
-	prefix := strings.TrimSuffix(users.Email, "@gmail.com")
-	if prefix == "" {
-		http.Error(w, "Email must contains a prefix before @gmail.com ", http.StatusBadRequest)
-		return
-	}
-
-	res, err := a.DB.Exec("INSERT INTO users (name , email) VALUES (? , ?)", users.Name, users.Email)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	id, _ := res.LastInsertId()
-	users.ID = int(id)
+// CacheStats are the counters of the cache the handlers share.
+func (a *App) CacheStats() hybridsystem.CacheStats {
+	return a.users().Cache.Stats()
+}
 
-	json.NewEncoder(w).Encode(users)
+func (a *App) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	a.users().CreateWithStatus(w, r, http.StatusOK)
 }
 
 func (a *App) GetUserHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	value, err := a.RDB.Get(a.Ctx, id).Result()
-	if err == nil {
-		log.Println("cache hit")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(value))
-		return
-	}
-	log.Println("cache miss, guerying mysql... ")
-	row := a.DB.QueryRow("SELECT id ,name , email FROM users WHERE id=?", id)
-
-	var users User
-	if err := row.Scan(&users.ID, &users.Name, &users.Email); err != nil {
-		http.Error(w, "user not found", http.StatusNotFound)
-		return
-	}
-	jsonData, _ := json.Marshal(users)
-	a.RDB.Set(a.Ctx, id, jsonData, 10*time.Minute)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsonData)
+	a.users().Get(w, r)
+}
+func (a *App) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	a.users().List(w, r)
 }
 func (a *App) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
-	var users User
-	if err := json.NewDecoder(r.Body).Decode(&users); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	res, err := a.DB.Exec("UPDATE users SET name=?,email=? WHERE id=?", users.Name, users.Email, users.ID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	rows, _ := res.RowsAffected()
-	if rows == 0 {
-		http.Error(w, "user not found", http.StatusNotFound)
-		return
-	}
-	jsonData, _ := json.Marshal(users)
-	a.RDB.Set(a.Ctx, fmt.Sprint(users.ID), jsonData, 10*time.Minute)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write(jsonData)
-
+	a.users().Update(w, r)
+}
+func (a *App) PatchUserHandler(w http.ResponseWriter, r *http.Request) {
+	a.users().Patch(w, r)
 }
 func (a *App) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-	idInt, _ := strconv.Atoi(id)
-
-	res, err := a.DB.Exec("DELETE FROM users WHERE id=?", idInt)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	rows, _ := res.RowsAffected()
-	if rows == 0 {
-		http.Error(w, "user not found", http.StatusNotFound)
-		return
-	}
-	a.RDB.Del(a.Ctx, id)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("user deleted"))
+	a.users().DeleteWithMessage(w, r, "user deleted")
 }
-func Redisexample() {
-	dsn := "root:root@tcp(127.0.0.1:3306)/go_users"
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		log.Fatal(err)
-	}
-	rdb := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-		DB:   0,
-	})
-	app := &App{
-		DB:  db,
-		RDB: rdb,
-		Ctx: context.Background(),
-	}
-	r := mux.NewRouter()
-	r.HandleFunc("/users", app.CreateUserHandler).Methods("POST")
-	r.HandleFunc("/users/{id}", app.GetUserHandler).Methods("GET")
-	r.HandleFunc("/users/{id}", app.UpdateUserHandler).Methods("PUT")
-	r.HandleFunc("/users/{id}", app.DeleteUserHandler).Methods("DELETE")
-
-	log.Println("Server running on port :8080")
-	http.ListenAndServe(":8080", r)
 
+// Redisexample serves the MySQL users API with a Redis cache until the
+// process is told to stop. It is hybridsystem.Serve with the mysql preset.
+func Redisexample(cfg hybridsystem.Config, logger *slog.Logger) error {
+	cfg.Modules = hybridsystem.ModulePresets["mysql"]
+	return hybridsystem.Serve(cfg, logger)
 }
@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"redisDatabase/hybridsystem"
 	redisDatabase "redisDatabase/redisDatabase"
 	"strconv"
 	"testing"
@@ -317,3 +318,58 @@ func TestApp_DeleteUserHandler(t *testing.T) {
 		})
 	}
 }
+
+// The handlers of this package keep the responses of their first version:
+// 200 for a create and a plain text message for a delete.
+func TestLegacyHandlers_Memory(t *testing.T) {
+	app := &redisDatabase.App{Store: hybridsystem.NewMemoryUserStore()}
+	hybrid := &redisDatabase.HybridHandler{Store: hybridsystem.NewMemoryPersonStore()}
+
+	tests := []struct {
+		name        string // description of this test case
+		create      http.HandlerFunc
+		delete      http.HandlerFunc
+		key         func(body []byte) string
+		wantDeleted string
+	}{
+		{
+			name:   "mysql users",
+			create: app.CreateUserHandler,
+			delete: app.DeleteUserHandler,
+			key: func(body []byte) string {
+				var u redisDatabase.User
+				json.Unmarshal(body, &u)
+				return u.Key()
+			},
+			wantDeleted: "user deleted",
+		},
+		{
+			name:   "mongo users",
+			create: hybrid.CreateUserHandlers1,
+			delete: hybrid.DeleteuserHandler1,
+			key: func(body []byte) string {
+				var u redisDatabase.User1
+				json.Unmarshal(body, &u)
+				return u.Key()
+			},
+			wantDeleted: "user Deleted!",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.create(w, httptest.NewRequest(http.MethodPost, "/users", bytes.NewBufferString(`{"name":"Akash","email":"akash@gmail.com"}`)))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expected create to answer 200, got %d: %s", w.Code, w.Body)
+			}
+			id := tt.key(w.Body.Bytes())
+
+			r := mux.SetURLVars(httptest.NewRequest(http.MethodDelete, "/users/"+id, nil), map[string]string{"id": id})
+			w = httptest.NewRecorder()
+			tt.delete(w, r)
+			if w.Code != http.StatusOK || w.Body.String() != tt.wantDeleted {
+				t.Errorf("expected 200 %q, got %d %q", tt.wantDeleted, w.Code, w.Body)
+			}
+		})
+	}
+}
@@ -2,19 +2,14 @@ package redisDatabase
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
+	"redisDatabase/hybridsystem"
+	"sync"
 	"time"
 
-	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
 	"github.com/redis/go-redis/v9"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type RedisInstance struct {
@@ -27,163 +22,107 @@ type MongoInstance struct {
 }
 
 type HybridHandler struct {
-	Redis *RedisInstance
-	Mongo *MongoInstance
-	Ctx   context.Context
+	Redis      *RedisInstance
+	Mongo      *MongoInstance
+	Store      hybridsystem.UserStore[User1]
+	Cache      *hybridsystem.Cache[User1]
+	Logger     *slog.Logger
+	Timeouts   hybridsystem.TimeoutConfig
+	Validation hybridsystem.ValidationConfig
+
+	usersOnce sync.Once
+	usersRes  *hybridsystem.Resource[User1, *User1]
+
+	// Deprecated: handlers use the request's context; Ctx is ignored.
+	Ctx context.Context
 }
 
-type User1 struct {
-	ID    primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-	Name  string             `json:"name" bson:"name"`
-	Email string             `json:"email" bson:"email"`
+// User1 is a document in the Mongo users collection. It has the same shape
+// as a Person, only the collection differs.
+type User1 = hybridsystem.Person
+
+// MongoUserKeys namespaces the Mongo users collection apart from the MySQL
+// users table, which uses hybridsystem.UserKeys.
+var MongoUserKeys = hybridsystem.MongoUserKeys
+
+// users is built on the first request and shared by all of them, cache
+// included.
+func (h *HybridHandler) users() *hybridsystem.Resource[User1, *User1] {
+	h.usersOnce.Do(func() {
+		store := h.Store
+		if store == nil {
+			store = &hybridsystem.MongoPersonStore{Collection: h.Mongo.Users}
+		}
+		cache := h.Cache
+		if cache == nil && h.Redis != nil {
+			cache = hybridsystem.ServerCache[User1](&hybridsystem.RedisBackend{Client: h.Redis.Client}, MongoUserKeys, h.Timeouts)
+		}
+		h.usersRes = &hybridsystem.Resource[User1, *User1]{Name: "user", Store: store, Cache: cache, Validate: hybridsystem.PersonValidator(h.Validation).Validate, Logger: h.Logger, Timeouts: h.Timeouts}
+	})
+	return h.usersRes
 }
 
-func (h *HybridHandler) CreateUserHandlers1(w http.ResponseWriter, r *http.Request) {
-	var users User1
-	if err := json.NewDecoder(r.Body).Decode(&users); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	ctx, cancel := context.WithTimeout(h.Ctx, 5*time.Second)
-	defer cancel()
-
-	res, err := h.Mongo.Users.InsertOne(ctx, users)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	users.ID = res.InsertedID.(primitive.ObjectID)
-
-	jsonData, _ := json.Marshal(users)
-	h.Redis.Client.Set(h.Ctx, users.ID.Hex(), jsonData, 10*time.Minute)
+// CacheStats are the counters of the cache the handlers share.
+func (h *HybridHandler) CacheStats() hybridsystem.CacheStats {
+	return h.users().Cache.Stats()
+}
 
-	json.NewEncoder(w).Encode(users)
+func (h *HybridHandler) CreateUserHandlers1(w http.ResponseWriter, r *http.Request) {
+	h.users().CreateWithStatus(w, r, http.StatusOK)
 }
 func (h *HybridHandler) GetUserHandler1(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	value, err := h.Redis.Client.Get(h.Ctx, id).Result()
-	if err == nil {
-		log.Println("Cache hit")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write([]byte(value))
-		return
-	}
-	log.Println("cache miss, querying MongoDB...")
-	objID, _ := primitive.ObjectIDFromHex(id)
-	var users User1
-	ctx, cancel := context.WithTimeout(h.Ctx, 5*time.Second)
-	defer cancel()
-
-	err = h.Mongo.Users.FindOne(ctx, bson.M{"_id": objID}).Decode(&users)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	jsondata, _ := json.Marshal(users)
-	h.Redis.Client.Set(h.Ctx, id, jsondata, 10*time.Minute)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsondata)
+	h.users().Get(w, r)
+}
+func (h *HybridHandler) ListUsersHandler1(w http.ResponseWriter, r *http.Request) {
+	h.users().List(w, r)
 }
 func (h *HybridHandler) UpdateUserHandler1(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	var users User1
-	if err := json.NewDecoder(r.Body).Decode(&users); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	objID, _ := primitive.ObjectIDFromHex(id)
-	ctx, cancel := context.WithTimeout(h.Ctx, 5*time.Second)
-	defer cancel()
-	update := bson.M{
-		"$set": bson.M{
-			"name":  users.Name,
-			"email": users.Email,
-		},
-	}
-
-	res, err := h.Mongo.Users.UpdateOne(ctx, bson.M{"_id": objID}, update)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if res.MatchedCount == 0 {
-		http.Error(w, "user not found", http.StatusInternalServerError)
-		return
-	}
-	users.ID = objID
-	jsonData, _ := json.Marshal(users)
-	h.Redis.Client.Set(h.Ctx, id, jsonData, 10*time.Minute)
-
-	w.Header().Set("content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	h.users().Update(w, r)
+}
+func (h *HybridHandler) PatchUserHandler1(w http.ResponseWriter, r *http.Request) {
+	h.users().Patch(w, r)
 }
 func (h *HybridHandler) DeleteuserHandler1(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+	h.users().DeleteWithMessage(w, r, "user Deleted!")
+}
+func (r *RedisInstance) Close() error {
+	return r.Client.Close()
+}
 
-	objID, _ := primitive.ObjectIDFromHex(id)
-	ctx, cancel := context.WithTimeout(h.Ctx, 5*time.Second)
+func (m *MongoInstance) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
+	return m.Client.Disconnect(ctx)
+}
 
-	_, err := h.Mongo.Users.DeleteOne(ctx, bson.M{"_id": objID})
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	h.Redis.Client.Del(h.Ctx, id)
-	w.Write([]byte("user Deleted!"))
+func (r *RedisInstance) Ping(ctx context.Context) error {
+	return r.Client.Ping(ctx).Err()
 }
-func Connectredis() (*RedisInstance, error) {
-	rdb := redis.NewClient(&redis.Options{
-		Addr: os.Getenv("REDIS_ADDR"),
-		DB:   0,
-	})
-	return &RedisInstance{Client: rdb}, nil
+
+func (m *MongoInstance) Ping(ctx context.Context) error {
+	return m.Client.Ping(ctx, nil)
 }
 
-func connectMongo() (*MongoInstance, error) {
-	clientOPtions := options.Client().ApplyURI(os.Getenv("MONGO_URI"))
-	client, err := mongo.NewClient(clientOPtions)
-	if err != nil {
-		return nil, err
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	err = client.Connect(ctx)
+func Connectredis() (*RedisInstance, error) {
+	cfg, err := hybridsystem.EnvConfig()
 	if err != nil {
 		return nil, err
 	}
-	db := client.Database(os.Getenv("MONGO_DB"))
-	return &MongoInstance{
-		Client: client,
-		DB:     db,
-		Users:  db.Collection("users"),
-	}, nil
+	return connectRedis(cfg.Redis)
 }
-func CRUDoperations1() {
-	godotenv.Load()
 
-	redisInstance, err := Connectredis()
-	if err != nil {
-		log.Fatal(err)
-	}
-	mongoInstance, err := connectMongo()
-	if err != nil {
-		log.Fatal(err)
+func connectRedis(cfg hybridsystem.RedisConfig) (*RedisInstance, error) {
+	r := &RedisInstance{Client: cfg.NewClient()}
+	if err := hybridsystem.VerifyConnection("redis at "+cfg.Addr, r); err != nil {
+		r.Close()
+		return nil, err
 	}
-	handle := &HybridHandler{Mongo: mongoInstance, Redis: redisInstance, Ctx: context.Background()}
-	r := mux.NewRouter()
-
-	r.HandleFunc("/users", handle.CreateUserHandlers1).Methods("POST")
-	r.HandleFunc("/users/{id}", handle.GetUserHandler1).Methods("GET")
-	r.HandleFunc("/users/{id}", handle.UpdateUserHandler1).Methods("PUT")
-	r.HandleFunc("/users/{id}", handle.DeleteuserHandler1).Methods("DELETE")
+	return r, nil
+}
 
-	log.Println("Server running on port :8080")
-	http.ListenAndServe(":8080", r)
+// CRUDoperations1 serves the MongoDB users API with a Redis cache until the
+// process is told to stop. It is hybridsystem.Serve with the mongo preset.
+func CRUDoperations1(cfg hybridsystem.Config, logger *slog.Logger) error {
+	cfg.Modules = hybridsystem.ModulePresets["mongo"]
+	return hybridsystem.Serve(cfg, logger)
 }
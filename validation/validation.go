@@ -0,0 +1,170 @@
+// Package validation checks request bodies with rules declared per field.
+//
+//	v := validation.New[User]().
+//		Field("name", func(u *User) string { return u.Name }, validation.Required(), validation.PersonName()).
+//		Field("email", func(u *User) string { return u.Email }, validation.Required(), validation.Email())
+//	err := v.Validate(user) // nil or validation.Errors
+//
+// Every field is checked and all failures are returned together; within a
+// field, rules run in order and stop at the first failure.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// FieldError is one invalid field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors lists every invalid field of a value.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Rule checks one string value and returns a message describing what is
+// wrong with it, or "" if it is fine.
+type Rule func(value string) string
+
+// Validator holds the rules for the fields of a T.
+type Validator[T any] struct {
+	fields []field[T]
+}
+
+type field[T any] struct {
+	name  string
+	get   func(*T) string
+	rules []Rule
+}
+
+func New[T any]() *Validator[T] {
+	return &Validator[T]{}
+}
+
+// Field declares the rules for the field called name, read by get.
+func (v *Validator[T]) Field(name string, get func(*T) string, rules ...Rule) *Validator[T] {
+	v.fields = append(v.fields, field[T]{name: name, get: get, rules: rules})
+	return v
+}
+
+// Validate returns nil or the Errors of value.
+func (v *Validator[T]) Validate(value T) error {
+	var errs Errors
+	for _, f := range v.fields {
+		s := f.get(&value)
+		for _, rule := range f.rules {
+			if msg := rule(s); msg != "" {
+				errs = append(errs, FieldError{Field: f.name, Message: msg})
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Required rejects empty and whitespace-only values.
+func Required() Rule {
+	return func(value string) string {
+		if strings.TrimSpace(value) == "" {
+			return "is required"
+		}
+		return ""
+	}
+}
+
+// MinLen and MaxLen count characters, not bytes. A limit <= 0 disables the
+// rule.
+func MinLen(n int) Rule {
+	return func(value string) string {
+		if n > 0 && utf8.RuneCountInString(value) < n {
+			return fmt.Sprintf("must be at least %d characters", n)
+		}
+		return ""
+	}
+}
+
+func MaxLen(n int) Rule {
+	return func(value string) string {
+		if n > 0 && utf8.RuneCountInString(value) > n {
+			return fmt.Sprintf("must be at most %d characters", n)
+		}
+		return ""
+	}
+}
+
+// PersonName accepts names in any script: letters and combining marks,
+// separated by spaces, apostrophes, hyphens or periods, starting with a
+// letter. Digits, symbols and control characters are rejected.
+func PersonName() Rule {
+	return func(value string) string {
+		if !utf8.ValidString(value) {
+			return "is not valid UTF-8"
+		}
+		for i, r := range strings.TrimSpace(value) {
+			switch {
+			case unicode.IsLetter(r):
+			case i == 0:
+				return "must start with a letter"
+			case unicode.IsMark(r), r == ' ', r == '\'', r == '’', r == '-', r == '.':
+			default:
+				return fmt.Sprintf("must not contain %q", r)
+			}
+		}
+		return ""
+	}
+}
+
+// Email accepts a bare RFC 5322 address such as jane@example.com. Display
+// names ("Jane <jane@example.com>") and dotless domains are rejected.
+func Email() Rule {
+	return func(value string) string {
+		addr, err := mail.ParseAddress(value)
+		if err != nil || addr.Name != "" || addr.Address != value {
+			return "must be a valid email address"
+		}
+		domain := Domain(value)
+		if !strings.Contains(domain, ".") || strings.HasSuffix(domain, ".") {
+			return "must be a valid email address"
+		}
+		return ""
+	}
+}
+
+// EmailDomain only accepts addresses at one of domains, compared without
+// regard to case. No domains means any domain is allowed.
+func EmailDomain(domains ...string) Rule {
+	allowed := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		allowed[strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@"))] = true
+	}
+	return func(value string) string {
+		if len(allowed) == 0 || allowed[Domain(value)] {
+			return ""
+		}
+		return "must be an address at " + strings.Join(domains, ", ")
+	}
+}
+
+// Domain returns the lower-cased part of an address after the last @.
+func Domain(address string) string {
+	at := strings.LastIndex(address, "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(address[at+1:])
+}
@@ -0,0 +1,74 @@
+package validation_test
+
+import (
+	"errors"
+	"redisDatabase/validation"
+	"strings"
+	"testing"
+)
+
+type contact struct {
+	Name  string
+	Email string
+}
+
+func contactValidator(domains ...string) *validation.Validator[contact] {
+	return validation.New[contact]().
+		Field("name", func(c *contact) string { return c.Name }, validation.Required(), validation.MaxLen(12), validation.PersonName()).
+		Field("email", func(c *contact) string { return c.Email }, validation.Required(), validation.MaxLen(40), validation.Email(), validation.EmailDomain(domains...))
+}
+
+func TestValidator_Validate(t *testing.T) {
+	tests := []struct {
+		name       string // description of this test case
+		value      contact
+		domains    []string
+		wantFields []string
+	}{
+		{name: "valid", value: contact{Name: "Akash", Email: "akash@gmail.com"}},
+		{name: "unicode name", value: contact{Name: "Zoë O’Brien", Email: "zoe@example.org"}},
+		{name: "non-latin name", value: contact{Name: "Ана-Мария", Email: "ana@example.org"}},
+		{name: "combining accent", value: contact{Name: "José", Email: "jose@example.org"}},
+		{name: "plus addressing", value: contact{Name: "Akash", Email: "akash+news@gmail.com"}},
+		{name: "every field reported", value: contact{Name: " ", Email: ""}, wantFields: []string{"name", "email"}},
+		{name: "name with digits", value: contact{Name: "R2D2", Email: "r2@example.org"}, wantFields: []string{"name"}},
+		{name: "name starting with a hyphen", value: contact{Name: "-Ann", Email: "ann@example.org"}, wantFields: []string{"name"}},
+		{name: "name too long in characters", value: contact{Name: "Ééééééééééééé", Email: "e@example.org"}, wantFields: []string{"name"}},
+		{name: "email without prefix", value: contact{Name: "Akash", Email: "@gmail.com"}, wantFields: []string{"email"}},
+		{name: "email with display name", value: contact{Name: "Akash", Email: "Akash <akash@gmail.com>"}, wantFields: []string{"email"}},
+		{name: "email without dot in domain", value: contact{Name: "Akash", Email: "akash@localhost"}, wantFields: []string{"email"}},
+		{name: "email with two at signs", value: contact{Name: "Akash", Email: "a@b@gmail.com"}, wantFields: []string{"email"}},
+		{name: "allowed domain ignores case", value: contact{Name: "Akash", Email: "akash@GMAIL.com"}, domains: []string{"gmail.com"}},
+		{name: "domain not allowed", value: contact{Name: "Akash", Email: "akash@yahoo.com"}, domains: []string{"gmail.com", "@example.org"}, wantFields: []string{"email"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := contactValidator(tt.domains...).Validate(tt.value)
+			if len(tt.wantFields) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var errs validation.Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected validation.Errors, got %v", err)
+			}
+			if len(errs) != len(tt.wantFields) {
+				t.Fatalf("expected errors for %v, got %v", tt.wantFields, errs)
+			}
+			for i, f := range tt.wantFields {
+				if errs[i].Field != f || errs[i].Message == "" {
+					t.Errorf("expected an error for %s, got %+v", f, errs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestErrors_Error(t *testing.T) {
+	err := validation.Errors{{Field: "name", Message: "is required"}, {Field: "email", Message: "is required"}}
+	if got := err.Error(); !strings.Contains(got, "name: is required") || !strings.Contains(got, "email: is required") {
+		t.Fatalf("unexpected message %q", got)
+	}
+}